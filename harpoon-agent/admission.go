@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/soundcloud/harpoon/harpoon-agent/lib"
+)
+
+// admit checks that a container fits in the resources the agent has left,
+// and returns an error describing every shortage if it doesn't. The caller
+// must hold the api's admission lock until the container is registered, so
+// that concurrent creates can't over-commit the agent.
+func admit(config agent.ContainerConfig, r agent.HostResources) error {
+	var errs []string
+
+	if free := r.Mem.Total - min64(r.Mem.Reserved, r.Mem.Total); config.Resources.Mem > free {
+		errs = append(errs, fmt.Sprintf("memory: %d MB requested, %d MB available", config.Resources.Mem, free))
+	}
+
+	if free := r.CPU.Total - r.CPU.Reserved; config.Resources.CPU > free {
+		errs = append(errs, fmt.Sprintf("CPU: %.2f requested, %.2f available", config.Resources.CPU, free))
+	}
+
+	if free := r.Storage.Total - r.Storage.Reserved; config.Storage.TempSize() > free {
+		errs = append(errs, fmt.Sprintf("storage: %.0f MB requested, %.0f MB available", config.Storage.TempSize(), free))
+	}
+
+	volumes := map[string]struct{}{}
+	for _, vol := range r.Volumes {
+		volumes[vol] = struct{}{}
+	}
+
+	for _, source := range config.Storage.Volumes {
+		if _, ok := volumes[source]; !ok {
+			errs = append(errs, fmt.Sprintf("volume %q not available", source))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("insufficient resources: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+func min64(x, y uint64) uint64 {
+	if x < y {
+		return x
+	}
+	return y
+}
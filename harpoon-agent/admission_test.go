@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/soundcloud/harpoon/harpoon-agent/lib"
+)
+
+func TestAdmit(t *testing.T) {
+	r := agent.HostResources{
+		Mem:     agent.TotalReservedInt{Total: 1000, Reserved: 600},
+		CPU:     agent.TotalReserved{Total: 4, Reserved: 3},
+		Storage: agent.TotalReserved{Total: 100, Reserved: 0},
+		Volumes: []string{"/data"},
+	}
+
+	for i, input := range []struct {
+		config agent.ContainerConfig
+		ok     bool
+	}{
+		{
+			config: agent.ContainerConfig{Resources: agent.Resources{Mem: 400, CPU: 1}},
+			ok:     true,
+		},
+		{
+			config: agent.ContainerConfig{Resources: agent.Resources{Mem: 401, CPU: 1}},
+			ok:     false,
+		},
+		{
+			config: agent.ContainerConfig{Resources: agent.Resources{Mem: 100, CPU: 1.5}},
+			ok:     false,
+		},
+		{
+			config: agent.ContainerConfig{
+				Resources: agent.Resources{Mem: 100, CPU: 1},
+				Storage:   agent.Storage{Volumes: map[string]string{"/mnt": "/data"}},
+			},
+			ok: true,
+		},
+		{
+			config: agent.ContainerConfig{
+				Resources: agent.Resources{Mem: 100, CPU: 1},
+				Storage:   agent.Storage{Volumes: map[string]string{"/mnt": "/scratch"}},
+			},
+			ok: false,
+		},
+	} {
+		if err := admit(input.config, r); (err == nil) != input.ok {
+			t.Errorf("%d: expected ok %v, got error %v", i, input.ok, err)
+		}
+	}
+
+	// Reservations beyond the total, e.g. of containers recovered after the
+	// agent restarted with less memory, leave nothing available.
+	r.Mem.Reserved = 1200
+	if err := admit(agent.ContainerConfig{Resources: agent.Resources{Mem: 1}}, r); err == nil {
+		t.Error("expected over-reserved memory to reject containers")
+	}
+}
@@ -2,12 +2,19 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
 	"log"
 	"mime"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/bernerdschaefer/eventsource"
 	"github.com/bmizerany/pat"
@@ -19,13 +26,29 @@ type api struct {
 	http.Handler
 	*registry
 	*portDB
+	addressDB *addressDB
+	artifacts *artifactManager
 
 	containerRoot string
 	enabled       bool
 	sync.RWMutex
+
+	// heartbeatInterval is how often container event streams send
+	// heartbeats.
+	heartbeatInterval time.Duration
+
+	// admission is held from checking that a container fits until it's
+	// registered, and thereby reserves its resources.
+	admission sync.Mutex
+
+	// draining agents accept no new containers, so they can be taken out of
+	// service once the scheduler moved their containers elsewhere. drainc is
+	// closed (and replaced) whenever draining changes.
+	draining bool
+	drainc   chan struct{}
 }
 
-func newAPI(containerRoot string, r *registry, pdb *portDB) *api {
+func newAPI(containerRoot string, r *registry, pdb *portDB, adb *addressDB, am *artifactManager) *api {
 	var (
 		mux = pat.New()
 		api = &api{
@@ -33,6 +56,11 @@ func newAPI(containerRoot string, r *registry, pdb *portDB) *api {
 			containerRoot: containerRoot,
 			registry:      r,
 			portDB:        pdb,
+			addressDB:     adb,
+			artifacts:     am,
+			drainc:        make(chan struct{}),
+
+			heartbeatInterval: heartbeatInterval,
 		}
 	)
 
@@ -41,9 +69,16 @@ func newAPI(containerRoot string, r *registry, pdb *portDB) *api {
 	mux.Del("/api/v0/containers/:id", http.HandlerFunc(api.handleDestroy))
 	mux.Post("/api/v0/containers/:id/start", http.HandlerFunc(api.handleStart))
 	mux.Post("/api/v0/containers/:id/stop", http.HandlerFunc(api.handleStop))
+	mux.Post("/api/v0/containers/:id/signal", http.HandlerFunc(api.handleSignal))
+	mux.Post("/api/v0/containers/:id/exec", http.HandlerFunc(api.handleExec))
 	mux.Get("/api/v0/containers/:id/log", http.HandlerFunc(api.handleLog))
 	mux.Get("/api/v0/containers", http.HandlerFunc(api.handleList))
 	mux.Get("/api/v0/resources", http.HandlerFunc(api.handleResources))
+	mux.Get("/api/v0/artifacts", http.HandlerFunc(api.handleArtifacts))
+	mux.Get("/api/v0/tombstones", http.HandlerFunc(api.handleTombstones))
+	mux.Get("/api/v0/tombstones/:id", http.HandlerFunc(api.handleTombstone))
+	mux.Post("/api/v0/drain", http.HandlerFunc(api.handleDrain))
+	mux.Post("/api/v0/undrain", http.HandlerFunc(api.handleUndrain))
 
 	return api
 }
@@ -55,6 +90,45 @@ func (a *api) enable() {
 	a.enabled = true // TODO(pb): this is never used
 }
 
+// drainState returns whether the agent is draining, and a channel which is
+// closed when that changes.
+func (a *api) drainState() (bool, <-chan struct{}) {
+	a.RLock()
+	defer a.RUnlock()
+
+	return a.draining, a.drainc
+}
+
+// setDraining starts or stops draining the agent. No container is admitted
+// once it returns from starting to drain.
+func (a *api) setDraining(draining bool) {
+	a.admission.Lock()
+	defer a.admission.Unlock()
+
+	a.Lock()
+	defer a.Unlock()
+
+	if a.draining == draining {
+		return
+	}
+
+	a.draining = draining
+	close(a.drainc)
+	a.drainc = make(chan struct{})
+}
+
+func (a *api) handleDrain(w http.ResponseWriter, r *http.Request) {
+	a.setDraining(true)
+	log.Printf("draining")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *api) handleUndrain(w http.ResponseWriter, r *http.Request) {
+	a.setDraining(false)
+	log.Printf("undrained")
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (a *api) handleGet(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Query().Get(":id")
 
@@ -88,27 +162,164 @@ func (a *api) handleCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	container := newContainer(id, a.containerRoot, config, a.portDB)
+	var old container
+
+	if oldID := r.URL.Query().Get("replace"); oldID != "" {
+		c, ok := a.registry.get(oldID)
+		if !ok {
+			http.Error(w, "container to replace not found", http.StatusNotFound)
+			return
+		}
+
+		if c.Instance().ContainerStatus != agent.ContainerStatusRunning {
+			http.Error(w, "container to replace not running", http.StatusPreconditionFailed)
+			return
+		}
+
+		old = c
+	}
+
+	container := newContainer(id, a.containerRoot, config, a.portDB, a.addressDB, a.artifacts)
 
-	if ok := a.registry.register(container); !ok {
-		http.Error(w, "already exists", http.StatusConflict)
+	if code, err := a.admit(container, config); err != nil {
+		http.Error(w, err.Error(), code)
 		return
 	}
 
+	// Create returns as soon as the container is preparing. The container
+	// is started once it's prepared, which is reported on the event stream.
 	if err := container.Create(); err != nil {
 		log.Printf("[%s] create: %s", id, err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if err := container.Start(); err != nil {
-		log.Printf("[%s] create, start: %s", id, err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if old != nil {
+		go a.replace(container, old)
+
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("replace accepted"))
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("create accepted"))
+}
+
+// admit registers the container if it fits in the agent's remaining
+// resources, and returns the HTTP status and error to reject it with if not.
+func (a *api) admit(c container, config agent.ContainerConfig) (int, error) {
+	a.admission.Lock()
+	defer a.admission.Unlock()
+
+	if draining, _ := a.drainState(); draining {
+		return http.StatusServiceUnavailable, fmt.Errorf("agent draining")
+	}
+
+	if err := admit(config, resources(a.registry.instances())); err != nil {
+		return agent.StatusInsufficientResources, err
+	}
+
+	if ok := a.registry.register(c); !ok {
+		return http.StatusConflict, fmt.Errorf("already exists")
+	}
+
+	return 0, nil
+}
+
+// replace waits for newc to come up, and then stops and destroys oldc. If
+// newc fails to start, oldc is left untouched.
+func (a *api) replace(newc, oldc container) {
+	var (
+		newID = newc.Instance().ID
+		oldID = oldc.Instance().ID
+	)
+
+	status := awaitStatus(
+		newc,
+		agent.ContainerStatusRunning,
+		agent.ContainerStatusFailed,
+		agent.ContainerStatusFinished,
+		agent.ContainerStatusDeleted,
+	)
+	if status != agent.ContainerStatusRunning {
+		log.Printf("[%s] replace: new container %s; leaving %s untouched", newID, status, oldID)
+		return
+	}
+
+	if oldc.Instance().ContainerStatus == agent.ContainerStatusRunning {
+		if err := oldc.Stop(); err != nil {
+			log.Printf("[%s] replace: stop %s: %s", newID, oldID, err)
+			return
+		}
+	}
+
+	status = awaitStatus(
+		oldc,
+		agent.ContainerStatusFailed,
+		agent.ContainerStatusFinished,
+		agent.ContainerStatusDeleted,
+	)
+	if status == agent.ContainerStatusDeleted {
+		return // someone beat us to it
+	}
+
+	if err := oldc.Destroy(); err != nil {
+		log.Printf("[%s] replace: destroy %s: %s", newID, oldID, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	w.Write([]byte("created OK"))
+	a.registry.remove(oldID)
+
+	log.Printf("[%s] replace: %s replaced", newID, oldID)
+}
+
+// awaitStatus blocks until the container reaches one of the passed statuses,
+// and returns that status. A container which is destroyed while we wait is
+// reported as deleted.
+func awaitStatus(c container, statuses ...agent.ContainerStatus) agent.ContainerStatus {
+	want := make(map[agent.ContainerStatus]struct{}, len(statuses))
+	for _, status := range statuses {
+		want[status] = struct{}{}
+	}
+
+	statec := make(chan agent.ContainerInstance)
+	c.Subscribe(statec)
+
+	// Any state change after the subscription is sent on statec, so checking
+	// the current status here can't miss a transition.
+	status := c.Instance().ContainerStatus
+
+	for {
+		if _, ok := want[status]; ok {
+			break
+		}
+
+		instance, ok := <-statec
+		if !ok {
+			return agent.ContainerStatusDeleted
+		}
+
+		status = instance.ContainerStatus
+	}
+
+	// The container blocks on its subscribers, so keep draining statec until
+	// the unsubscribe goes through. A destroyed container closes statec and
+	// never processes the unsubscribe.
+	done := make(chan struct{})
+	go func() { c.Unsubscribe(statec); close(done) }()
+
+	for {
+		select {
+		case _, ok := <-statec:
+			if !ok {
+				return status
+			}
+
+		case <-done:
+			return status
+		}
+	}
 }
 
 func (a *api) handleStop(w http.ResponseWriter, r *http.Request) {
@@ -136,6 +347,131 @@ func (a *api) handleStop(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("stop accepted"))
 }
 
+func (a *api) handleSignal(w http.ResponseWriter, r *http.Request) {
+	var (
+		id   = r.URL.Query().Get(":id")
+		name = r.URL.Query().Get("signal")
+	)
+
+	container, ok := a.registry.get(id)
+	if !ok {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	if _, err := agent.ParseSignal(name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if container.Instance().ContainerStatus != agent.ContainerStatusRunning {
+		http.Error(w, "not running", http.StatusConflict)
+		return
+	}
+
+	if err := container.Signal(name); err != nil {
+		log.Printf("[%s] signal %s: %s", id, name, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("signal accepted"))
+}
+
+func (a *api) handleExec(w http.ResponseWriter, r *http.Request) {
+	var (
+		id  = r.URL.Query().Get(":id")
+		cmd = r.URL.Query()["cmd"]
+	)
+
+	container, ok := a.registry.get(id)
+	if !ok {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	if len(cmd) == 0 {
+		http.Error(w, "no command given", http.StatusBadRequest)
+		return
+	}
+
+	if container.Instance().ContainerStatus != agent.ContainerStatusRunning {
+		http.Error(w, "not running", http.StatusConflict)
+		return
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "exec requires a hijackable connection", http.StatusInternalServerError)
+		return
+	}
+
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		log.Printf("[%s] exec: %s", id, err)
+		return
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(rw, "HTTP/1.1 200 OK\r\nContent-Type: %s\r\n\r\n", agent.ExecContentType)
+	if err := rw.Flush(); err != nil {
+		return
+	}
+
+	log.Printf("[%s] exec: %s", id, strings.Join(cmd, " "))
+
+	// The command gets its own stdin pipe, which is closed when it exits,
+	// so waiting for it doesn't wait for the client to close stdin too.
+	stdin, stdinw, err := os.Pipe()
+	if err != nil {
+		agent.WriteExecFrame(conn, agent.ExecError, []byte(err.Error()))
+		return
+	}
+	defer stdin.Close()
+
+	go func() {
+		io.Copy(stdinw, rw.Reader)
+		stdinw.Close()
+	}()
+
+	var (
+		mu     sync.Mutex
+		stdout = execFrameWriter{conn: conn, stream: agent.ExecStdout, mu: &mu}
+		stderr = execFrameWriter{conn: conn, stream: agent.ExecStderr, mu: &mu}
+	)
+
+	status, err := container.Exec(cmd, stdin, stdout, stderr)
+	stdinw.Close()
+
+	if err != nil {
+		log.Printf("[%s] exec: %s", id, err)
+		agent.WriteExecFrame(conn, agent.ExecError, []byte(err.Error()))
+		return
+	}
+
+	agent.WriteExecFrame(conn, agent.ExecExit, []byte(strconv.Itoa(status)))
+}
+
+// execFrameWriter writes output of an exec'd command as frames of its stream
+// to the hijacked connection.
+type execFrameWriter struct {
+	conn   io.Writer
+	stream byte
+	mu     *sync.Mutex // shared by the streams of a connection
+}
+
+func (w execFrameWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := agent.WriteExecFrame(w.conn, w.stream, p); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
 func (a *api) handleStart(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Query().Get(":id")
 
@@ -182,17 +518,33 @@ func (a *api) handleDestroy(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("destroy OK"))
 }
 
-func (a *api) handleContainerStream(_ string, enc *eventsource.Encoder, stop <-chan bool) {
-	statec := make(chan agent.ContainerInstance)
+// handleContainerStream streams the state of all containers, followed by
+// their state changes. Events carry the generation of the registry as their
+// ID. A client resuming from one (lastID) receives the state of the
+// containers which changed since, instead of all of them, if the registry
+// still has these changes. Heartbeats let clients detect broken connections.
+// Changes to draining are sent as events without containers.
+func (a *api) handleContainerStream(lastID string, enc *eventsource.Encoder, stop <-chan bool) {
+	statec := make(chan stateChange)
 
-	a.registry.notify(statec)
+	generation := a.registry.notify(statec)
 	defer a.registry.stop(statec)
 
+	_, drainc := a.drainState()
+
 	instances := a.registry.instances()
+
+	containers := instances
+	if resumed, err := strconv.ParseUint(lastID, 10, 64); err == nil {
+		if changed, ok := a.registry.changes(resumed, generation); ok {
+			containers = changed
+		}
+	}
+
 	b, err := json.Marshal(
 		&agent.StateEvent{
-			Resources:  resources(instances),
-			Containers: instances,
+			Resources:  a.hostResources(instances),
+			Containers: containers,
 		},
 	)
 	if err != nil {
@@ -200,21 +552,62 @@ func (a *api) handleContainerStream(_ string, enc *eventsource.Encoder, stop <-c
 		return
 	}
 
-	if err := enc.Encode(eventsource.Event{Data: b}); err != nil {
+	if err := enc.Encode(eventsource.Event{ID: strconv.FormatUint(generation, 10), Data: b}); err != nil {
 		log.Printf("container stream: fatal error: %s", err)
 		return
 	}
 
+	heartbeat := time.NewTicker(a.heartbeatInterval)
+	defer heartbeat.Stop()
+
 	for {
 		select {
 		case <-stop:
 			return
 
-		case state := <-statec:
+		case change := <-statec:
+			generation = change.generation
+
+			b, err := json.Marshal(
+				agent.StateEvent{
+					Resources:  a.hostResources(a.registry.instances()),
+					Containers: map[string]agent.ContainerInstance{change.instance.ID: change.instance},
+				},
+			)
+			if err != nil {
+				log.Printf("container stream: fatal error: %s", err)
+				return
+			}
+
+			if err := enc.Encode(eventsource.Event{ID: strconv.FormatUint(generation, 10), Data: b}); err != nil {
+				log.Printf("container stream: non-fatal error: %s", err)
+			}
+
+		case <-drainc:
+			_, drainc = a.drainState()
+
+			// Reflects no containers, like a heartbeat, but isn't one: the
+			// resources changed.
+			b, err := json.Marshal(
+				agent.StateEvent{
+					Resources:  a.hostResources(a.registry.instances()),
+					Containers: map[string]agent.ContainerInstance{},
+				},
+			)
+			if err != nil {
+				log.Printf("container stream: fatal error: %s", err)
+				return
+			}
+
+			if err := enc.Encode(eventsource.Event{ID: strconv.FormatUint(generation, 10), Data: b}); err != nil {
+				log.Printf("container stream: non-fatal error: %s", err)
+			}
+
+		case <-heartbeat.C:
 			b, err := json.Marshal(
 				agent.StateEvent{
-					Resources:  resources(a.registry.instances()),
-					Containers: map[string]agent.ContainerInstance{state.ID: state},
+					Resources:  a.hostResources(a.registry.instances()),
+					Containers: map[string]agent.ContainerInstance{},
 				},
 			)
 			if err != nil {
@@ -222,7 +615,7 @@ func (a *api) handleContainerStream(_ string, enc *eventsource.Encoder, stop <-c
 				return
 			}
 
-			if err := enc.Encode(eventsource.Event{Data: b}); err != nil {
+			if err := enc.Encode(eventsource.Event{Type: agent.HeartbeatEvent, ID: strconv.FormatUint(generation, 10), Data: b}); err != nil {
 				log.Printf("container stream: non-fatal error: %s", err)
 			}
 		}
@@ -254,14 +647,7 @@ func isStreamAccept(accept string) bool {
 }
 
 func (a *api) handleLog(w http.ResponseWriter, r *http.Request) {
-	var (
-		id         = r.URL.Query().Get(":id")
-		rawHistory = r.URL.Query().Get("history")
-	)
-
-	if rawHistory == "" {
-		rawHistory = "10"
-	}
+	id := r.URL.Query().Get(":id")
 
 	container, ok := a.registry.get(id)
 	if !ok {
@@ -269,67 +655,238 @@ func (a *api) handleLog(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	history, err := strconv.Atoi(rawHistory)
+	q, err := parseLogQuery(r.URL.Query())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	h := container.Logs().last(history)
+	h, err := readLogHistory(filepath.Join(logRoot, id), q)
+	switch {
+	case err == nil:
+		w.Header().Set("X-Harpoon-Log-Offset", strconv.FormatInt(h.next, 10))
+
+	case os.IsNotExist(err) && !q.persistent():
+		// The logger hasn't written anything yet; the buffer has all there is.
+		h = logHistory{lines: grepLines(container.Logs().last(q.count), q.grep)}
+
+	case os.IsNotExist(err):
+		http.Error(w, "no persisted logs", http.StatusNotFound)
+		return
+
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	if isStreamAccept(r.Header.Get("Accept")) {
-		eventsource.Handler(func(_ string, enc *eventsource.Encoder, stop <-chan bool) {
-			a.streamLog(h, container.Logs(), enc, stop)
+		eventsource.Handler(func(lastID string, enc *eventsource.Encoder, stop <-chan bool) {
+			a.streamLog(lastID, h.lines, q, container.Logs(), enc, stop)
 		}).ServeHTTP(w, r)
 		return
 	}
 
-	json.NewEncoder(w).Encode(h)
+	json.NewEncoder(w).Encode(h.lines)
 }
 
-func (a *api) streamLog(history []string, current *containerLog, enc *eventsource.Encoder, stop <-chan bool) {
+// parseLogQuery reads a logQuery from the parameters of a log request.
+func parseLogQuery(v url.Values) (logQuery, error) {
+	q := logQuery{count: 10, offset: -1}
+
+	if raw := v.Get("history"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return logQuery{}, fmt.Errorf("history %q invalid", raw)
+		}
+
+		q.count = n
+	}
+
+	for param, t := range map[string]*time.Time{"since": &q.since, "until": &q.until} {
+		if raw := v.Get(param); raw != "" {
+			parsed, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return logQuery{}, fmt.Errorf("%s: %s", param, err)
+			}
+
+			*t = parsed
+		}
+	}
+
+	if raw := v.Get("offset"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return logQuery{}, fmt.Errorf("offset %q invalid", raw)
+		}
+
+		q.offset = n
+	}
+
+	if raw := v.Get("byte_offset"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n < 0 {
+			return logQuery{}, fmt.Errorf("byte_offset %q invalid", raw)
+		}
+
+		q.byteOffset = n
+	}
+
+	if raw := v.Get("grep"); raw != "" {
+		re, err := regexp.Compile(raw)
+		if err != nil {
+			return logQuery{}, fmt.Errorf("grep: %s", err)
+		}
+
+		q.grep = re
+	}
+
+	return q, nil
+}
+
+// grepLines returns the lines matching re, or all of them if re is nil.
+func grepLines(lines []string, re *regexp.Regexp) []string {
+	if re == nil {
+		return lines
+	}
+
+	matched := []string{}
+
+	for _, line := range lines {
+		if re.MatchString(line) {
+			matched = append(matched, line)
+		}
+	}
+
+	return matched
+}
+
+// streamLog streams the history, followed by the live tail of the log. Lines
+// of the tail carry their sequence number as the event ID. A client resuming
+// from one (lastID) receives the lines following it instead of the history.
+func (a *api) streamLog(lastID string, history []string, q logQuery, current *containerLog, enc *eventsource.Encoder, stop <-chan bool) {
 	// logs.Notify does not write to blocked channels, so the channel has to
 	// be buffered. The capacity is chosen so that a burst of log lines won't
 	// immediately result in a loss of data during large surge of incoming log
-	// lines.
+	// lines. Notifications only wake the stream up: lines are retrieved by
+	// their sequence number, so that dropped notifications don't lose any.
 	linec := make(chan string, logBufferSize)
 
-	current.notify(linec)
+	seq := current.notify(linec)
 	defer current.stop(linec)
 
-	if len(history) > 0 {
+	resumed, err := strconv.ParseUint(lastID, 10, 64)
+	if err == nil {
+		if resumed <= seq {
+			seq = resumed
+		} else {
+			seq = 0 // numbered before the agent restarted
+		}
+	} else if len(history) > 0 {
 		b, err := json.Marshal(history)
 		if err != nil {
 			log.Printf("log stream: fatal error: %s", err)
 			return
 		}
 
-		if err = enc.Encode(eventsource.Event{Data: b}); err != nil {
+		// The history ends about where the buffer does, so a client resuming
+		// from it continues with the lines that follow.
+		if err = enc.Encode(eventsource.Event{ID: strconv.FormatUint(seq, 10), Data: b}); err != nil {
 			log.Printf("log stream: non-fatal error: %s", err)
 		}
 	}
 
+	if !q.until.IsZero() {
+		return // the live tail is past the end of the query
+	}
+
+	catchUp := func() error {
+		entries := current.after(seq)
+		if len(entries) == 0 {
+			return nil
+		}
+
+		if first := entries[0].seq; first > seq+1 {
+			b, err := json.Marshal(agent.LogGap{From: seq + 1, To: first - 1})
+			if err != nil {
+				return err
+			}
+
+			incLogLostLines(int(first - 1 - seq))
+
+			if err := enc.Encode(eventsource.Event{Type: agent.LogGapEvent, ID: strconv.FormatUint(first-1, 10), Data: b}); err != nil {
+				log.Printf("log stream: non-fatal error: %s", err)
+			}
+		}
+
+		for _, entry := range entries {
+			seq = entry.seq
+
+			if q.grep != nil && !q.grep.MatchString(entry.line) {
+				continue
+			}
+
+			b, err := json.Marshal([]string{entry.line})
+			if err != nil {
+				return err
+			}
+
+			if err := enc.Encode(eventsource.Event{ID: strconv.FormatUint(seq, 10), Data: b}); err != nil {
+				log.Printf("log stream: non-fatal error: %s", err)
+			}
+		}
+
+		return nil
+	}
+
+	if err := catchUp(); err != nil {
+		log.Printf("log stream: fatal error: %s", err)
+		return
+	}
+
 	for {
 		select {
 		case <-stop:
 			return
 
-		case line := <-linec:
-			b, err := json.Marshal([]string{line})
-			if err != nil {
+		case <-linec:
+			if err := catchUp(); err != nil {
 				log.Printf("log stream: fatal error: %s", err)
 				return
 			}
-
-			if err = enc.Encode(eventsource.Event{Data: b}); err != nil {
-				log.Printf("log stream: non-fatal error: %s", err)
-			}
 		}
 	}
 }
 
 func (a *api) handleResources(w http.ResponseWriter, r *http.Request) {
-	json.NewEncoder(w).Encode(resources(a.registry.instances()))
+	json.NewEncoder(w).Encode(a.hostResources(a.registry.instances()))
+}
+
+func (a *api) handleArtifacts(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(a.artifacts.list())
+}
+
+func (a *api) handleTombstones(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(a.registry.tombstoneList())
+}
+
+func (a *api) handleTombstone(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get(":id")
+
+	for _, tombstone := range a.registry.tombstoneList() {
+		if tombstone.ID == id {
+			json.NewEncoder(w).Encode(tombstone)
+			return
+		}
+	}
+
+	http.Error(w, "", http.StatusNotFound)
+}
+
+// hostResources returns the resources of the agent, as reported to clients.
+func (a *api) hostResources(instances map[string]agent.ContainerInstance) agent.HostResources {
+	r := resources(instances)
+	r.Draining, _ = a.drainState()
+	return r
 }
 
 func resources(instances map[string]agent.ContainerInstance) agent.HostResources {
@@ -339,27 +896,42 @@ func resources(instances map[string]agent.ContainerInstance) agent.HostResources
 		volumes = append(volumes, vol)
 	}
 
+	labels := make(map[string]string, len(configuredLabels))
+
+	for k, v := range configuredLabels {
+		labels[k] = v
+	}
+
 	var (
-		reservedMem uint64
-		reservedCPU float64
+		reservedMem     uint64
+		reservedCPU     float64
+		reservedStorage float64
 	)
 
 	for _, instance := range instances {
 		if instance.ContainerStatus != agent.ContainerStatusDeleted {
 			reservedMem += instance.ContainerConfig.Resources.Mem
 			reservedCPU += instance.ContainerConfig.Resources.CPU
+			reservedStorage += instance.ContainerConfig.Storage.TempSize()
 		}
 	}
 
+	// Over-committed resources are made available in full, so that the
+	// scheduler places containers the agent admits.
 	return agent.HostResources{
 		Mem: agent.TotalReservedInt{
-			Total:    uint64(agentMem),
+			Total:    uint64(float64(agentMem) * overcommitMem),
 			Reserved: reservedMem,
 		},
 		CPU: agent.TotalReserved{
-			Total:    float64(agentCPU),
+			Total:    agentCPU * overcommitCPU,
 			Reserved: reservedCPU,
 		},
+		Storage: agent.TotalReserved{
+			Total:    float64(agentStorage) * overcommitStorage,
+			Reserved: reservedStorage,
+		},
 		Volumes: volumes,
+		Labels:  labels,
 	}
 }
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net"
@@ -10,6 +12,7 @@ import (
 	"net/http/httptest"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -30,7 +33,7 @@ func TestContainerList(t *testing.T) {
 	var (
 		registry = newRegistry()
 		pdb      = newPortDB(lowTestPort, highTestPort)
-		api      = newAPI(fixtureContainerRoot, registry, pdb)
+		api      = newAPI(fixtureContainerRoot, registry, pdb, nil, nil)
 		server   = httptest.NewServer(api)
 	)
 
@@ -71,6 +74,8 @@ func TestContainerList(t *testing.T) {
 				CPU: 2,
 			},
 		},
+		nil,
+		nil,
 		nil)
 
 	registry.m["123"] = cont
@@ -111,13 +116,192 @@ func TestContainerList(t *testing.T) {
 	}
 }
 
+func TestCreateRejectsOverCommitment(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+
+	defer func(mem int64, cpu float64) { agentMem, agentCPU = mem, cpu }(agentMem, agentCPU)
+	agentMem, agentCPU = 1000, 2
+
+	var (
+		registry = newRegistry()
+		pdb      = newPortDB(lowTestPort, highTestPort)
+		api      = newAPI(fixtureContainerRoot, registry, pdb, nil, nil)
+		server   = httptest.NewServer(api)
+	)
+	defer pdb.exit()
+	defer server.Close()
+
+	c := newFakeContainer("a")
+	c.ContainerInstance.ContainerConfig.Resources = agent.Resources{Mem: 800, CPU: 1}
+	registry.register(c)
+
+	client, err := agent.NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := agent.ContainerConfig{Resources: agent.Resources{Mem: 300, CPU: 1}}
+
+	if want, have := agent.ErrInsufficientResources, client.Put("b", config); want != have {
+		t.Errorf("want %v, have %v", want, have)
+	}
+
+	if _, ok := registry.get("b"); ok {
+		t.Error("rejected container registered")
+	}
+}
+
+func TestDrainRejectsCreate(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+
+	var (
+		registry = newRegistry()
+		pdb      = newPortDB(lowTestPort, highTestPort)
+		api      = newAPI(fixtureContainerRoot, registry, pdb, nil, nil)
+		server   = httptest.NewServer(api)
+	)
+	defer pdb.exit()
+	defer server.Close()
+
+	client, err := agent.NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, stopper, err := client.Events()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stopper.Stop()
+
+	if state := <-events; state.Resources.Draining {
+		t.Fatal("agent draining initially")
+	}
+
+	if err := client.Drain(); err != nil {
+		t.Fatal(err)
+	}
+
+	if state := <-events; !state.Resources.Draining || len(state.Containers) != 0 {
+		t.Errorf("expected draining event without containers, got %v", state)
+	}
+
+	if want, have := agent.ErrAgentDraining, client.Put("a", agent.ContainerConfig{}); want != have {
+		t.Errorf("want %v, have %v", want, have)
+	}
+
+	if _, ok := registry.get("a"); ok {
+		t.Error("rejected container registered")
+	}
+
+	if err := client.Undrain(); err != nil {
+		t.Fatal(err)
+	}
+
+	resources, err := client.Resources()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resources.Draining {
+		t.Error("agent still draining")
+	}
+}
+
+func TestContainerStreamResumes(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+
+	var (
+		registry = newRegistry()
+		pdb      = newPortDB(lowTestPort, highTestPort)
+		api      = newAPI(fixtureContainerRoot, registry, pdb, nil, nil)
+	)
+	defer pdb.exit()
+
+	api.heartbeatInterval = 10 * time.Millisecond
+
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	registry.register(newFakeContainer("a"))
+	registry.register(newFakeContainer("b"))
+
+	stream := func(lastID string) (*eventsource.Decoder, io.Closer) {
+		req, _ := http.NewRequest("GET", server.URL+"/api/v0/containers", nil)
+		req.Header.Set("Accept", "text/event-stream")
+		req.Header.Set("Last-Event-ID", lastID)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return eventsource.NewDecoder(resp.Body), resp.Body
+	}
+
+	read := func(dec *eventsource.Decoder) (eventsource.Event, agent.StateEvent) {
+		var (
+			ev    eventsource.Event
+			state agent.StateEvent
+		)
+
+		if err := dec.Decode(&ev); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := json.Unmarshal(ev.Data, &state); err != nil {
+			t.Fatal(err)
+		}
+
+		return ev, state
+	}
+
+	dec, body := stream("")
+	ev, state := read(dec)
+	body.Close()
+
+	if len(state.Containers) != 2 {
+		t.Fatalf("expected snapshot of 2 containers, got %d", len(state.Containers))
+	}
+
+	generation, err := strconv.ParseUint(ev.ID, 10, 64)
+	if err != nil {
+		t.Fatalf("expected generation as event ID, got %q", ev.ID)
+	}
+
+	registry.statec <- agent.ContainerInstance{ID: "a", ContainerStatus: agent.ContainerStatusFinished}
+
+	dec, body = stream(ev.ID)
+	defer body.Close()
+
+	ev, state = read(dec)
+	if want, have := strconv.FormatUint(generation+1, 10), ev.ID; want != have {
+		t.Errorf("expected resumed event ID %s, got %s", want, have)
+	}
+
+	if len(state.Containers) != 1 || state.Containers["a"].ContainerStatus != agent.ContainerStatusFinished {
+		t.Errorf("expected only the change to a, got %v", state.Containers)
+	}
+
+	if ev, _ = read(dec); ev.Type != agent.HeartbeatEvent || ev.ID != strconv.FormatUint(generation+1, 10) {
+		t.Errorf("expected heartbeat at generation %d, got %s event %s", generation+1, ev.Type, ev.ID)
+	}
+
+	dec, body = stream("1") // long gone
+	defer body.Close()
+
+	if _, state = read(dec); len(state.Containers) != 2 {
+		t.Errorf("expected snapshot of 2 containers, got %d", len(state.Containers))
+	}
+}
+
 func TestLogAPICanTailLogs(t *testing.T) {
 	log.SetOutput(ioutil.Discard)
 
 	var (
 		registry = newRegistry()
 		pdb      = newPortDB(lowTestPort, highTestPort)
-		api      = newAPI(fixtureContainerRoot, registry, pdb)
+		api      = newAPI(fixtureContainerRoot, registry, pdb, nil, nil)
 		server   = httptest.NewServer(api)
 	)
 	defer pdb.exit()
@@ -192,7 +376,7 @@ func TestLogAPILogTailIncludesHistory(t *testing.T) {
 	var (
 		registry = newRegistry()
 		pdb      = newPortDB(lowTestPort, highTestPort)
-		api      = newAPI(fixtureContainerRoot, registry, pdb)
+		api      = newAPI(fixtureContainerRoot, registry, pdb, nil, nil)
 		server   = httptest.NewServer(api)
 	)
 	defer pdb.exit()
@@ -269,7 +453,7 @@ func TestLogAPICanRetrieveLastLines(t *testing.T) {
 	var (
 		registry = newRegistry()
 		pdb      = newPortDB(lowTestPort, highTestPort)
-		api      = newAPI(fixtureContainerRoot, registry, pdb)
+		api      = newAPI(fixtureContainerRoot, registry, pdb, nil, nil)
 		server   = httptest.NewServer(api)
 	)
 	defer pdb.exit()
@@ -306,6 +490,96 @@ func TestLogAPICanRetrieveLastLines(t *testing.T) {
 	ExpectArraysEqual(t, logLines, []string{"container[123] m1", "container[123] m2"})
 }
 
+func TestLogAPIRejectsInvalidQueries(t *testing.T) {
+	var (
+		registry = newRegistry()
+		pdb      = newPortDB(lowTestPort, highTestPort)
+		api      = newAPI(fixtureContainerRoot, registry, pdb, nil, nil)
+		server   = httptest.NewServer(api)
+	)
+	defer pdb.exit()
+	defer server.Close()
+
+	registry.register(newFakeContainer("123"))
+
+	for i, query := range []string{
+		"history=-1",
+		"history=x",
+		"offset=-1",
+		"byte_offset=-1",
+		"since=yesterday",
+		"grep=(",
+	} {
+		resp, err := http.Get(server.URL + "/api/v0/containers/123/log?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if want, have := http.StatusBadRequest, resp.StatusCode; want != have {
+			t.Errorf("%d: %s: want HTTP %d, have %d", i, query, want, have)
+		}
+	}
+}
+
+func TestLogStreamResumes(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+
+	var (
+		registry = newRegistry()
+		pdb      = newPortDB(lowTestPort, highTestPort)
+		api      = newAPI(fixtureContainerRoot, registry, pdb, nil, nil)
+		server   = httptest.NewServer(api)
+	)
+	defer pdb.exit()
+	defer server.Close()
+
+	c := newFakeContainer("123")
+	c.logs = newContainerLog(3)
+	registry.register(c)
+
+	for _, line := range []string{"m1", "m2", "m3", "m4", "m5"} {
+		c.Logs().addLogLine(line)
+	}
+
+	for lastID, expected := range map[string][]eventsource.Event{
+		"3": {
+			{Type: "message", ID: "4", Data: []byte(`["m4"]`)},
+			{Type: "message", ID: "5", Data: []byte(`["m5"]`)},
+		},
+		"1": {
+			{Type: agent.LogGapEvent, ID: "2", Data: []byte(`{"from":2,"to":2}`)},
+			{Type: "message", ID: "3", Data: []byte(`["m3"]`)},
+			{Type: "message", ID: "4", Data: []byte(`["m4"]`)},
+			{Type: "message", ID: "5", Data: []byte(`["m5"]`)},
+		},
+	} {
+		req, _ := http.NewRequest("GET", server.URL+"/api/v0/containers/123/log", nil)
+		req.Header.Set("Accept", "text/event-stream")
+		req.Header.Set("Last-Event-ID", lastID)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dec := eventsource.NewDecoder(resp.Body)
+
+		for _, want := range expected {
+			var have eventsource.Event
+			if err := dec.Decode(&have); err != nil {
+				t.Fatalf("after %s: %s", lastID, err)
+			}
+
+			if have.Type != want.Type || have.ID != want.ID || string(have.Data) != string(want.Data) {
+				t.Errorf("after %s: expected event %s %s %s, got %s %s %s", lastID, want.Type, want.ID, want.Data, have.Type, have.ID, have.Data)
+			}
+		}
+
+		resp.Body.Close()
+	}
+}
+
 func TestMessagesGetWrittenToLogs(t *testing.T) {
 	log.SetOutput(ioutil.Discard)
 
@@ -411,3 +685,94 @@ func validateEvent(expected agent.HostResources, have agent.StateEvent, containe
 
 	return nil
 }
+
+func TestReplace(t *testing.T) {
+	var (
+		registry = newRegistry()
+		pdb      = newPortDB(lowTestPort, highTestPort)
+		api      = newAPI(fixtureContainerRoot, registry, pdb, nil, nil)
+		oldc     = newFakeContainer("old")
+		newc     = newFakeContainer("new")
+	)
+	defer pdb.exit()
+
+	registry.register(oldc)
+	registry.register(newc)
+
+	api.replace(newc, oldc)
+
+	if _, ok := registry.get("old"); ok {
+		t.Errorf("old container still registered after replace")
+	}
+
+	if want, have := agent.ContainerStatusDeleted, oldc.Instance().ContainerStatus; want != have {
+		t.Errorf("want old container %q, have %q", want, have)
+	}
+
+	if want, have := agent.ContainerStatusRunning, newc.Instance().ContainerStatus; want != have {
+		t.Errorf("want new container %q, have %q", want, have)
+	}
+}
+
+func TestReplaceFailedLeavesOldContainer(t *testing.T) {
+	var (
+		registry = newRegistry()
+		pdb      = newPortDB(lowTestPort, highTestPort)
+		api      = newAPI(fixtureContainerRoot, registry, pdb, nil, nil)
+		oldc     = newFakeContainer("old")
+		newc     = newFakeContainer("new")
+	)
+	defer pdb.exit()
+
+	newc.ContainerStatus = agent.ContainerStatusFailed
+
+	registry.register(oldc)
+	registry.register(newc)
+
+	api.replace(newc, oldc)
+
+	if _, ok := registry.get("old"); !ok {
+		t.Errorf("old container removed after failed replace")
+	}
+
+	if want, have := agent.ContainerStatusRunning, oldc.Instance().ContainerStatus; want != have {
+		t.Errorf("want old container %q, have %q", want, have)
+	}
+}
+
+func TestExec(t *testing.T) {
+	var (
+		registry = newRegistry()
+		pdb      = newPortDB(lowTestPort, highTestPort)
+		api      = newAPI(fixtureContainerRoot, registry, pdb, nil, nil)
+		server   = httptest.NewServer(api)
+		client   = agent.MustNewClient(server.URL)
+	)
+	defer pdb.exit()
+	defer server.Close()
+
+	registry.register(newFakeContainer("123"))
+
+	var stdout, stderr bytes.Buffer
+
+	status, err := client.Exec("123", []string{"echo", "hello"}, strings.NewReader("input"), &stdout, &stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := 2, status; want != have {
+		t.Errorf("want exit status %d, have %d", want, have)
+	}
+
+	if want, have := "echo hello\n", stdout.String(); want != have {
+		t.Errorf("want stdout %q, have %q", want, have)
+	}
+
+	if want, have := "input", stderr.String(); want != have {
+		t.Errorf("want stderr %q, have %q", want, have)
+	}
+
+	if _, err := client.Exec("456", []string{"true"}, nil, &stdout, &stderr); err != agent.ErrContainerNotExist {
+		t.Errorf("want %v, have %v", agent.ErrContainerNotExist, err)
+	}
+}
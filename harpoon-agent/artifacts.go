@@ -0,0 +1,631 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/soundcloud/harpoon/harpoon-agent/lib"
+)
+
+const (
+	artifactIndexFile = "index.json"
+	artifactTempDir   = ".tmp"
+	artifactDigestDir = ".sha256" // hostnames can't start with a dot
+)
+
+// artifactManager downloads, verifies, and caches the artifacts containers
+// run from.
+//
+// Concurrent requests for the same artifact share a single download.
+// Artifacts are extracted into a temporary directory, and only renamed into
+// place once they're complete and verified, so a directory in the cache is
+// always a complete artifact. Every artifact keeps track of the containers
+// using it, and artifacts which are no longer used are garbage collected,
+// least recently used first, whenever the cache exceeds its maximum size.
+type artifactManager struct {
+	root    string
+	maxSize int64 // bytes
+
+	acquirec  chan acquireArtifactCmd
+	claimc    chan acquireArtifactCmd
+	releasec  chan releaseArtifactCmd
+	listc     chan []agent.Artifact
+	enablegcc chan struct{}
+	downloadc chan downloadResult
+	exitc     chan chan struct{}
+}
+
+type acquireArtifactCmd struct {
+	id     string // container ID
+	url    string
+	digest string
+	pathc  chan string
+	errc   chan error
+}
+
+type releaseArtifactCmd struct {
+	id   string // container ID
+	errc chan error
+}
+
+type downloadResult struct {
+	path string
+	size int64
+	err  error
+}
+
+// cachedArtifact is the manager's view of an artifact.
+type cachedArtifact struct {
+	agent.Artifact
+	refs        map[string]struct{} // container IDs
+	downloading bool
+	waiters     []acquireArtifactCmd
+}
+
+// newArtifactManager returns a manager for the artifacts cached under root.
+// Artifacts recorded in the index are reused, but garbage collection only
+// starts after enableGC is called, i.e. once all containers have been
+// recovered and claimed their artifacts.
+func newArtifactManager(root string, maxSize int64) (*artifactManager, error) {
+	tmp := filepath.Join(root, artifactTempDir)
+
+	// Anything in the temporary directory was left behind by an interrupted
+	// download or removal.
+	if err := os.RemoveAll(tmp); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		return nil, err
+	}
+
+	artifacts, err := loadArtifactIndex(filepath.Join(root, artifactIndexFile))
+	if err != nil {
+		return nil, err
+	}
+
+	m := &artifactManager{
+		root:    root,
+		maxSize: maxSize,
+
+		acquirec:  make(chan acquireArtifactCmd),
+		claimc:    make(chan acquireArtifactCmd),
+		releasec:  make(chan releaseArtifactCmd),
+		listc:     make(chan []agent.Artifact),
+		enablegcc: make(chan struct{}),
+		downloadc: make(chan downloadResult),
+		exitc:     make(chan chan struct{}),
+	}
+
+	go m.loop(artifacts)
+
+	return m, nil
+}
+
+// acquire returns the path of the artifact at url, downloading it if
+// necessary, and records that the container uses it. If digest is set, the
+// artifact is verified against it, and cached by digest rather than by URL.
+func (m *artifactManager) acquire(id, url, digest string) (string, error) {
+	cmd := acquireArtifactCmd{
+		id:     id,
+		url:    url,
+		digest: digest,
+		pathc:  make(chan string, 1),
+		errc:   make(chan error, 1),
+	}
+	m.acquirec <- cmd
+
+	select {
+	case path := <-cmd.pathc:
+		return path, nil
+	case err := <-cmd.errc:
+		return "", err
+	}
+}
+
+// claim records that a recovered container uses the artifact at url. It
+// never downloads, but adopts an artifact missing from the index if it's
+// already on disk, as it was in use before the agent restarted.
+func (m *artifactManager) claim(id, url, digest string) error {
+	cmd := acquireArtifactCmd{
+		id:     id,
+		url:    url,
+		digest: digest,
+		pathc:  make(chan string, 1),
+		errc:   make(chan error, 1),
+	}
+	m.claimc <- cmd
+
+	select {
+	case <-cmd.pathc:
+		return nil
+	case err := <-cmd.errc:
+		return err
+	}
+}
+
+// release records that the container no longer uses any artifact.
+func (m *artifactManager) release(id string) {
+	cmd := releaseArtifactCmd{id: id, errc: make(chan error)}
+	m.releasec <- cmd
+	<-cmd.errc
+}
+
+// list returns all cached artifacts, sorted by path.
+func (m *artifactManager) list() []agent.Artifact {
+	return <-m.listc
+}
+
+// enableGC allows unused artifacts to be garbage collected.
+func (m *artifactManager) enableGC() {
+	m.enablegcc <- struct{}{}
+}
+
+func (m *artifactManager) exit() {
+	exitc := make(chan struct{})
+	m.exitc <- exitc
+	<-exitc
+}
+
+func (m *artifactManager) loop(artifacts map[string]*cachedArtifact) {
+	gc := false
+
+	for {
+		select {
+		case cmd := <-m.acquirec:
+			path, compression, err := m.artifactPath(cmd.url, cmd.digest)
+			if err != nil {
+				cmd.errc <- err
+				continue
+			}
+
+			if a, ok := artifacts[path]; ok {
+				if a.downloading {
+					a.waiters = append(a.waiters, cmd)
+					continue
+				}
+
+				a.use(cmd.id)
+				cmd.pathc <- path
+				m.save(artifacts)
+				continue
+			}
+
+			artifacts[path] = &cachedArtifact{
+				Artifact: agent.Artifact{
+					URL:    cmd.url,
+					SHA256: strings.ToLower(cmd.digest),
+					Path:   path,
+				},
+				refs:        map[string]struct{}{},
+				downloading: true,
+				waiters:     []acquireArtifactCmd{cmd},
+			}
+
+			go m.download(path, compression, cmd.url, strings.ToLower(cmd.digest))
+
+		case cmd := <-m.claimc:
+			path, _, err := m.artifactPath(cmd.url, cmd.digest)
+			if err != nil {
+				cmd.errc <- err
+				continue
+			}
+
+			a, ok := artifacts[path]
+			if !ok {
+				size, err := dirSize(path)
+				if err != nil {
+					cmd.errc <- err
+					continue
+				}
+
+				a = &cachedArtifact{
+					Artifact: agent.Artifact{
+						URL:    cmd.url,
+						SHA256: strings.ToLower(cmd.digest),
+						Path:   path,
+						Size:   size,
+					},
+					refs: map[string]struct{}{},
+				}
+				artifacts[path] = a
+			}
+
+			if a.downloading {
+				a.waiters = append(a.waiters, cmd)
+				continue
+			}
+
+			a.use(cmd.id)
+			cmd.pathc <- path
+			m.save(artifacts)
+
+		case res := <-m.downloadc:
+			a := artifacts[res.path]
+			a.downloading = false
+
+			if res.err != nil {
+				incArtifactDownloadFailure(1)
+				log.Printf("artifact %s: %s", a.URL, res.err)
+				delete(artifacts, res.path)
+
+				for _, cmd := range a.waiters {
+					cmd.errc <- res.err
+				}
+				continue
+			}
+
+			a.Size = res.size
+
+			for _, cmd := range a.waiters {
+				a.use(cmd.id)
+				cmd.pathc <- res.path
+			}
+			a.waiters = nil
+
+			if gc {
+				m.collect(artifacts)
+			}
+			m.save(artifacts)
+
+		case cmd := <-m.releasec:
+			for _, a := range artifacts {
+				delete(a.refs, cmd.id)
+			}
+
+			if gc {
+				m.collect(artifacts)
+			}
+			m.save(artifacts)
+			close(cmd.errc)
+
+		case m.listc <- listArtifacts(artifacts):
+
+		case <-m.enablegcc:
+			gc = true
+			m.collect(artifacts)
+			m.save(artifacts)
+
+		case exitc := <-m.exitc:
+			close(exitc)
+			return
+		}
+	}
+}
+
+// artifactPath returns where the artifact is cached, and how it's compressed.
+// Artifacts with a digest are cached by digest, so they're shared between
+// URLs, and never confused with an unverified download of the same URL.
+func (m *artifactManager) artifactPath(url, digest string) (string, string, error) {
+	path, compression, err := getArtifactDetails(m.root, url)
+	if err != nil {
+		return "", "", err
+	}
+
+	if digest != "" {
+		path = filepath.Join(m.root, artifactDigestDir, strings.ToLower(digest))
+	}
+
+	return path, compression, nil
+}
+
+func (a *cachedArtifact) use(id string) {
+	a.refs[id] = struct{}{}
+	a.LastUsed = time.Now()
+}
+
+// download fetches the artifact into a temporary directory, and renames it
+// into place once it's complete.
+func (m *artifactManager) download(path, compression, url, digest string) {
+	incArtifactDownload(1)
+
+	size, err := func() (int64, error) {
+		tmp, size, err := fetchArtifact(filepath.Join(m.root, artifactTempDir), url, compression, digest)
+		if err != nil {
+			return 0, err
+		}
+
+		// Whatever is at path isn't in the index, so it can't be trusted.
+		if err := m.remove(path); err != nil {
+			os.RemoveAll(tmp)
+			return 0, err
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			os.RemoveAll(tmp)
+			return 0, err
+		}
+
+		if err := os.Rename(tmp, path); err != nil {
+			os.RemoveAll(tmp)
+			return 0, err
+		}
+
+		return size, nil
+	}()
+
+	m.downloadc <- downloadResult{path: path, size: size, err: err}
+}
+
+// collect removes unused artifacts, least recently used first, until the
+// cache fits into its maximum size.
+func (m *artifactManager) collect(artifacts map[string]*cachedArtifact) {
+	var (
+		total  int64
+		unused = []*cachedArtifact{}
+	)
+
+	for _, a := range artifacts {
+		total += a.Size
+
+		if !a.downloading && len(a.refs) == 0 {
+			unused = append(unused, a)
+		}
+	}
+
+	sort.Sort(byLastUsed(unused))
+
+	for _, a := range unused {
+		if total <= m.maxSize {
+			return
+		}
+
+		if err := m.remove(a.Path); err != nil {
+			log.Printf("artifact %s: garbage collection: %s", a.URL, err)
+			continue
+		}
+
+		delete(artifacts, a.Path)
+		total -= a.Size
+		incArtifactCollected(1)
+
+		if debug {
+			log.Printf("artifact %s: garbage collected %s", a.URL, a.Path)
+		}
+	}
+}
+
+// remove atomically moves the directory at path out of the cache, and deletes
+// it in the background.
+func (m *artifactManager) remove(path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+
+	trash, err := ioutil.TempDir(filepath.Join(m.root, artifactTempDir), "remove-")
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(path, filepath.Join(trash, "artifact")); err != nil {
+		os.Remove(trash)
+		return err
+	}
+
+	go os.RemoveAll(trash)
+
+	return nil
+}
+
+// save writes the index of complete artifacts. Failures are logged, as the
+// only consequence is that artifacts are downloaded again after a restart.
+func (m *artifactManager) save(artifacts map[string]*cachedArtifact) {
+	index := map[string]agent.Artifact{}
+
+	for path, a := range artifacts {
+		if !a.downloading {
+			index[path] = a.Artifact
+		}
+	}
+
+	buf, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		log.Printf("artifact index: %s", err)
+		return
+	}
+
+	f, err := ioutil.TempFile(filepath.Join(m.root, artifactTempDir), "index-")
+	if err != nil {
+		log.Printf("artifact index: %s", err)
+		return
+	}
+
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		log.Printf("artifact index: %s", err)
+		return
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		log.Printf("artifact index: %s", err)
+		return
+	}
+
+	if err := os.Rename(f.Name(), filepath.Join(m.root, artifactIndexFile)); err != nil {
+		os.Remove(f.Name())
+		log.Printf("artifact index: %s", err)
+	}
+}
+
+// loadArtifactIndex reads the index written by save. Artifacts which are no
+// longer on disk are dropped. Container references aren't persisted; they're
+// restored as containers are recovered.
+func loadArtifactIndex(filename string) (map[string]*cachedArtifact, error) {
+	artifacts := map[string]*cachedArtifact{}
+
+	buf, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return artifacts, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var index map[string]agent.Artifact
+	if err := json.Unmarshal(buf, &index); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+
+	for path, a := range index {
+		if _, err := os.Stat(path); err != nil {
+			log.Printf("artifact %s: dropping from index: %s", a.URL, err)
+			continue
+		}
+
+		a.Containers = nil
+		artifacts[path] = &cachedArtifact{
+			Artifact: a,
+			refs:     map[string]struct{}{},
+		}
+	}
+
+	return artifacts, nil
+}
+
+func listArtifacts(artifacts map[string]*cachedArtifact) []agent.Artifact {
+	list := []agent.Artifact{}
+
+	for _, a := range artifacts {
+		if a.downloading {
+			continue
+		}
+
+		artifact := a.Artifact
+		artifact.Containers = []string{}
+		for id := range a.refs {
+			artifact.Containers = append(artifact.Containers, id)
+		}
+		sort.Strings(artifact.Containers)
+
+		list = append(list, artifact)
+	}
+
+	sort.Sort(byPath(list))
+
+	return list
+}
+
+// fetchArtifact downloads and extracts the artifact into a new temporary
+// directory in tmp. It returns the directory and the size of the extracted
+// artifact. If digest is set, the artifact is downloaded into a temporary file
+// and verified against it first, so unverified artifacts are never extracted.
+func fetchArtifact(tmp, artifactURL, compression, digest string) (string, int64, error) {
+	resp, err := http.Get(artifactURL)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("fetch %s: HTTP %d", artifactURL, resp.StatusCode)
+	}
+
+	var body io.Reader = resp.Body
+
+	if digest != "" {
+		f, err := verifyArtifact(tmp, resp.Body, digest)
+		if err != nil {
+			return "", 0, fmt.Errorf("fetch %s: %s", artifactURL, err)
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+
+		body = f
+	}
+
+	dir, err := ioutil.TempDir(tmp, "download-")
+	if err != nil {
+		return "", 0, err
+	}
+
+	if err := os.Chmod(dir, 0755); err != nil {
+		os.RemoveAll(dir)
+		return "", 0, err
+	}
+
+	if err := extractArtifact(body, dir, compression); err != nil {
+		return "", 0, err
+	}
+
+	size, err := dirSize(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", 0, err
+	}
+
+	return dir, size, nil
+}
+
+// verifyArtifact copies the artifact into a temporary file in tmp, and checks
+// its SHA256 against digest. It returns the file, rewound for extraction.
+func verifyArtifact(tmp string, r io.Reader, digest string) (*os.File, error) {
+	f, err := ioutil.TempFile(tmp, "download-")
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+
+	if _, err := io.Copy(io.MultiWriter(f, hash), r); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != digest {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("SHA256 mismatch: want %s, have %s", digest, sum)
+	}
+
+	if _, err := f.Seek(0, 0); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func dirSize(path string) (int64, error) {
+	var size int64
+
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+
+		return nil
+	})
+
+	return size, err
+}
+
+type byLastUsed []*cachedArtifact
+
+func (a byLastUsed) Len() int           { return len(a) }
+func (a byLastUsed) Less(i, j int) bool { return a[i].LastUsed.Before(a[j].LastUsed) }
+func (a byLastUsed) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+type byPath []agent.Artifact
+
+func (a byPath) Len() int           { return len(a) }
+func (a byPath) Less(i, j int) bool { return a[i].Path < a[j].Path }
+func (a byPath) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestArtifactManagerSingleFlight(t *testing.T) {
+	var (
+		requests int32
+		release  = make(chan struct{})
+		artifact = testArtifact(t, "hello", 10)
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		<-release
+		w.Write(artifact)
+	}))
+	defer server.Close()
+
+	m, root := testArtifactManager(t, 1<<20)
+	defer os.RemoveAll(root)
+	defer m.exit()
+
+	var (
+		wg    sync.WaitGroup
+		paths = make([]string, 3)
+		errs  = make([]error, 3)
+	)
+
+	for i := range paths {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			paths[i], errs[i] = m.acquire(strconv.Itoa(i), server.URL+"/foo.tar", "")
+		}(i)
+	}
+
+	close(release)
+	wg.Wait()
+
+	for i := range paths {
+		if errs[i] != nil {
+			t.Fatalf("%d: %s", i, errs[i])
+		}
+
+		if want, have := filepath.Join(root, strings.TrimPrefix(server.URL, "http://"), "foo"), paths[i]; want != have {
+			t.Errorf("%d: want %s, have %s", i, want, have)
+		}
+	}
+
+	if want, have := int32(1), atomic.LoadInt32(&requests); want != have {
+		t.Errorf("want %d download(s), have %d", want, have)
+	}
+
+	if _, err := os.Stat(filepath.Join(paths[0], "hello")); err != nil {
+		t.Errorf("artifact not extracted: %s", err)
+	}
+
+	artifacts := m.list()
+	if want, have := 1, len(artifacts); want != have {
+		t.Fatalf("want %d artifact(s), have %d", want, have)
+	}
+
+	if want, have := 3, len(artifacts[0].Containers); want != have {
+		t.Errorf("want %d container(s), have %d", want, have)
+	}
+
+	if want, have := int64(10), artifacts[0].Size; want != have {
+		t.Errorf("want size %d, have %d", want, have)
+	}
+}
+
+func TestArtifactManagerDigestMismatch(t *testing.T) {
+	artifact := testArtifact(t, "hello", 10)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(artifact)
+	}))
+	defer server.Close()
+
+	m, root := testArtifactManager(t, 1<<20)
+	defer os.RemoveAll(root)
+	defer m.exit()
+
+	wrong := sha256.Sum256([]byte("something else"))
+
+	if _, err := m.acquire("a", server.URL+"/foo.tar", hex.EncodeToString(wrong[:])); err == nil {
+		t.Fatal("expected digest mismatch, got none")
+	}
+
+	if want, have := 0, len(m.list()); want != have {
+		t.Errorf("want %d artifact(s), have %d", want, have)
+	}
+
+	if _, err := os.Stat(filepath.Join(root, artifactDigestDir, hex.EncodeToString(wrong[:]))); !os.IsNotExist(err) {
+		t.Errorf("want no artifact directory after digest mismatch, have %v", err)
+	}
+
+	right := sha256.Sum256(artifact)
+
+	path, err := m.acquire("a", server.URL+"/foo.tar", hex.EncodeToString(right[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := filepath.Join(root, artifactDigestDir, hex.EncodeToString(right[:])), path; want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
+
+func TestFetchArtifactVerifiesBeforeExtracting(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("not a tarball"))
+	}))
+	defer server.Close()
+
+	tmp, err := ioutil.TempDir("", "harpoon-agent-fetch-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	wrong := sha256.Sum256([]byte("something else"))
+
+	_, _, err = fetchArtifact(tmp, server.URL+"/foo.tar", "", hex.EncodeToString(wrong[:]))
+	if err == nil || !strings.Contains(err.Error(), "SHA256 mismatch") {
+		t.Fatalf("want digest mismatch, have %v", err)
+	}
+
+	infos, err := ioutil.ReadDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := 0, len(infos); want != have {
+		t.Errorf("want %d file(s) left behind, have %d", want, have)
+	}
+}
+
+func TestArtifactManagerGC(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(testArtifact(t, "file", 100))
+	}))
+	defer server.Close()
+
+	m, root := testArtifactManager(t, 250)
+	defer os.RemoveAll(root)
+	defer m.exit()
+
+	var paths []string
+	for _, id := range []string{"a", "b", "c"} {
+		path, err := m.acquire(id, server.URL+"/"+id+".tar", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	m.release("c")
+	m.release("a")
+
+	// Nothing is collected before recovery has finished.
+	if want, have := 3, len(m.list()); want != have {
+		t.Fatalf("want %d artifact(s), have %d", want, have)
+	}
+
+	m.enableGC()
+
+	// a and c are unused, but a was used least recently, and collecting it
+	// is enough to fit into the cache.
+	artifacts := m.list()
+	if want, have := 2, len(artifacts); want != have {
+		t.Fatalf("want %d artifact(s), have %d", want, have)
+	}
+
+	for _, a := range artifacts {
+		if a.Path == paths[0] {
+			t.Errorf("least recently used artifact %s not collected", a.Path)
+		}
+	}
+
+	if _, err := os.Stat(paths[0]); !os.IsNotExist(err) {
+		t.Errorf("want %s removed, have %v", paths[0], err)
+	}
+}
+
+func TestArtifactManagerIndex(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(testArtifact(t, "file", 10))
+	}))
+	defer server.Close()
+
+	m, root := testArtifactManager(t, 1<<20)
+	defer os.RemoveAll(root)
+
+	path, err := m.acquire("a", server.URL+"/foo.tar", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.exit()
+
+	m, err = newArtifactManager(root, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.exit()
+
+	artifacts := m.list()
+	if want, have := 1, len(artifacts); want != have {
+		t.Fatalf("want %d artifact(s), have %d", want, have)
+	}
+
+	if want, have := path, artifacts[0].Path; want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+
+	if want, have := 0, len(artifacts[0].Containers); want != have {
+		t.Errorf("want %d container(s) before recovery, have %d", want, have)
+	}
+
+	server.Close() // claims must not download
+
+	if err := m.claim("a", server.URL+"/foo.tar", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := []string{"a"}, m.list()[0].Containers; len(have) != 1 || want[0] != have[0] {
+		t.Errorf("want containers %v, have %v", want, have)
+	}
+}
+
+func testArtifactManager(t *testing.T, maxSize int64) (*artifactManager, string) {
+	root, err := ioutil.TempDir("", "harpoon-agent-artifacts-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := newArtifactManager(root, maxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m, root
+}
+
+// testArtifact returns a tarball containing a single file of the given size.
+func testArtifact(t *testing.T, name string, size int) []byte {
+	var (
+		buf bytes.Buffer
+		w   = tar.NewWriter(&buf)
+	)
+
+	if err := w.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(size)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.Write(bytes.Repeat([]byte{'x'}, size)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
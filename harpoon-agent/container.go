@@ -5,7 +5,7 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"net/http"
+	"net"
 	"net/url"
 	"os"
 	"os/exec"
@@ -14,6 +14,7 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/soundcloud/harpoon/harpoon-agent/lib"
 )
@@ -26,6 +27,8 @@ type container interface {
 	Destroy() error
 	Start() error
 	Stop() error
+	Signal(name string) error
+	Exec(args []string, stdin io.Reader, stdout, stderr io.Writer) (int, error)
 	Subscribe(ch chan<- agent.ContainerInstance)
 	Unsubscribe(ch chan<- agent.ContainerInstance)
 	Logs() *containerLog
@@ -33,6 +36,16 @@ type container interface {
 	Exit()
 }
 
+// containerSupervisor is the part of the supervisor a container talks to
+// once its process is started.
+type containerSupervisor interface {
+	Stop(grace time.Duration)
+	Signal(name string)
+	Subscribe(c chan<- agent.ContainerProcessState)
+	Unsubscribe(c chan<- agent.ContainerProcessState)
+	Exit() error
+}
+
 const (
 	maxContainerIDLength       = 256 // TODO(pb): enforce this limit at creation-time
 	containerLogRingBufferSize = 10000
@@ -43,11 +56,24 @@ type realContainer struct {
 
 	containerRoot string
 	portDB        *portDB
+	addressDB     *addressDB
+	artifacts     *artifactManager
 	logs          *containerLog
 
-	supervisor      *supervisor
+	supervisor      containerSupervisor
 	containerStatec chan agent.ContainerProcessState
 
+	healthChecker *healthChecker
+	healthc       <-chan agent.ContainerHealth // of the healthChecker, if any
+
+	preparedc chan error
+
+	// startupc fires when the startup grace period expires, until the
+	// container is up, and healthy if it has health checks. startupErr is
+	// reported as the failure of a container killed for missing it.
+	startupc   <-chan time.Time
+	startupErr string
+
 	subscribers map[chan<- agent.ContainerInstance]struct{}
 
 	actionc chan actionRequest
@@ -58,18 +84,27 @@ type realContainer struct {
 }
 
 // Satisfaction guaranteed.
-var _ container = &realContainer{}
+var (
+	_ container           = &realContainer{}
+	_ containerSupervisor = &supervisor{}
+)
 
-func newContainer(id string, containerRoot string, config agent.ContainerConfig, pdb *portDB) *realContainer {
+func newContainer(id string, containerRoot string, config agent.ContainerConfig, pdb *portDB, adb *addressDB, am *artifactManager) *realContainer {
 	c := &realContainer{
 		ContainerInstance: agent.ContainerInstance{
 			ID:              id,
 			ContainerStatus: agent.ContainerStatusCreated,
 			ContainerConfig: config,
+			ContainerHealth: agent.ContainerHealth{
+				HealthStatus: agent.HealthStatusUnknown,
+				Since:        time.Now(),
+			},
 		},
 
 		containerRoot: containerRoot,
 		portDB:        pdb,
+		addressDB:     adb,
+		artifacts:     am,
 		logs:          newContainerLog(containerLogRingBufferSize),
 
 		subscribers: map[chan<- agent.ContainerInstance]struct{}{},
@@ -78,6 +113,7 @@ func newContainer(id string, containerRoot string, config agent.ContainerConfig,
 		subc:            make(chan chan<- agent.ContainerInstance),
 		unsubc:          make(chan chan<- agent.ContainerInstance),
 		containerStatec: make(chan agent.ContainerProcessState),
+		preparedc:       make(chan error, 1), // prepare mustn't block if the loop has exited
 		quitc:           make(chan chan struct{}),
 	}
 
@@ -130,6 +166,26 @@ func (c *realContainer) Stop() error {
 	return <-req.res
 }
 
+func (c *realContainer) Signal(name string) error {
+	req := actionRequest{
+		action: containerSignal,
+		signal: name,
+		res:    make(chan error),
+	}
+	c.actionc <- req
+	return <-req.res
+}
+
+// Exec runs a command in the running container, and returns its exit status.
+// It blocks until the command exits, so it doesn't go through the loop.
+func (c *realContainer) Exec(args []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	if status := c.Instance().ContainerStatus; status != agent.ContainerStatusRunning {
+		return -1, fmt.Errorf("can't exec in container with status %s", status)
+	}
+
+	return execContainer(filepath.Join(c.containerRoot, c.ID), args, stdin, stdout, stderr)
+}
+
 func (c *realContainer) Subscribe(ch chan<- agent.ContainerInstance) {
 	c.subc <- ch
 }
@@ -174,6 +230,9 @@ func (c *realContainer) loop() {
 				incContainerStop(1)
 				req.res <- c.stop()
 
+			case containerSignal:
+				req.res <- c.signal(req.signal)
+
 			default:
 				panic(fmt.Sprintf("unknown action %q", req.action))
 			}
@@ -181,18 +240,50 @@ func (c *realContainer) loop() {
 		case ch := <-c.subc:
 			c.subscribers[ch] = struct{}{}
 
+		case err := <-c.preparedc:
+			if err != nil {
+				incContainerCreateFailure(1)
+				log.Printf("[%s] create: %s", c.ID, err)
+				c.fail(err)
+				continue
+			}
+
+			c.updateStatus(agent.ContainerStatusCreated)
+
+			incContainerStart(1)
+			if err := c.start(); err != nil {
+				incContainerStartFailure(1)
+				log.Printf("[%s] create, start: %s", c.ID, err)
+				c.fail(err)
+			}
+
 		case state := <-c.containerStatec:
+			if !state.Up && !state.Restarting && c.startupErr != "" {
+				state.Err = c.startupErr
+			}
+
 			c.ContainerInstance.ContainerProcessState = state
 			if state.Up {
+				if len(c.ContainerConfig.HealthChecks) == 0 {
+					c.startupc = nil // started
+				}
+
+				c.startHealthChecks()
 				c.updateStatus(agent.ContainerStatusRunning)
+				continue
 			}
 
-			if state.Up || state.Restarting {
+			// Health checks are meaningless while the process is down, even if
+			// it's going to be restarted.
+			c.stopHealthChecks()
+
+			if state.Restarting {
 				continue
 			}
 
 			// The container is down and will not be restarted; begin teardown and
 			// update state.
+			c.startupc = nil
 			c.supervisor.Unsubscribe(c.containerStatec)
 
 			if state.Err != "" {
@@ -205,10 +296,26 @@ func (c *realContainer) loop() {
 
 			c.supervisor.Exit()
 
+		case health := <-c.healthc:
+			if health.HealthStatus == agent.HealthStatusHealthy {
+				c.startupc = nil // started
+			}
+
+			c.ContainerInstance.ContainerHealth = health
+			c.broadcast()
+
+		case <-c.startupc:
+			c.startupc = nil
+			c.startupErr = fmt.Sprintf("startup timeout: not started within %s", c.ContainerConfig.Grace.Startup)
+			incContainerStartupTimeout(1)
+			log.Printf("[%s] %s, killing", c.ID, c.startupErr)
+			c.supervisor.Stop(0)
+
 		case ch := <-c.unsubc:
 			delete(c.subscribers, ch)
 
 		case quitc := <-c.quitc:
+			c.stopHealthChecks()
 			close(quitc)
 			return
 		}
@@ -219,18 +326,28 @@ func (c *realContainer) loop() {
 func (c *realContainer) Recover() error {
 	var (
 		rundir = filepath.Join(c.containerRoot, c.ID)
-		logdir = filepath.Join("/srv/harpoon/log/", c.ID)
+		logdir = filepath.Join(logRoot, c.ID)
 	)
 
 	if err := c.validateConfig(); err != nil {
 		return err
 	}
 
-	err := c.portDB.claimPorts(c.ContainerConfig.Ports)
+	err := c.portDB.claimPorts(c.hostPorts())
 	if err != nil {
 		return err
 	}
 
+	if c.ContainerConfig.Network == agent.NetworkPrivate {
+		if err := c.addressDB.claim(c.ContainerConfig.Address); err != nil {
+			return err
+		}
+
+		if err := mapPorts(c.ContainerConfig.Address, c.ContainerConfig.HostPorts, c.ContainerConfig.Ports); err != nil {
+			return err
+		}
+	}
+
 	logPipe, err := startLogger(c.ID, logdir)
 	if err != nil {
 		return err
@@ -238,7 +355,8 @@ func (c *realContainer) Recover() error {
 	// ensure we don't hold on to the logger
 	defer logPipe.Close()
 
-	c.supervisor = newSupervisor(c.ID, rundir)
+	s := newSupervisor(c.ID, rundir)
+	c.supervisor = s
 
 	_, err = os.Stat(filepath.Join(rundir, "control"))
 	if err == syscall.ENOENT || err == syscall.ENOTDIR {
@@ -246,8 +364,14 @@ func (c *realContainer) Recover() error {
 	}
 	if err == nil {
 		exitedc := make(chan error, 1)
-		c.supervisor.attach(exitedc)
-		c.supervisor.Subscribe(c.containerStatec)
+		s.attach(exitedc)
+		s.Subscribe(c.containerStatec)
+	}
+
+	// The container doesn't depend on the cache to run, but its artifact
+	// mustn't be garbage collected.
+	if err := c.artifacts.claim(c.ID, c.ContainerConfig.ArtifactURL, c.ContainerConfig.ArtifactSHA256); err != nil {
+		log.Printf("[%s] claim artifact: %s", c.ID, err)
 	}
 
 	return nil
@@ -262,7 +386,7 @@ func (c *realContainer) Exit() {
 func (c *realContainer) create() error {
 	var (
 		rundir = filepath.Join(c.containerRoot, c.ID)
-		logdir = filepath.Join("/srv/harpoon/log/", c.ID)
+		logdir = filepath.Join(logRoot, c.ID)
 
 		agentJSONPath     = filepath.Join(rundir, "agent.json")
 		rootfsSymlinkPath = filepath.Join(rundir, "rootfs")
@@ -309,8 +433,22 @@ func (c *realContainer) create() error {
 	if debug {
 		log.Printf("agent file written to: %s", agentJSONPath)
 	}
-	// TODO(pb): it's a problem that this is blocking
-	rootfs, err := c.fetchArtifact()
+
+	// Fetching the artifact may take a long time, so it's done outside of
+	// the loop. The container is started once it's prepared.
+	c.updateStatus(agent.ContainerStatusPreparing)
+
+	go func(id, artifactURL, digest string) {
+		c.preparedc <- c.prepare(id, artifactURL, digest, rootfsSymlinkPath, logdir, logSymlinkPath)
+	}(c.ID, c.ContainerConfig.ArtifactURL, c.ContainerConfig.ArtifactSHA256)
+
+	return nil
+}
+
+// prepare fetches the container's artifact, and links it and the log
+// directory into the container's rundir. It's called outside of the loop.
+func (c *realContainer) prepare(id, artifactURL, digest, rootfsSymlinkPath, logdir, logSymlinkPath string) error {
+	rootfs, err := c.artifacts.acquire(id, artifactURL, digest)
 	if err != nil {
 		return fmt.Errorf("fetch: %s", err)
 	}
@@ -324,8 +462,9 @@ func (c *realContainer) create() error {
 	}
 
 	if debug {
-		log.Printf("artifact successfully retrieved and unpacked")
+		log.Printf("[%s] artifact successfully retrieved and unpacked", id)
 	}
+
 	return nil
 }
 
@@ -334,25 +473,28 @@ func (c *realContainer) validateConfig() error {
 		c.ContainerConfig.Env = map[string]string{}
 	}
 
+	if c.ContainerConfig.Network == agent.NetworkPrivate && c.addressDB == nil {
+		return fmt.Errorf("private networks not enabled on this agent")
+	}
+
 	for _, source := range c.ContainerConfig.Storage.Volumes {
 		if _, ok := configuredVolumes[source]; !ok {
 			return fmt.Errorf("container depends on missing volume %q", source)
 		}
 	}
 
-	for dest, size := range c.ContainerConfig.Storage.Temp {
-		if size != -1 {
-			return fmt.Errorf("cannot make tmpfs %q %dMB: sized tmpfs storage not yet supported", dest, size)
-		}
-	}
-
 	return nil
 }
 
 // assignPorts assigns any automatic ports, updating the config's port and
-// environment maps.
+// environment maps. Containers with private networks also get an address,
+// and a dynamic host port for each of their ports.
 func (c *realContainer) assignPorts() error {
-	if err := c.portDB.acquirePorts(c.ContainerConfig.Ports); err != nil {
+	if c.ContainerConfig.Network == agent.NetworkPrivate {
+		if err := c.assignHostPorts(); err != nil {
+			return err
+		}
+	} else if err := c.portDB.acquirePorts(c.ContainerConfig.Ports); err != nil {
 		return err
 	}
 	for name, port := range c.ContainerConfig.Ports {
@@ -362,66 +504,88 @@ func (c *realContainer) assignPorts() error {
 	return nil
 }
 
-func (c *realContainer) destroy() error {
-	var (
-		rundir = filepath.Join(c.containerRoot, c.ID)
-	)
-
-	switch c.ContainerInstance.ContainerStatus {
-	default:
-	case agent.ContainerStatusRunning:
-		return fmt.Errorf("can't destroy container in status %s", c.ContainerInstance.ContainerStatus)
+func (c *realContainer) assignHostPorts() error {
+	addr, err := c.addressDB.acquire()
+	if err != nil {
+		return err
 	}
 
-	c.updateStatus(agent.ContainerStatusDeleted)
-
-	c.portDB.releasePorts(c.ContainerConfig.Ports)
+	hostPorts := map[string]uint16{}
+	for name := range c.ContainerConfig.Ports {
+		hostPorts[name] = 0
+	}
 
-	err := os.RemoveAll(rundir)
-	if err != nil {
+	if err := c.portDB.acquirePorts(hostPorts); err != nil {
+		c.addressDB.release(addr)
 		return err
 	}
 
-	for subc := range c.subscribers {
-		close(subc)
+	// Automatic ports are the same inside and outside of the container.
+	for name, port := range c.ContainerConfig.Ports {
+		if port == 0 {
+			c.ContainerConfig.Ports[name] = hostPorts[name]
+		}
 	}
 
-	c.subscribers = map[chan<- agent.ContainerInstance]struct{}{}
+	if err := mapPorts(addr, hostPorts, c.ContainerConfig.Ports); err != nil {
+		unmapPorts(addr, hostPorts, c.ContainerConfig.Ports)
+		c.portDB.releasePorts(hostPorts)
+		c.addressDB.release(addr)
+		return err
+	}
+
+	c.ContainerConfig.HostPorts = hostPorts
+	c.ContainerConfig.Address = addr
 
 	return nil
 }
 
-func (c *realContainer) fetchArtifact() (string, error) {
+// hostPorts returns the ports the container occupies on the host.
+func (c *realContainer) hostPorts() map[string]uint16 {
+	if c.ContainerConfig.Network == agent.NetworkPrivate {
+		return c.ContainerConfig.HostPorts
+	}
+
+	return c.ContainerConfig.Ports
+}
+
+func (c *realContainer) destroy() error {
 	var (
-		artifactURL                            = c.ContainerConfig.ArtifactURL
-		artifactPath, artifactCompression, err = getArtifactDetails(artifactURL)
+		rundir = filepath.Join(c.containerRoot, c.ID)
 	)
 
-	if err != nil {
-		return "", err
+	switch c.ContainerInstance.ContainerStatus {
+	default:
+	case agent.ContainerStatusPreparing, agent.ContainerStatusRunning:
+		return fmt.Errorf("can't destroy container in status %s", c.ContainerInstance.ContainerStatus)
 	}
 
-	log.Printf("fetching url %s to %s", artifactURL, artifactPath)
+	c.updateStatus(agent.ContainerStatusDeleted)
 
-	if _, err := os.Stat(artifactPath); err == nil {
-		return artifactPath, nil
-	}
+	c.portDB.releasePorts(c.hostPorts())
+
+	if c.ContainerConfig.Network == agent.NetworkPrivate {
+		if err := unmapPorts(c.ContainerConfig.Address, c.ContainerConfig.HostPorts, c.ContainerConfig.Ports); err != nil {
+			log.Printf("[%s] unmap ports: %s", c.ID, err)
+		}
 
-	if err := os.MkdirAll(artifactPath, 0755); err != nil {
-		return "", err
+		c.addressDB.release(c.ContainerConfig.Address)
 	}
 
-	resp, err := http.Get(artifactURL)
+	err := os.RemoveAll(rundir)
 	if err != nil {
-		return "", err
+		return err
 	}
-	defer resp.Body.Close()
 
-	if err := extractArtifact(resp.Body, artifactPath, artifactCompression); err != nil {
-		return "", err
+	c.artifacts.release(c.ID)
+
+	for subc := range c.subscribers {
+		close(subc)
 	}
 
-	return artifactPath, nil
+	c.subscribers = map[chan<- agent.ContainerInstance]struct{}{}
+
+	return nil
 }
 
 func (c *realContainer) start() error {
@@ -433,7 +597,7 @@ func (c *realContainer) start() error {
 
 	var (
 		rundir = path.Join(c.containerRoot, c.ID)
-		logdir = filepath.Join("/srv/harpoon/log/", c.ID)
+		logdir = filepath.Join(logRoot, c.ID)
 	)
 
 	supervisorLog, err := os.Create(path.Join(rundir, "supervisor.log"))
@@ -454,14 +618,27 @@ func (c *realContainer) start() error {
 
 	s := newSupervisor(c.ID, rundir)
 
-	if err := s.Start(c.ContainerConfig, logPipe, supervisorLog); err != nil {
+	if err := s.Start(c.ContainerConfig, c.addressDB, logPipe, supervisorLog); err != nil {
 		return err
 	}
 
+	c.supervise(s)
+
+	return nil
+}
+
+// supervise follows the state of the container's freshly started supervisor.
+// A container is started once it's up, or healthy if it has health checks.
+// If that takes longer than the startup grace period, it's killed.
+func (c *realContainer) supervise(s containerSupervisor) {
 	s.Subscribe(c.containerStatec)
 	c.supervisor = s
 
-	return nil
+	c.startupErr = ""
+	c.startupc = nil
+	if d := c.ContainerConfig.Grace.Startup.Duration; d > 0 {
+		c.startupc = time.After(d)
+	}
 }
 
 func (c *realContainer) stop() error {
@@ -471,19 +648,79 @@ func (c *realContainer) stop() error {
 	case agent.ContainerStatusRunning:
 	}
 
+	c.startupc = nil // stopping isn't failing to start
 	c.supervisor.Stop(c.ContainerConfig.Grace.Shutdown.Duration)
 
 	return nil
 }
 
+func (c *realContainer) signal(name string) error {
+	switch c.ContainerInstance.ContainerStatus {
+	default:
+		return fmt.Errorf("can't signal container with status %s", c.ContainerInstance.ContainerStatus)
+	case agent.ContainerStatusRunning:
+	}
+
+	if _, err := agent.ParseSignal(name); err != nil {
+		return err
+	}
+
+	c.supervisor.Signal(name)
+
+	return nil
+}
+
 func (c *realContainer) updateStatus(status agent.ContainerStatus) {
 	c.ContainerInstance.ContainerStatus = status
+	c.broadcast()
+}
+
+// fail marks the container as failed, because it couldn't be prepared or
+// started.
+func (c *realContainer) fail(err error) {
+	c.ContainerInstance.ContainerProcessState.Err = err.Error()
+	c.updateStatus(agent.ContainerStatusFailed)
+}
 
+func (c *realContainer) broadcast() {
 	for subc := range c.subscribers {
 		subc <- c.ContainerInstance
 	}
 }
 
+// startHealthChecks starts probing the container, unless it has no health
+// checks or they're already being executed.
+func (c *realContainer) startHealthChecks() {
+	if c.healthChecker != nil || len(c.ContainerConfig.HealthChecks) == 0 {
+		return
+	}
+
+	// Containers with private networks are probed on their own address, as
+	// their host ports aren't mapped for connections to the loopback address.
+	host := "127.0.0.1"
+	if c.ContainerConfig.Network == agent.NetworkPrivate {
+		if ip, _, err := net.ParseCIDR(c.ContainerConfig.Address); err == nil {
+			host = ip.String()
+		}
+	}
+
+	c.healthChecker = newHealthChecker(c.ContainerConfig.HealthChecks, host, c.ContainerConfig.Ports)
+	c.healthc = c.healthChecker.healthc
+}
+
+// stopHealthChecks stops probing the container, and resets its health.
+func (c *realContainer) stopHealthChecks() {
+	if c.healthChecker == nil {
+		return
+	}
+
+	c.healthChecker.stop()
+	c.healthChecker, c.healthc = nil, nil
+
+	c.ContainerInstance.ContainerHealth.HealthStatus = agent.HealthStatusUnknown
+	c.ContainerInstance.ContainerHealth.Since = time.Now()
+}
+
 type containerAction string
 
 const (
@@ -491,10 +728,12 @@ const (
 	containerDestroy                 = "destroy"
 	containerStart                   = "start"
 	containerStop                    = "stop"
+	containerSignal                  = "signal"
 )
 
 type actionRequest struct {
 	action containerAction
+	signal string // containerSignal only
 	res    chan error
 }
 
@@ -515,7 +754,7 @@ func extractArtifact(src io.Reader, dst string, compression string) (err error)
 	return nil
 }
 
-func getArtifactDetails(artifactURL string) (string, string, error) {
+func getArtifactDetails(root, artifactURL string) (string, string, error) {
 	parsed, err := url.Parse(artifactURL)
 	if err != nil {
 		return "", "", fmt.Errorf("unable to parse url: %s", err)
@@ -523,7 +762,7 @@ func getArtifactDetails(artifactURL string) (string, string, error) {
 
 	path := func(suffix string) string {
 		return filepath.Join(
-			"/srv/harpoon/artifacts",
+			root,
 			parsed.Host,
 			strings.TrimSuffix(parsed.Path, suffix),
 		)
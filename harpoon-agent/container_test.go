@@ -1,7 +1,12 @@
 package main
 
 import (
+	"net"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/soundcloud/harpoon/harpoon-agent/lib"
 )
 
 func TestValidArtifactURLs(t *testing.T) {
@@ -29,7 +34,7 @@ func TestValidArtifactURLs(t *testing.T) {
 	}
 
 	for _, test := range validArtifactTestCases {
-		path, compression, err := getArtifactDetails(test.url)
+		path, compression, err := getArtifactDetails("/srv/harpoon/artifacts", test.url)
 		if path != test.expectedPath {
 			t.Errorf("artifact url %q: path %q does not equal expected path %q", test.url, path, test.expectedPath)
 		}
@@ -46,7 +51,7 @@ func TestInvalidArtifactURLs(t *testing.T) {
 	invalidArtifactURLs := []string{"692734hjlk,mnasdf7o689734", "http://foo/bar.unknowncompresson"}
 
 	for _, artifactURL := range invalidArtifactURLs {
-		path, compression, err := getArtifactDetails(artifactURL)
+		path, compression, err := getArtifactDetails("/srv/harpoon/artifacts", artifactURL)
 		if path != "" {
 			t.Errorf("artifact url %q: expected no path, but got %q", artifactURL, path)
 		}
@@ -58,3 +63,186 @@ func TestInvalidArtifactURLs(t *testing.T) {
 		}
 	}
 }
+
+func TestStartupTimeout(t *testing.T) {
+	c, s, updatec := newSupervisedContainer(agent.ContainerConfig{
+		Grace: agent.Grace{Startup: agent.JSONDuration{Duration: 50 * time.Millisecond}},
+	})
+	defer c.Exit()
+
+	// The container never comes up, so it's killed once the grace period
+	// expires, and reported as failed to start.
+
+	select {
+	case grace := <-s.stopc:
+		if grace != 0 {
+			t.Errorf("want container killed, have stop with grace %s", grace)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for container to be killed")
+	}
+
+	c.containerStatec <- agent.ContainerProcessState{Up: false}
+
+	instance := waitForStatus(t, updatec, agent.ContainerStatusFailed)
+
+	if want, have := "startup timeout", instance.Err; !strings.HasPrefix(have, want) {
+		t.Errorf("want error starting with %q, have %q", want, have)
+	}
+}
+
+func TestStartupWaitsForHealth(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	var (
+		healthy   = uint16(ln.Addr().(*net.TCPAddr).Port)
+		unhealthy = closedPort(t)
+	)
+
+	for i, input := range []struct {
+		port   uint16
+		killed bool
+	}{
+		{healthy, false},
+		{unhealthy, true},
+	} {
+		c, s, _ := newSupervisedContainer(agent.ContainerConfig{
+			Ports:        map[string]uint16{"http": input.port},
+			HealthChecks: []agent.HealthCheck{{Protocol: agent.ProtocolTCP, Port: "http"}},
+			Grace:        agent.Grace{Startup: agent.JSONDuration{Duration: 50 * time.Millisecond}},
+		})
+
+		// Being up isn't enough for a container with health checks.
+		c.containerStatec <- agent.ContainerProcessState{Up: true}
+
+		var killed bool
+		select {
+		case <-s.stopc:
+			killed = true
+		case <-time.After(200 * time.Millisecond):
+		}
+
+		if want, have := input.killed, killed; want != have {
+			t.Errorf("%d: want killed %v, have %v", i, want, have)
+		}
+
+		c.Exit()
+	}
+}
+
+func TestStopDuringStartup(t *testing.T) {
+	c, s, updatec := newSupervisedContainer(agent.ContainerConfig{
+		Ports:        map[string]uint16{"http": closedPort(t)},
+		HealthChecks: []agent.HealthCheck{{Protocol: agent.ProtocolTCP, Port: "http"}},
+		Grace: agent.Grace{
+			Startup:  agent.JSONDuration{Duration: 50 * time.Millisecond},
+			Shutdown: agent.JSONDuration{Duration: time.Second},
+		},
+	})
+	defer c.Exit()
+
+	// The container is up, but never healthy, and stopped before the startup
+	// grace period expires.
+	c.containerStatec <- agent.ContainerProcessState{Up: true}
+	waitForStatus(t, updatec, agent.ContainerStatusRunning)
+
+	if err := c.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := time.Second, <-s.stopc; want != have {
+		t.Errorf("want stop with grace %s, have %s", want, have)
+	}
+
+	select {
+	case grace := <-s.stopc:
+		t.Errorf("want no startup timeout, have stop with grace %s", grace)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	c.containerStatec <- agent.ContainerProcessState{Up: false}
+
+	instance := waitForStatus(t, updatec, agent.ContainerStatusFinished)
+
+	if instance.Err != "" {
+		t.Errorf("want no error, have %q", instance.Err)
+	}
+}
+
+// newSupervisedContainer returns a container which was just started with a
+// fake supervisor, and a channel of its updates.
+func newSupervisedContainer(config agent.ContainerConfig) (*realContainer, *fakeSupervisor, chan agent.ContainerInstance) {
+	var (
+		s = &fakeSupervisor{stopc: make(chan time.Duration, 1)}
+		c = &realContainer{
+			ContainerInstance: agent.ContainerInstance{
+				ID:              "123",
+				ContainerStatus: agent.ContainerStatusCreated,
+				ContainerConfig: config,
+				ContainerHealth: agent.ContainerHealth{HealthStatus: agent.HealthStatusUnknown},
+			},
+
+			logs:        newContainerLog(3),
+			subscribers: map[chan<- agent.ContainerInstance]struct{}{},
+
+			actionc:         make(chan actionRequest),
+			subc:            make(chan chan<- agent.ContainerInstance),
+			unsubc:          make(chan chan<- agent.ContainerInstance),
+			containerStatec: make(chan agent.ContainerProcessState),
+			preparedc:       make(chan error, 1),
+			quitc:           make(chan chan struct{}),
+		}
+		updatec = make(chan agent.ContainerInstance, 100)
+	)
+
+	c.supervise(s)
+	go c.loop()
+	c.Subscribe(updatec)
+
+	return c, s, updatec
+}
+
+func waitForStatus(t *testing.T, updatec chan agent.ContainerInstance, status agent.ContainerStatus) agent.ContainerInstance {
+	timeout := time.After(time.Second)
+
+	for {
+		select {
+		case instance := <-updatec:
+			if instance.ContainerStatus == status {
+				return instance
+			}
+		case <-timeout:
+			t.Fatalf("timeout waiting for status %s", status)
+		}
+	}
+}
+
+// closedPort returns a port nothing listens on.
+func closedPort(t *testing.T) uint16 {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	return uint16(ln.Addr().(*net.TCPAddr).Port)
+}
+
+// fakeSupervisor records how the container stops it.
+type fakeSupervisor struct {
+	stopc chan time.Duration
+}
+
+func (s *fakeSupervisor) Stop(grace time.Duration) { s.stopc <- grace }
+
+func (s *fakeSupervisor) Signal(name string) {}
+
+func (s *fakeSupervisor) Subscribe(c chan<- agent.ContainerProcessState) {}
+
+func (s *fakeSupervisor) Unsubscribe(c chan<- agent.ContainerProcessState) {}
+
+func (s *fakeSupervisor) Exit() error { return nil }
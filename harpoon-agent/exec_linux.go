@@ -0,0 +1,38 @@
+// +build linux
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/libcontainer"
+	"github.com/docker/libcontainer/namespaces"
+)
+
+// execContainer runs a command in the namespaces and cgroups of the container
+// in rundir, and returns its exit status. The supervisor saves the state of
+// the running container, which locates its init process and cgroups, in the
+// rundir. The harpoon-supervisor binary joins the namespaces.
+func execContainer(rundir string, args []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	f, err := os.Open(filepath.Join(rundir, "container.json"))
+	if err != nil {
+		return -1, err
+	}
+	defer f.Close()
+
+	var config *libcontainer.Config
+	if err := json.NewDecoder(f).Decode(&config); err != nil {
+		return -1, fmt.Errorf("unable to parse container config: %s", err)
+	}
+
+	state, err := libcontainer.GetState(rundir)
+	if err != nil {
+		return -1, fmt.Errorf("unable to get container state: %s", err)
+	}
+
+	return namespaces.ExecIn(config, state, args, "harpoon-supervisor", "exec", stdin, stdout, stderr, "", nil)
+}
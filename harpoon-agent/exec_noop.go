@@ -0,0 +1,12 @@
+// +build !linux
+
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+func execContainer(rundir string, args []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	return -1, fmt.Errorf("platform does not support containers")
+}
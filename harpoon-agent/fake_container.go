@@ -1,6 +1,12 @@
 package main
 
-import "github.com/soundcloud/harpoon/harpoon-agent/lib"
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/soundcloud/harpoon/harpoon-agent/lib"
+)
 
 type fakeContainer struct {
 	agent.ContainerInstance
@@ -81,6 +87,23 @@ func (c *fakeContainer) Stop() error {
 	return <-req.res
 }
 
+func (c *fakeContainer) Signal(name string) error {
+	req := actionRequest{
+		action: containerSignal,
+		signal: name,
+		res:    make(chan error),
+	}
+	c.actionRequestc <- req
+	return <-req.res
+}
+
+// Exec echoes the command to stdout, and stdin to stderr.
+func (c *fakeContainer) Exec(args []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	fmt.Fprintln(stdout, strings.Join(args, " "))
+	io.Copy(stderr, stdin)
+	return len(args), nil
+}
+
 func (c *fakeContainer) Recover() error {
 	return nil
 }
@@ -107,11 +130,18 @@ func (c *fakeContainer) loop() {
 			case containerCreate:
 				req.res <- c.create()
 			case containerDestroy:
-				req.res <- c.destroy()
+				err := c.destroy()
+				req.res <- err
+				if err == nil {
+					c.logs.exit()
+					return
+				}
 			case containerStart:
 				req.res <- c.start()
 			case containerStop:
 				req.res <- c.stop()
+			case containerSignal:
+				req.res <- nil
 			default:
 				panic("unknown action")
 			}
@@ -140,8 +170,6 @@ func (c *fakeContainer) destroy() error {
 
 	c.subscribers = map[chan<- agent.ContainerInstance]struct{}{}
 
-	c.Exit()
-
 	return nil
 }
 
@@ -151,6 +179,7 @@ func (c *fakeContainer) start() error {
 }
 
 func (c *fakeContainer) stop() error {
+	c.updateStatus(agent.ContainerStatusFinished)
 	return nil
 }
 
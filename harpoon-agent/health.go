@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/soundcloud/harpoon/harpoon-agent/lib"
+)
+
+const (
+	defaultHealthCheckTimeout  = 1 * time.Second
+	defaultHealthCheckInterval = 10 * time.Second
+)
+
+// healthChecker periodically probes a running container according to its
+// health checks, and reports the aggregated health to the container. Every
+// checker has its own healthc, so the container never receives the health a
+// stopped checker may still be sending.
+type healthChecker struct {
+	healthc chan agent.ContainerHealth
+	quitc   chan struct{}
+}
+
+type probeResult struct {
+	index  int
+	result string
+	err    error
+}
+
+// newHealthChecker starts executing the health checks against the ports on
+// host they refer to. The aggregated health is sent on healthc after every
+// probe, until stop is called.
+func newHealthChecker(checks []agent.HealthCheck, host string, ports map[string]uint16) *healthChecker {
+	h := &healthChecker{
+		healthc: make(chan agent.ContainerHealth),
+		quitc:   make(chan struct{}),
+	}
+
+	resultc := make(chan probeResult)
+
+	for i, check := range checks {
+		go h.run(i, check, host, ports[check.Port], resultc)
+	}
+
+	go h.loop(len(checks), resultc)
+
+	return h
+}
+
+// stop terminates all probes. It doesn't block, so it's safe to call from
+// the container loop, which may be the receiver of a pending health update.
+func (h *healthChecker) stop() {
+	close(h.quitc)
+}
+
+func (h *healthChecker) run(index int, check agent.HealthCheck, host string, port uint16, resultc chan<- probeResult) {
+	var (
+		timeout  = check.Timeout.Duration
+		interval = check.Interval.Duration
+	)
+
+	if timeout <= 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
+
+	select {
+	case <-time.After(check.InitialDelay.Duration):
+	case <-h.quitc:
+		return
+	}
+
+	for {
+		err := probe(check, host, port, timeout)
+
+		result := fmt.Sprintf("%s check on port %s (%d): ok", check.Protocol, check.Port, port)
+		if err != nil {
+			result = fmt.Sprintf("%s check on port %s (%d): %s", check.Protocol, check.Port, port, err)
+		}
+
+		select {
+		case resultc <- probeResult{index: index, result: result, err: err}:
+		case <-h.quitc:
+			return
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-h.quitc:
+			return
+		}
+	}
+}
+
+func (h *healthChecker) loop(n int, resultc <-chan probeResult) {
+	var (
+		probed = make([]bool, n)
+		failed = make([]bool, n)
+		health = agent.ContainerHealth{
+			HealthStatus: agent.HealthStatusUnknown,
+			Since:        time.Now(),
+		}
+	)
+
+	for {
+		select {
+		case r := <-resultc:
+			probed[r.index] = true
+			failed[r.index] = r.err != nil
+
+			health.LastCheck = time.Now()
+			health.LastResult = r.result
+
+			if r.err != nil {
+				health.Failures++
+			}
+
+			status := aggregateHealth(probed, failed)
+			if status != health.HealthStatus {
+				health.HealthStatus = status
+				health.Since = health.LastCheck
+			}
+
+			if status == agent.HealthStatusHealthy {
+				health.Failures = 0
+			}
+
+			select {
+			case h.healthc <- health:
+			case <-h.quitc:
+				return
+			}
+
+		case <-h.quitc:
+			return
+		}
+	}
+}
+
+// aggregateHealth considers a container unhealthy as soon as the latest
+// probe of any check failed, and healthy once the latest probe of every
+// check passed.
+func aggregateHealth(probed, failed []bool) agent.HealthStatus {
+	status := agent.HealthStatusHealthy
+
+	for i := range probed {
+		switch {
+		case failed[i]:
+			return agent.HealthStatusUnhealthy
+		case !probed[i]:
+			status = agent.HealthStatusUnknown
+		}
+	}
+
+	return status
+}
+
+func probe(check agent.HealthCheck, host string, port uint16, timeout time.Duration) error {
+	addr := net.JoinHostPort(host, strconv.Itoa(int(port)))
+
+	switch check.Protocol {
+	case agent.ProtocolTCP:
+		conn, err := net.DialTimeout("tcp", addr, timeout)
+		if err != nil {
+			return err
+		}
+
+		return conn.Close()
+
+	case agent.ProtocolHTTP:
+		client := &http.Client{Timeout: timeout}
+
+		resp, err := client.Get(fmt.Sprintf("http://%s%s", addr, check.HTTPPath))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		for _, code := range check.HTTPAcceptableResponses {
+			if resp.StatusCode == code {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("unacceptable response HTTP %d", resp.StatusCode)
+
+	default:
+		return fmt.Errorf("unknown protocol %q", check.Protocol)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/harpoon/harpoon-agent/lib"
+)
+
+func TestProbeTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	port := uint16(ln.Addr().(*net.TCPAddr).Port)
+	check := agent.HealthCheck{Protocol: agent.ProtocolTCP, Port: "tcp"}
+
+	if err := probe(check, "127.0.0.1", port, time.Second); err != nil {
+		t.Errorf("expected TCP probe to pass, got %s", err)
+	}
+
+	ln.Close()
+
+	if err := probe(check, "127.0.0.1", port, time.Second); err == nil {
+		t.Errorf("expected TCP probe of closed listener to fail")
+	}
+}
+
+func TestProbeHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/-/health" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	port := testServerPort(t, server)
+
+	for path, want := range map[string]bool{
+		"/-/health": true,
+		"/missing":  false,
+	} {
+		check := agent.HealthCheck{
+			Protocol:                agent.ProtocolHTTP,
+			Port:                    "http",
+			HTTPPath:                path,
+			HTTPAcceptableResponses: []int{200},
+		}
+
+		if have := probe(check, "127.0.0.1", port, time.Second) == nil; want != have {
+			t.Errorf("%s: want pass %v, have %v", path, want, have)
+		}
+	}
+}
+
+func TestHealthCheckerReportsTransitions(t *testing.T) {
+	var failing int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	var (
+		checks = []agent.HealthCheck{{
+			Protocol:                agent.ProtocolHTTP,
+			Port:                    "http",
+			Interval:                agent.JSONDuration{Duration: time.Millisecond},
+			HTTPPath:                "/",
+			HTTPAcceptableResponses: []int{200},
+		}}
+		ports = map[string]uint16{"http": testServerPort(t, server)}
+		h     = newHealthChecker(checks, "127.0.0.1", ports)
+	)
+	defer h.stop()
+
+	health := awaitHealth(t, h.healthc, agent.HealthStatusHealthy)
+	if health.Failures != 0 {
+		t.Errorf("healthy container reported %d failures", health.Failures)
+	}
+
+	atomic.StoreInt32(&failing, 1)
+
+	health = awaitHealth(t, h.healthc, agent.HealthStatusUnhealthy)
+	if health.Failures == 0 {
+		t.Errorf("unhealthy container reported no failures")
+	}
+	if health.LastResult == "" {
+		t.Errorf("unhealthy container reported no last result")
+	}
+
+	atomic.StoreInt32(&failing, 0)
+
+	health = awaitHealth(t, h.healthc, agent.HealthStatusHealthy)
+	if health.Failures != 0 {
+		t.Errorf("recovered container reported %d failures", health.Failures)
+	}
+}
+
+func TestAggregateHealth(t *testing.T) {
+	for i, input := range []struct {
+		probed, failed []bool
+		want           agent.HealthStatus
+	}{
+		{[]bool{true, true}, []bool{false, false}, agent.HealthStatusHealthy},
+		{[]bool{true, false}, []bool{false, false}, agent.HealthStatusUnknown},
+		{[]bool{true, false}, []bool{true, false}, agent.HealthStatusUnhealthy},
+		{[]bool{true, true}, []bool{false, true}, agent.HealthStatusUnhealthy},
+	} {
+		if want, have := input.want, aggregateHealth(input.probed, input.failed); want != have {
+			t.Errorf("%d: want %s, have %s", i, want, have)
+		}
+	}
+}
+
+func awaitHealth(t *testing.T, healthc <-chan agent.ContainerHealth, status agent.HealthStatus) agent.ContainerHealth {
+	timeout := time.After(time.Second)
+
+	for {
+		select {
+		case health := <-healthc:
+			if health.HealthStatus == status {
+				return health
+			}
+		case <-timeout:
+			t.Fatalf("timeout waiting for health status %s", status)
+		}
+	}
+}
+
+func testServerPort(t *testing.T, server *httptest.Server) uint16 {
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return uint16(p)
+}
@@ -13,6 +13,8 @@ import (
 //   - A ROUTED line is sent to all channels listening to that container's logs.
 //       - Each copy potentially sent to a listener's channel is DELIVERABLE.
 //       - Each DELIVERABLE which encountered a blocked channel is UNDELIVERED.
+//   - Log streams retrieve UNDELIVERED lines from the container's buffer.
+//       - Each line which was no longer buffered is LOST to that stream.
 //
 // So...
 //   - Every inbound message generates a received count.
@@ -26,16 +28,22 @@ var (
 	expvarLogUnroutableLines                 = expvar.NewInt("log_unroutable_lines_total")
 	expvarLogDeliverableLines                = expvar.NewInt("log_deliverable_lines_total")
 	expvarLogUndeliveredLines                = expvar.NewInt("log_undelivered_lines_total")
+	expvarLogLostLines                       = expvar.NewInt("log_lost_lines_total")
 	expvarContainerCreate                    = expvar.NewInt("container_creates_total")
 	expvarContainerCreateFailures            = expvar.NewInt("container_create_failures_total")
 	expvarContainerRecoveryAttempts          = expvar.NewInt("container_recovery_attempts_total")
 	expvarContainerDestroy                   = expvar.NewInt("container_destroys_total")
 	expvarContainerStart                     = expvar.NewInt("container_start_total")
 	expvarContainerStartFailures             = expvar.NewInt("container_start_failures_total")
+	expvarContainerStartupTimeouts           = expvar.NewInt("container_startup_timeouts_total")
 	expvarContainerStop                      = expvar.NewInt("container_stops_total")
 	expvarContainerStatusKilled              = expvar.NewInt("container_status_kill_total")
 	expvarContainerStatusDownSuccessful      = expvar.NewInt("container_status_down_successful_total")
 	expvarContainerStatusForceDownSuccessful = expvar.NewInt("container_status_force_down_successful_total")
+	expvarArtifactDownloads                  = expvar.NewInt("artifact_downloads_total")
+	expvarArtifactDownloadFailures           = expvar.NewInt("artifact_download_failures_total")
+	expvarArtifactsCollected                 = expvar.NewInt("artifacts_collected_total")
+	expvarContainersReaped                   = expvar.NewInt("containers_reaped_total")
 )
 
 // Derivable metrics:
@@ -73,6 +81,12 @@ var (
 		Name:      "log_undelivered_lines_total",
 		Help:      "Number of accepted log lines written to listeners.",
 	})
+	prometheusLogLostLines = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "harpoon",
+		Subsystem: "agent",
+		Name:      "log_lost_lines_total",
+		Help:      "Number of log lines log streams couldn't retrieve from the buffer anymore.",
+	})
 	prometheusContainerCreate = prometheus.NewCounter(prometheus.CounterOpts{
 		Namespace: "harpoon",
 		Subsystem: "agent",
@@ -109,6 +123,12 @@ var (
 		Name:      "container_start_failures_total",
 		Help:      "Number of times a container start operation has failed.",
 	})
+	prometheusContainerStartupTimeouts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "harpoon",
+		Subsystem: "agent",
+		Name:      "container_startup_timeouts_total",
+		Help:      "Number of times a container was killed for not starting within its startup grace period.",
+	})
 	prometheusContainerStop = prometheus.NewCounter(prometheus.CounterOpts{
 		Namespace: "harpoon",
 		Subsystem: "agent",
@@ -133,8 +153,60 @@ var (
 		Name:      "container_status_force_down_successful_total",
 		Help:      "Number of times that a container was successfully forced down.",
 	})
+	prometheusArtifactDownloads = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "harpoon",
+		Subsystem: "agent",
+		Name:      "artifact_downloads_total",
+		Help:      "Number of times the agent attempted to download an artifact.",
+	})
+	prometheusArtifactDownloadFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "harpoon",
+		Subsystem: "agent",
+		Name:      "artifact_download_failures_total",
+		Help:      "Number of times an artifact download or verification has failed.",
+	})
+	prometheusArtifactsCollected = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "harpoon",
+		Subsystem: "agent",
+		Name:      "artifacts_collected_total",
+		Help:      "Number of unused artifacts removed from the artifact cache.",
+	})
+	prometheusContainersReaped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "harpoon",
+		Subsystem: "agent",
+		Name:      "containers_reaped_total",
+		Help:      "Number of finished or failed containers garbage-collected by the retention policy.",
+	})
 )
 
+func init() {
+	for _, c := range []prometheus.Collector{
+		prometheusLogReceivedLines,
+		prometheusLogUnparsableLines,
+		prometheusLogUnroutableLines,
+		prometheusLogDeliverableLines,
+		prometheusLogUndeliveredLines,
+		prometheusLogLostLines,
+		prometheusContainerCreate,
+		prometheusContainerCreateFailures,
+		prometheusContainerRecoveryAttempts,
+		prometheusContainerDestroy,
+		prometheusContainerStart,
+		prometheusContainerStartFailures,
+		prometheusContainerStartupTimeouts,
+		prometheusContainerStop,
+		prometheusContainerStatusKilled,
+		prometheusContainerStatusDownSuccessful,
+		prometheusContainerStatusForceDownSuccessful,
+		prometheusArtifactDownloads,
+		prometheusArtifactDownloadFailures,
+		prometheusArtifactsCollected,
+		prometheusContainersReaped,
+	} {
+		prometheus.MustRegister(c)
+	}
+}
+
 func incLogReceivedLines(n int) {
 	expvarLogReceivedLines.Add(int64(n))
 	prometheusLogReceivedLines.Add(float64(n))
@@ -160,6 +232,11 @@ func incLogUndeliveredLines(n int) {
 	prometheusLogUndeliveredLines.Add(float64(n))
 }
 
+func incLogLostLines(n int) {
+	expvarLogLostLines.Add(int64(n))
+	prometheusLogLostLines.Add(float64(n))
+}
+
 func incContainerStart(n int) {
 	expvarContainerStart.Add(int64(n))
 	prometheusContainerStart.Add(float64(n))
@@ -170,6 +247,11 @@ func incContainerStartFailure(n int) {
 	prometheusContainerStartFailures.Add(float64(n))
 }
 
+func incContainerStartupTimeout(n int) {
+	expvarContainerStartupTimeouts.Add(int64(n))
+	prometheusContainerStartupTimeouts.Add(float64(n))
+}
+
 func incContainerRecoveryAttempts(n int) {
 	expvarContainerRecoveryAttempts.Add(int64(n))
 	prometheusContainerRecoveryAttempts.Add(float64(n))
@@ -209,3 +291,113 @@ func incContainerStatusForceDownSuccessful(n int) {
 	expvarContainerStatusForceDownSuccessful.Add(int64(n))
 	prometheusContainerStatusForceDownSuccessful.Add(float64(n))
 }
+
+func incArtifactDownload(n int) {
+	expvarArtifactDownloads.Add(int64(n))
+	prometheusArtifactDownloads.Add(float64(n))
+}
+
+func incArtifactDownloadFailure(n int) {
+	expvarArtifactDownloadFailures.Add(int64(n))
+	prometheusArtifactDownloadFailures.Add(float64(n))
+}
+
+func incArtifactCollected(n int) {
+	expvarArtifactsCollected.Add(int64(n))
+	prometheusArtifactsCollected.Add(float64(n))
+}
+
+func incContainersReaped(n int) {
+	expvarContainersReaped.Add(int64(n))
+	prometheusContainersReaped.Add(float64(n))
+}
+
+// containerLabels are the labels of all per-container series. The job labels
+// are empty for containers which weren't labelled by the scheduler.
+var containerLabels = []string{"container_id", "job_name", "environment", "product"}
+
+func newContainerDesc(name, help string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName("harpoon", "container", name), help, containerLabels, nil)
+}
+
+var (
+	containerUpDesc                  = newContainerDesc("up", "Whether the container process is running.")
+	containerRestartsDesc            = newContainerDesc("restarts_total", "Number of times the container process has been restarted.")
+	containerOOMsDesc                = newContainerDesc("ooms_total", "Number of times the container process has been killed for exceeding its memory limit.")
+	containerExitStatusDesc          = newContainerDesc("last_exit_status", "Exit status of the container process, if it last exited on its own.")
+	containerExitSignalDesc          = newContainerDesc("last_exit_signal", "Signal which killed the container process, if it was last killed by one.")
+	containerCPUSecondsDesc          = newContainerDesc("cpu_seconds_total", "CPU time consumed by the container.")
+	containerCPUPeriodsDesc          = newContainerDesc("cpu_periods_total", "Number of CPU enforcement periods in which the container was active.")
+	containerCPUThrottledPeriodsDesc = newContainerDesc("cpu_throttled_periods_total", "Number of CPU enforcement periods in which the container was throttled.")
+	containerCPUThrottledSecondsDesc = newContainerDesc("cpu_throttled_seconds_total", "Time the container was throttled for.")
+	containerMemoryUsageBytesDesc    = newContainerDesc("memory_usage_bytes", "Memory used by the container.")
+	containerMemoryLimitBytesDesc    = newContainerDesc("memory_limit_bytes", "Memory limit of the container.")
+)
+
+// containerCollector exports the process state and metrics of every
+// container in the registry, as reported by their supervisors, at the time
+// of the scrape.
+type containerCollector struct {
+	r *registry
+}
+
+// Describe implements prometheus.Collector.
+func (c containerCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		containerUpDesc,
+		containerRestartsDesc,
+		containerOOMsDesc,
+		containerExitStatusDesc,
+		containerExitSignalDesc,
+		containerCPUSecondsDesc,
+		containerCPUPeriodsDesc,
+		containerCPUThrottledPeriodsDesc,
+		containerCPUThrottledSecondsDesc,
+		containerMemoryUsageBytesDesc,
+		containerMemoryLimitBytesDesc,
+	} {
+		ch <- desc
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c containerCollector) Collect(ch chan<- prometheus.Metric) {
+	for id, instance := range c.r.instances() {
+		var (
+			state  = instance.ContainerProcessState
+			labels = []string{
+				id,
+				instance.Labels["job"],
+				instance.Labels["environment"],
+				instance.Labels["product"],
+			}
+			up float64
+		)
+
+		if state.Up {
+			up = 1
+		}
+
+		send := func(desc *prometheus.Desc, valueType prometheus.ValueType, value float64) {
+			ch <- prometheus.MustNewConstMetric(desc, valueType, value, labels...)
+		}
+
+		send(containerUpDesc, prometheus.GaugeValue, up)
+		send(containerRestartsDesc, prometheus.CounterValue, float64(state.Restarts))
+		send(containerOOMsDesc, prometheus.CounterValue, float64(state.OOMs))
+
+		switch {
+		case state.Exited:
+			send(containerExitStatusDesc, prometheus.GaugeValue, float64(state.ExitStatus))
+		case state.Signaled:
+			send(containerExitSignalDesc, prometheus.GaugeValue, float64(state.Signal))
+		}
+
+		send(containerCPUSecondsDesc, prometheus.CounterValue, float64(state.CPUTime)/1e9)
+		send(containerCPUPeriodsDesc, prometheus.CounterValue, float64(state.CPUPeriods))
+		send(containerCPUThrottledPeriodsDesc, prometheus.CounterValue, float64(state.CPUThrottledPeriods))
+		send(containerCPUThrottledSecondsDesc, prometheus.CounterValue, float64(state.CPUThrottledTime)/1e9)
+		send(containerMemoryUsageBytesDesc, prometheus.GaugeValue, float64(state.MemoryUsage))
+		send(containerMemoryLimitBytesDesc, prometheus.GaugeValue, float64(state.MemoryLimit))
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"expvar"
 	"fmt"
+	"reflect"
 	"strconv"
 	"testing"
 	"time"
@@ -10,6 +11,8 @@ import (
 	dto "github.com/prometheus/client_model/go"
 
 	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/soundcloud/harpoon/harpoon-agent/lib"
 )
 
 func TestReceiveLogInstrumentation(t *testing.T) {
@@ -109,6 +112,71 @@ func TestLogInstrumentationNotifyWithBlockedWatcher(t *testing.T) {
 	ExpectCounterEqual(t, "log_undelivered_lines_total", 1)
 }
 
+func TestContainerCollector(t *testing.T) {
+	var (
+		r = newRegistry()
+		c = newFakeContainer("123")
+	)
+	defer c.Exit()
+
+	c.ContainerInstance.Labels = map[string]string{"job": "web", "environment": "prod", "product": "stream"}
+	c.ContainerInstance.ContainerProcessState = agent.ContainerProcessState{
+		Up:       true,
+		Restarts: 2,
+		OOMs:     1,
+		ContainerMetrics: agent.ContainerMetrics{
+			CPUTime:     3e9,
+			MemoryUsage: 1024,
+		},
+	}
+	r.register(c)
+
+	var (
+		metricc = make(chan prometheus.Metric, 100)
+		values  = map[*prometheus.Desc]float64{}
+	)
+
+	containerCollector{r}.Collect(metricc)
+	close(metricc)
+
+	for m := range metricc {
+		pb := &dto.Metric{}
+		m.Write(pb)
+
+		labels := map[string]string{}
+		for _, pair := range pb.GetLabel() {
+			labels[pair.GetName()] = pair.GetValue()
+		}
+
+		if want, have := map[string]string{"container_id": "123", "job_name": "web", "environment": "prod", "product": "stream"}, labels; !reflect.DeepEqual(want, have) {
+			t.Errorf("want labels %v, have %v", want, have)
+		}
+
+		switch {
+		case pb.Counter != nil:
+			values[m.Desc()] = pb.GetCounter().GetValue()
+		case pb.Gauge != nil:
+			values[m.Desc()] = pb.GetGauge().GetValue()
+		}
+	}
+
+	for desc, want := range map[*prometheus.Desc]float64{
+		containerUpDesc:               1,
+		containerRestartsDesc:         2,
+		containerOOMsDesc:             1,
+		containerCPUSecondsDesc:       3,
+		containerMemoryUsageBytesDesc: 1024,
+	} {
+		if have, ok := values[desc]; !ok || want != have {
+			t.Errorf("%s: want %f, have %f", desc, want, have)
+		}
+	}
+
+	if _, ok := values[containerExitStatusDesc]; ok {
+		t.Errorf("running container reported an exit status")
+	}
+}
+
 func createReceiveLogsFixture(t *testing.T, r *registry) {
 	setLogAddrRandomly(t)
 	go receiveLogs(r)
@@ -1,8 +1,13 @@
 package agent
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -18,26 +23,40 @@ type Agent interface {
 	Get(containerID string) (ContainerInstance, error)                                                              // GET /containers/{id}
 	Start(containerID string) error                                                                                 // POST /containers/{id}/start
 	Stop(containerID string) error                                                                                  // POST /containers/{id}/stop
-	Replace(newContainerID, oldContainerID string) error                                                            // PUT /containers/{newID}?replace={oldID}
+	Signal(containerID, signal string) error                                                                        // POST /containers/{id}/signal?signal={signal}
+	Exec(containerID string, cmd []string, stdin io.Reader, stdout, stderr io.Writer) (int, error)                  // POST /containers/{id}/exec?cmd={cmd}&cmd=...
+	Replace(newContainerID, oldContainerID string, containerConfig ContainerConfig) error                           // PUT /containers/{newID}?replace={oldID}
 	Delete(containerID string) error                                                                                // DELETE /containers/{id}
 	Containers() (map[string]ContainerInstance, error)                                                              // GET /containers
 	Events() (<-chan StateEvent, Stopper, error)                                                                    // GET /containers with request header Accept: text/event-stream
+	EventsSince(generation uint64) (<-chan StateEvent, Stopper, error)                                              // GET /containers with request headers Accept: text/event-stream and Last-Event-ID
 	Log(containerID string, history int) (<-chan string, Stopper, error)                                            // GET /containers/{id}/log?history=10
 	Resources() (HostResources, error)                                                                              // GET /resources
+	Drain() error                                                                                                   // POST /drain
+	Undrain() error                                                                                                 // POST /undrain
 	Wait(containerID string, statuses map[ContainerStatus]struct{}, timeout time.Duration) (ContainerStatus, error) // Waits for event with one of the statuses
 }
 
 // ContainerConfig describes the information necessary to start a container on
 // an agent.
 type ContainerConfig struct {
-	ArtifactURL string            `json:"artifact_url"`
-	Ports       map[string]uint16 `json:"ports"`
-	Env         map[string]string `json:"env"`
-	Command     `json:"command"`
-	Resources   `json:"resources"`
-	Storage     `json:"storage"`
-	Grace       `json:"grace"`
-	Restart     `json:"restart"`
+	HealthChecks   []HealthCheck     `json:"health_checks"` // first, to keep configstore.JobConfig hashes stable
+	ArtifactURL    string            `json:"artifact_url"`
+	ArtifactSHA256 string            `json:"artifact_sha256,omitempty"` // optional, hex-encoded digest of the artifact
+	Ports          map[string]uint16 `json:"ports"`
+	Network        string            `json:"network,omitempty"`    // NetworkHost (default) or NetworkPrivate
+	HostPorts      map[string]uint16 `json:"host_ports,omitempty"` // private network only: host port per port, assigned by the agent
+	Address        string            `json:"address,omitempty"`    // private network only: container address (CIDR), assigned by the agent
+	Env            map[string]string `json:"env"`
+	Labels         map[string]string `json:"labels,omitempty"` // informational, e.g. the job of a scheduled task
+	Command        `json:"command"`
+	Resources      `json:"resources"`
+	Storage        `json:"storage"`
+	Grace          `json:"grace"`
+	Restart        Restart      `json:"restart"`
+	StopSignal     string       `json:"stop_signal,omitempty"` // e.g. "QUIT"; defaults to "TERM"
+	PreStop        *PreStop     `json:"pre_stop,omitempty"`    // optional, run before the stop signal is sent
+	Constraints    []Constraint `json:"constraints,omitempty"` // scheduler only: agents the container may be placed on
 }
 
 // Valid performs a validation check, to ensure invalid structures may be
@@ -49,6 +68,18 @@ func (c ContainerConfig) Valid() error {
 		errs = append(errs, fmt.Sprintf("artifact URL %q invalid: %s", c.ArtifactURL, err))
 	}
 
+	if c.ArtifactSHA256 != "" {
+		if buf, err := hex.DecodeString(c.ArtifactSHA256); err != nil || len(buf) != sha256.Size {
+			errs = append(errs, fmt.Sprintf("artifact SHA256 %q invalid", c.ArtifactSHA256))
+		}
+	}
+
+	switch c.Network {
+	case "", NetworkHost, NetworkPrivate:
+	default:
+		errs = append(errs, fmt.Sprintf("network %q invalid", c.Network))
+	}
+
 	if err := c.Command.Valid(); err != nil {
 		errs = append(errs, fmt.Sprintf("command invalid: %s", err))
 	}
@@ -69,6 +100,40 @@ func (c ContainerConfig) Valid() error {
 		errs = append(errs, fmt.Sprintf("restart policy invalid: %s", err))
 	}
 
+	if c.StopSignal != "" {
+		if _, err := ParseSignal(c.StopSignal); err != nil {
+			errs = append(errs, fmt.Sprintf("stop signal invalid: %s", err))
+		}
+	}
+
+	if c.PreStop != nil {
+		if err := c.PreStop.Valid(); err != nil {
+			errs = append(errs, fmt.Sprintf("pre-stop hook invalid: %s", err))
+		}
+
+		if c.PreStop.HTTPPort != "" {
+			if _, ok := c.Ports[c.PreStop.HTTPPort]; !ok {
+				errs = append(errs, fmt.Sprintf("pre-stop hook: port %q not in ports", c.PreStop.HTTPPort))
+			}
+		}
+	}
+
+	for i, constraint := range c.Constraints {
+		if err := constraint.Valid(); err != nil {
+			errs = append(errs, fmt.Sprintf("constraint %d: %s", i, err))
+		}
+	}
+
+	for i, healthCheck := range c.HealthChecks {
+		if err := healthCheck.Valid(); err != nil {
+			errs = append(errs, fmt.Sprintf("health check %d: %s", i, err))
+		}
+
+		if _, ok := c.Ports[healthCheck.Port]; !ok {
+			errs = append(errs, fmt.Sprintf("health check %d: port %q not in ports", i, healthCheck.Port))
+		}
+	}
+
 	if len(errs) > 0 {
 		return fmt.Errorf(strings.Join(errs, "; "))
 	}
@@ -76,6 +141,15 @@ func (c ContainerConfig) Valid() error {
 	return nil
 }
 
+// Network modes of a container. Containers in the host network bind their
+// ports on the host directly. Containers in a private network get their own
+// network namespace and address, and may bind fixed ports, which the agent
+// maps to dynamic host ports.
+const (
+	NetworkHost    = "host"
+	NetworkPrivate = "private"
+)
+
 // Command describes how to start a binary.
 type Command struct {
 	WorkingDir string   `json:"working_dir"`
@@ -100,9 +174,10 @@ func (c Command) Valid() error {
 
 // Resources describes resource limits for a container.
 type Resources struct {
-	Mem uint64  `json:"mem"` // MB
-	CPU float64 `json:"cpu"` // fractional CPUs
-	FD  uint64  `json:"fd"`  // file descriptor hard limit
+	Mem    uint64  `json:"mem"`              // MB
+	CPU    float64 `json:"cpu"`              // fractional CPUs
+	FD     uint64  `json:"fd"`               // file descriptor hard limit
+	CPUSet string  `json:"cpuset,omitempty"` // optional CPUs to pin to, e.g. "0-3,6"
 }
 
 // Valid performs a validation check, to ensure invalid structures may be
@@ -112,12 +187,48 @@ func (r Resources) Valid() error {
 	if r.CPU <= 0.0 {
 		errs = append(errs, "cpu (floating point fractional CPUs) not specified or zero")
 	}
+	if r.CPUSet != "" {
+		n, err := cpusetSize(r.CPUSet)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Sprintf("cpuset %q invalid: %s", r.CPUSet, err))
+		case float64(n) < r.CPU:
+			errs = append(errs, fmt.Sprintf("cpuset %q has %d CPU(s), fewer than the %.2f requested", r.CPUSet, n, r.CPU))
+		}
+	}
 	if len(errs) > 0 {
 		return fmt.Errorf(strings.Join(errs, "; "))
 	}
 	return nil
 }
 
+// cpusetSize returns the number of CPUs in a cpuset list like "0-3,6".
+func cpusetSize(cpuset string) (int, error) {
+	cpus := map[int]struct{}{}
+
+	for _, part := range strings.Split(cpuset, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+
+		lo, err := strconv.Atoi(bounds[0])
+		if err != nil || lo < 0 {
+			return 0, fmt.Errorf("bad CPU %q", bounds[0])
+		}
+
+		hi := lo
+		if len(bounds) == 2 {
+			if hi, err = strconv.Atoi(bounds[1]); err != nil || hi < lo {
+				return 0, fmt.Errorf("bad CPU range %q", part)
+			}
+		}
+
+		for cpu := lo; cpu <= hi; cpu++ {
+			cpus[cpu] = struct{}{}
+		}
+	}
+
+	return len(cpus), nil
+}
+
 // Storage describes storage requirements for a container.
 type Storage struct {
 	Temp    map[string]int    `json:"tmp"`     // container path: max alloc megabytes (-1 for unlimited)
@@ -127,14 +238,40 @@ type Storage struct {
 // Valid performs a validation check, to ensure invalid structures may be
 // detected as early as possible.
 func (s Storage) Valid() error {
-	// TODO: what constitutes invalid storage specification?
+	var errs []string
+
+	for dest, size := range s.Temp {
+		if size != -1 && size <= 0 {
+			errs = append(errs, fmt.Sprintf("tmpfs %q size %dMB invalid: must be positive, or -1 for unlimited", dest, size))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf(strings.Join(errs, "; "))
+	}
+
 	return nil
 }
 
+// TempSize returns the total size, in MB, of the sized tmpfs mounts. Unlimited
+// mounts don't count towards it.
+func (s Storage) TempSize() float64 {
+	var size float64
+
+	for _, n := range s.Temp {
+		if n > 0 {
+			size += float64(n)
+		}
+	}
+
+	return size
+}
+
 // Grace describes how many seconds the scheduler should wait for a container
 // to start up and shut down before giving up on that operation. Containers
 // that don't shut down within the shutdown window may be subject to a more
-// forceful kill.
+// forceful kill. Containers that aren't up, or healthy if they have health
+// checks, within the startup window are killed and fail.
 type Grace struct {
 	Startup  JSONDuration `json:"startup"`
 	Shutdown JSONDuration `json:"shutdown"`
@@ -167,15 +304,86 @@ func (g Grace) Valid() error {
 	return nil
 }
 
-// Restart describes the restart policy of a container in an agent.
+// Restart describes when a container in an agent is restarted, and how
+// restarts are paced.
+//
+// Consecutive restarts back off exponentially: the first waits Backoff, and
+// every one after doubles that, up to MaxBackoff. The actual delay is
+// jittered to between half and all of it. A container which ran for longer
+// than MaxBackoff before exiting starts over at Backoff. If a container is
+// restarted MaxRestarts times within Window, it's considered crash-looping,
+// and not restarted again.
+//
+// Zero durations take defaults: 1s Backoff, 1m MaxBackoff, and 10m Window. A
+// zero MaxRestarts means no limit. A Restart with only a Policy is encoded in
+// JSON as just the policy, e.g. "always".
+type Restart struct {
+	Policy      RestartPolicy `json:"policy"`
+	Backoff     JSONDuration  `json:"backoff"`
+	MaxBackoff  JSONDuration  `json:"max_backoff"`
+	MaxRestarts int           `json:"max_restarts"`
+	Window      JSONDuration  `json:"window"`
+}
+
+// Valid performs a validation check, to ensure invalid structures may be
+// detected as early as possible.
+func (r Restart) Valid() error {
+	var errs []string
+
+	if err := r.Policy.Valid(); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if r.Backoff.Duration < 0 || r.MaxBackoff.Duration < 0 || r.Window.Duration < 0 {
+		errs = append(errs, "durations must not be negative")
+	}
+
+	if r.MaxBackoff.Duration > 0 && r.MaxBackoff.Duration < r.Backoff.Duration {
+		errs = append(errs, fmt.Sprintf("max backoff (%s) must not be less than backoff (%s)", r.MaxBackoff, r.Backoff))
+	}
+
+	if r.MaxRestarts < 0 {
+		errs = append(errs, fmt.Sprintf("max restarts (%d) must not be negative", r.MaxRestarts))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf(strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (r Restart) MarshalJSON() ([]byte, error) {
+	if r == (Restart{Policy: r.Policy}) {
+		return json.Marshal(r.Policy)
+	}
+
+	type restart Restart // without methods, to avoid recursion
+	return json.Marshal(restart(r))
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (r *Restart) UnmarshalJSON(buf []byte) error {
+	var policy RestartPolicy
+	if err := json.Unmarshal(buf, &policy); err == nil {
+		*r = Restart{Policy: policy}
+		return nil
+	}
+
+	type restart Restart // without methods, to avoid recursion
+	return json.Unmarshal(buf, (*restart)(r))
+}
+
+// RestartPolicy describes when a container in an agent is restarted.
 //
 // Docker provides some inspiration here.
 // http://blog.docker.com/2014/08/announcing-docker-1-2-0/
-type Restart string
+type RestartPolicy string
 
 const (
 	// NoRestart indicates that the container won't be restarted if it dies
-	NoRestart Restart = "no"
+	NoRestart RestartPolicy = "no"
 
 	// OnFailureRestart indicates that the container will be restarted
 	// only if it exits with a non-zero status
@@ -188,7 +396,7 @@ const (
 
 // Valid performs a validation check, to ensure invalid structures may be
 // detected as early as possible.
-func (r Restart) Valid() error {
+func (r RestartPolicy) Valid() error {
 	switch r {
 	case NoRestart, OnFailureRestart, AlwaysRestart:
 	default:
@@ -204,19 +412,134 @@ func (r Restart) Valid() error {
 	return nil
 }
 
+// PreStop describes a hook which is run when a container is stopped, before
+// it's sent its stop signal, e.g. to drain connections. Exactly one of
+// HTTPPort and Exec must be set. The hook must finish within half of the
+// shutdown grace period, or the container is sent its stop signal regardless.
+type PreStop struct {
+	HTTPPort string   `json:"http_port,omitempty"` // from key of ports map in container config; the hook POSTs to HTTPPath
+	HTTPPath string   `json:"http_path,omitempty"` // e.g. "/-/drain"
+	Exec     []string `json:"exec,omitempty"`      // command run in the container, e.g. ["./drain"]
+}
+
+// Valid performs a validation check, to ensure invalid structures may be
+// detected as early as possible.
+func (h PreStop) Valid() error {
+	var errs []string
+
+	if (h.HTTPPort == "") == (len(h.Exec) == 0) {
+		errs = append(errs, "exactly one of HTTP port and exec must be specified")
+	}
+
+	if h.HTTPPort == "" && h.HTTPPath != "" {
+		errs = append(errs, "HTTP path requires an HTTP port")
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf(strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// HealthCheck defines how a third party can determine if an instance of a
+// given task is healthy. HealthChecks are defined and persisted in the config
+// store, but executed by the agent.
+//
+// HealthChecks are largely inspired by the Marathon definition.
+// https://github.com/mesosphere/marathon/blob/master/REST.md
+type HealthCheck struct {
+	Protocol     string       `json:"protocol"` // HTTP, TCP
+	Port         string       `json:"port"`     // from key of ports map in container config, i.e. env var name
+	InitialDelay JSONDuration `json:"initial_delay"`
+	Timeout      JSONDuration `json:"timeout"`
+	Interval     JSONDuration `json:"interval"`
+
+	// Special parameters for HTTP health checks.
+	HTTPPath                string `json:"http_path,omitempty"`                 // e.g. "/-/health"
+	HTTPAcceptableResponses []int  `json:"http_acceptable_responses,omitempty"` // e.g. [200,201,301]
+}
+
+const (
+	// ProtocolHTTP health checks issue a GET request against HTTPPath, and
+	// pass if the response code is one of HTTPAcceptableResponses.
+	ProtocolHTTP = "HTTP"
+
+	// ProtocolTCP health checks pass if a TCP connection can be established.
+	ProtocolTCP = "TCP"
+
+	maxInitialDelay = 30 * time.Second
+	maxTimeout      = 3 * time.Second
+	maxInterval     = 30 * time.Second
+)
+
+// Valid performs a validation check, to ensure invalid structures may be
+// detected as early as possible.
+func (c HealthCheck) Valid() error {
+	var errs []string
+
+	switch c.Protocol {
+	case ProtocolHTTP, ProtocolTCP:
+	default:
+		errs = append(errs, fmt.Sprintf("invalid protocol %q", c.Protocol))
+	}
+
+	if c.InitialDelay.Duration > maxInitialDelay {
+		errs = append(errs, fmt.Sprintf("initial delay (%s) too large (max %s)", c.InitialDelay, maxInitialDelay))
+	}
+
+	if c.Timeout.Duration > maxTimeout {
+		errs = append(errs, fmt.Sprintf("timeout (%s) too large (max %s)", c.Timeout, maxTimeout))
+	}
+
+	if c.Interval.Duration > maxInterval {
+		errs = append(errs, fmt.Sprintf("interval (%s) too large (max %s)", c.Interval, maxInterval))
+	}
+
+	if c.Protocol == ProtocolHTTP {
+		if c.HTTPPath == "" {
+			errs = append(errs, `protocol "HTTP" requires "http_path"`)
+		}
+
+		if len(c.HTTPAcceptableResponses) <= 0 {
+			errs = append(errs, `protocol "HTTP" requires "http_acceptable_responses" (array of integers)`)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf(strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
 // StateEvent is returned whenever a container changes state. It reflects the
 // changed container and the current host resources (post-change).
+//
+// Generation and Heartbeat are taken from the event stream, rather than the
+// event's data. Generation numbers the agent's state, for resuming the
+// stream with EventsSince. Heartbeats are sent periodically, reflect no
+// containers, and only serve to show the stream is alive.
 type StateEvent struct {
 	Resources  HostResources                `json:"resources"`
 	Containers map[string]ContainerInstance `json:"containers"`
+	Generation uint64                       `json:"-"`
+	Heartbeat  bool                         `json:"-"`
 }
 
+// HeartbeatEvent is the type of heartbeat events in the agent's event stream.
+const HeartbeatEvent = "heartbeat"
+
 // HostResources are returned by agents and reflect their current state.
+// Draining agents accept no new containers. Labels describe the agent, e.g.
+// its rack or hardware class, for containers' Constraints.
 type HostResources struct {
-	Mem     TotalReservedInt `json:"mem"`     // MB
-	CPU     TotalReserved    `json:"cpus"`    // whole CPUs
-	Storage TotalReserved    `json:"storage"` // Bytes
-	Volumes []string         `json:"volumes"`
+	Mem      TotalReservedInt  `json:"mem"`     // MB
+	CPU      TotalReserved     `json:"cpus"`    // whole CPUs
+	Storage  TotalReserved     `json:"storage"` // MB, for sized tmpfs mounts
+	Volumes  []string          `json:"volumes"`
+	Labels   map[string]string `json:"labels"`
+	Draining bool              `json:"draining"`
 }
 
 // TotalReserved encodes the total scalar amount of an arbitrary resource
@@ -260,6 +583,7 @@ type ContainerInstance struct {
 	ContainerStatus       `json:"status"`
 	ContainerConfig       `json:"config"`
 	ContainerProcessState `json:"process_state"`
+	ContainerHealth       `json:"health"`
 }
 
 // ContainerStatus describes the current state of a container in an agent. The
@@ -271,6 +595,12 @@ type ContainerInstance struct {
 type ContainerStatus string
 
 const (
+	// ContainerStatusPreparing indicates the container has been PUT on the
+	// agent, which is fetching and unpacking its artifact. Once that's done,
+	// the container is created and started. If preparing fails, the
+	// container is failed, with the error in its process state.
+	ContainerStatusPreparing ContainerStatus = "preparing"
+
 	// ContainerStatusCreated indicates the container has been successfully
 	// PUT on the agent, but hasn't yet been started. Once a container leaves
 	// the created state, it will never come back.
@@ -282,14 +612,17 @@ const (
 	ContainerStatusRunning ContainerStatus = "running"
 
 	// ContainerStatusFailed indicates the container has exited with a nonzero
-	// return code. In most cases, this is a very short-lived state, as the
-	// agent will restart the container.
+	// return code, or couldn't be prepared or started at all. In most cases,
+	// this is a very short-lived state, as the agent will restart the
+	// container.
 	ContainerStatusFailed ContainerStatus = "failed"
 
 	// ContainerStatusFinished indicates the container has exited successfully
 	// with a zero return code. In most cases, this will be a long-lived
-	// state, as the agent will not restart the container. (We should probably
-	// think about if and how to reap finished containers.)
+	// state, as the agent will not restart the container. Finished and failed
+	// containers are kept until they're destroyed, or garbage-collected by
+	// the agent's retention policy, which leaves a Tombstone. Tasks of the
+	// scheduler, labelled with their job, are never garbage-collected.
 	ContainerStatusFinished ContainerStatus = "finished"
 
 	// ContainerStatusDeleted is a special meta-state used only in event
@@ -298,6 +631,61 @@ const (
 	ContainerStatusDeleted ContainerStatus = "deleted"
 )
 
+// Artifact describes an artifact in the agent's artifact cache.
+type Artifact struct {
+	URL        string    `json:"url"`
+	SHA256     string    `json:"sha256,omitempty"`
+	Path       string    `json:"path"`
+	Size       int64     `json:"size"` // bytes
+	LastUsed   time.Time `json:"last_used"`
+	Containers []string  `json:"containers"` // IDs of the containers using the artifact
+}
+
+// Tombstone records a finished or failed container, which the agent
+// garbage-collected, in its final state.
+type Tombstone struct {
+	ID                    string `json:"container_id"`
+	ContainerStatus       `json:"status"`
+	ContainerProcessState `json:"process_state"`
+	Reaped                time.Time `json:"reaped"`
+}
+
+// HealthStatus describes the outcome of the health checks of a container.
+type HealthStatus string
+
+const (
+	// HealthStatusUnknown indicates the container has no health checks, or
+	// they haven't been executed yet, e.g. because the container isn't
+	// running or the initial delay hasn't elapsed.
+	HealthStatusUnknown HealthStatus = "unknown"
+
+	// HealthStatusHealthy indicates the most recent probe of every health
+	// check passed.
+	HealthStatusHealthy HealthStatus = "healthy"
+
+	// HealthStatusUnhealthy indicates the most recent probe of at least one
+	// health check failed.
+	HealthStatusUnhealthy HealthStatus = "unhealthy"
+)
+
+// ContainerHealth contains the result of executing the health checks of a
+// running container.
+type ContainerHealth struct {
+	HealthStatus `json:"status"`
+
+	// Since records when the container entered its current HealthStatus.
+	Since time.Time `json:"since"`
+
+	// LastCheck records when the most recent probe was executed, and
+	// LastResult describes its outcome.
+	LastCheck  time.Time `json:"last_check"`
+	LastResult string    `json:"last_result"`
+
+	// Failures is a counter of failed probes since the container was last
+	// healthy. It's reset whenever the container becomes healthy again.
+	Failures uint `json:"failures"`
+}
+
 // JSONDuration allows specification of time.Duration as strings in JSON-
 // serialized structs. For example, "250ms", "5s", "30m".
 type JSONDuration struct{ time.Duration }
@@ -333,9 +721,18 @@ type ContainerProcessState struct {
 	Restarting bool `json:"restarting"`
 
 	// Err records a non-recoverable error which prevented the container from
-	// starting. It will only be set if both Up and Restarting are false.
+	// being prepared or starting, or why it's crash-looping. It will only be
+	// set if both Up and Restarting are false.
 	Err string `json:"err,omitempty"`
 
+	// CrashLooping signals that the container process exited too often
+	// within its restart window, and won't be restarted again.
+	CrashLooping bool `json:"crash_looping,omitempty"`
+
+	// PreStop records the outcome of the container's pre-stop hook, once it
+	// has been run.
+	PreStop *PreStopStatus `json:"pre_stop,omitempty"`
+
 	// ContainerExitStatus contains the last exit status of the container. It
 	// will only be present if Up is false.
 	ContainerExitStatus `json:"container_exit_status,omitempty"`
@@ -350,6 +747,12 @@ type ContainerProcessState struct {
 	ContainerMetrics `json:"container_metrics"`
 }
 
+// PreStopStatus contains the outcome of a container's pre-stop hook.
+type PreStopStatus struct {
+	Succeeded bool   `json:"succeeded"`
+	Err       string `json:"err,omitempty"` // why the hook failed, or that it timed out
+}
+
 // ContainerExitStatus contains the exit status of a container.
 type ContainerExitStatus struct {
 	// Exited is true when the container exited on its own, or in response to
@@ -370,7 +773,10 @@ type ContainerExitStatus struct {
 // ContainerMetrics contains detailed historical information about a unique
 // container. ContainerMetrics are tracked across restarts.
 type ContainerMetrics struct {
-	CPUTime     uint64 `json:"cpu_time"`     // total counter of cpu time
-	MemoryUsage uint64 `json:"memory_usage"` // memory usage in bytes
-	MemoryLimit uint64 `json:"memory_limit"` // memory limit in bytes
+	CPUTime             uint64 `json:"cpu_time"`              // total counter of cpu time
+	CPUPeriods          uint64 `json:"cpu_periods"`           // total counter of enforcement periods with CPU activity
+	CPUThrottledPeriods uint64 `json:"cpu_throttled_periods"` // total counter of periods the container was throttled in
+	CPUThrottledTime    uint64 `json:"cpu_throttled_time"`    // total counter of time throttled, in nanoseconds
+	MemoryUsage         uint64 `json:"memory_usage"`          // memory usage in bytes
+	MemoryLimit         uint64 `json:"memory_limit"`          // memory limit in bytes
 }
@@ -0,0 +1,74 @@
+package agent
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestResourcesValidCPUSet(t *testing.T) {
+	for _, input := range []struct {
+		Resources
+		valid bool
+	}{
+		{Resources{CPU: 1}, true},
+		{Resources{CPU: 1, CPUSet: "3"}, true},
+		{Resources{CPU: 4, CPUSet: "0-2,6"}, true},
+		{Resources{CPU: 2.5, CPUSet: "0,1"}, false}, // too few CPUs
+		{Resources{CPU: 1, CPUSet: "0-"}, false},
+		{Resources{CPU: 1, CPUSet: "3-1"}, false},
+		{Resources{CPU: 1, CPUSet: "a"}, false},
+	} {
+		if want, have := input.valid, input.Resources.Valid() == nil; want != have {
+			t.Errorf("%+v: want valid %v, have %v", input.Resources, want, have)
+		}
+	}
+}
+
+func TestRestartJSON(t *testing.T) {
+	for _, input := range []struct {
+		Restart
+		encoded string
+	}{
+		{Restart{Policy: AlwaysRestart}, `"always"`},
+		{
+			Restart{Policy: OnFailureRestart, MaxRestarts: 5, Window: JSONDuration{time.Minute}},
+			`{"policy":"on-failure","backoff":"0s","max_backoff":"0s","max_restarts":5,"window":"1m0s"}`,
+		},
+	} {
+		buf, err := json.Marshal(input.Restart)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, have := input.encoded, string(buf); want != have {
+			t.Errorf("want %s, have %s", want, have)
+		}
+
+		var restart Restart
+		if err := json.Unmarshal(buf, &restart); err != nil {
+			t.Fatal(err)
+		}
+
+		if want, have := input.Restart, restart; want != have {
+			t.Errorf("want %+v, have %+v", want, have)
+		}
+	}
+}
+
+func TestPreStopValid(t *testing.T) {
+	for _, input := range []struct {
+		PreStop
+		valid bool
+	}{
+		{PreStop{HTTPPort: "http", HTTPPath: "/-/drain"}, true},
+		{PreStop{Exec: []string{"./drain"}}, true},
+		{PreStop{}, false},
+		{PreStop{HTTPPort: "http", Exec: []string{"./drain"}}, false},
+		{PreStop{HTTPPath: "/-/drain", Exec: []string{"./drain"}}, false},
+	} {
+		if err := input.PreStop.Valid(); (err == nil) != input.valid {
+			t.Errorf("%+v: want valid %v, got error %v", input.PreStop, input.valid, err)
+		}
+	}
+}
@@ -1,14 +1,18 @@
 package agent
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -33,17 +37,35 @@ const (
 	// APIDestroyContainerPath conforms to the agent API spec.
 	APIDestroyContainerPath = "/containers/:id"
 
+	// APIReplaceContainerPath conforms to the agent API spec. The ID of the
+	// container to replace is passed in the replace query parameter.
+	APIReplaceContainerPath = "/containers/:id"
+
 	// APIStartContainerPath conforms to the agent API spec.
 	APIStartContainerPath = "/containers/:id/start"
 
 	// APIStopContainerPath conforms to the agent API spec.
 	APIStopContainerPath = "/containers/:id/stop"
 
+	// APISignalContainerPath conforms to the agent API spec. The name of the
+	// signal is passed in the signal query parameter.
+	APISignalContainerPath = "/containers/:id/signal"
+
+	// APIExecContainerPath conforms to the agent API spec. The command is
+	// passed in repeated cmd query parameters.
+	APIExecContainerPath = "/containers/:id/exec"
+
 	// APIGetContainerLogPath conforms to the agent API spec.
 	APIGetContainerLogPath = "/containers/:id/log"
 
 	// APIGetResourcesPath conforms to the agent API spec.
 	APIGetResourcesPath = "/resources"
+
+	// APIDrainPath conforms to the agent API spec.
+	APIDrainPath = "/drain"
+
+	// APIUndrainPath conforms to the agent API spec.
+	APIUndrainPath = "/undrain"
 )
 
 var (
@@ -63,10 +85,26 @@ var (
 	// container that's already in ContainerStatusFinished.
 	ErrContainerAlreadyStopped = errors.New("container already stopped")
 
+	// ErrContainerNotRunning is returned when clients try to Signal a
+	// container that isn't in ContainerStatusRunning.
+	ErrContainerNotRunning = errors.New("container not running")
+
+	// ErrInsufficientResources is returned when clients try to Put a
+	// container that doesn't fit in the agent's remaining resources.
+	ErrInsufficientResources = errors.New("insufficient resources")
+
+	// ErrAgentDraining is returned when clients try to Put a container on a
+	// draining agent.
+	ErrAgentDraining = errors.New("agent draining")
+
 	// ErrTimeout is returned when clients try to Wait for container status too long
 	ErrTimeout = errors.New("timeout")
 )
 
+// StatusInsufficientResources is the HTTP status the agent rejects containers
+// with, which don't fit in its remaining resources (Insufficient Storage).
+const StatusInsufficientResources = 507
+
 type client struct{ url.URL }
 
 var _ Agent = client{}
@@ -125,6 +163,13 @@ func (c client) Containers() (map[string]ContainerInstance, error) {
 
 // Events implements the Agent interface.
 func (c client) Events() (<-chan StateEvent, Stopper, error) {
+	return c.EventsSince(0)
+}
+
+// EventsSince implements the Agent interface. The first event reflects the
+// containers which changed after the generation, or all of them if the agent
+// can't tell which did. A zero generation always yields all of them.
+func (c client) EventsSince(generation uint64) (<-chan StateEvent, Stopper, error) {
 	c.URL.Path = APIVersionPrefix + APIListContainersPath
 
 	req, err := http.NewRequest("GET", c.URL.String(), nil)
@@ -134,6 +179,10 @@ func (c client) Events() (<-chan StateEvent, Stopper, error) {
 	req.Header.Set("Accept", "text/event-stream")
 	req.Header.Set("Cache-Control", "no-cache")
 
+	if generation > 0 {
+		req.Header.Set("Last-Event-ID", strconv.FormatUint(generation, 10))
+	}
+
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, nil, err
@@ -178,6 +227,9 @@ func (c client) Events() (<-chan StateEvent, Stopper, error) {
 				continue
 			}
 
+			state.Generation, _ = strconv.ParseUint(event.ID, 10, 64)
+			state.Heartbeat = event.Type == HeartbeatEvent
+
 			statec <- state
 		}
 	}()
@@ -218,6 +270,40 @@ func (c client) Resources() (HostResources, error) {
 	}
 }
 
+// Drain implements the Agent interface.
+func (c client) Drain() error {
+	return c.setDraining(APIDrainPath)
+}
+
+// Undrain implements the Agent interface.
+func (c client) Undrain() error {
+	return c.setDraining(APIUndrainPath)
+}
+
+func (c client) setDraining(path string) error {
+	c.URL.Path = APIVersionPrefix + path
+
+	req, err := http.NewRequest("POST", c.URL.String(), nil)
+	if err != nil {
+		return fmt.Errorf("problem constructing HTTP request (%s)", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("agent unavailable (%s)", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		return nil
+
+	default:
+		buf, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("HTTP %d (%s)", resp.StatusCode, bytes.TrimSpace(buf))
+	}
+}
+
 // Put implements the Agent interface.
 func (c client) Put(id string, cfg ContainerConfig) error {
 	var body bytes.Buffer
@@ -240,12 +326,18 @@ func (c client) Put(id string, cfg ContainerConfig) error {
 	defer resp.Body.Close()
 
 	switch resp.StatusCode {
-	case http.StatusCreated:
+	case http.StatusCreated, http.StatusAccepted:
 		return nil
 
 	case http.StatusConflict:
 		return ErrContainerAlreadyExists
 
+	case StatusInsufficientResources:
+		return ErrInsufficientResources
+
+	case http.StatusServiceUnavailable:
+		return ErrAgentDraining
+
 	default:
 		buf, _ := ioutil.ReadAll(resp.Body)
 		return fmt.Errorf("HTTP %d (%s)", resp.StatusCode, bytes.TrimSpace(buf))
@@ -378,67 +470,284 @@ func (c client) Stop(id string) error {
 	}
 }
 
-// Replace implements the Agent interface.
-func (c client) Replace(newID, oldID string) error {
-	return fmt.Errorf("replace is not implemented or used by the harpoon scheduler")
+// Signal implements the Agent interface.
+func (c client) Signal(id, signal string) error {
+	c.URL.Path = APIVersionPrefix + APISignalContainerPath
+	c.URL.Path = strings.Replace(c.URL.Path, ":id", id, 1)
+	c.URL.RawQuery = url.Values{"signal": []string{signal}}.Encode()
+
+	req, err := http.NewRequest("POST", c.URL.String(), nil)
+	if err != nil {
+		return fmt.Errorf("problem constructing HTTP request (%s)", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("agent unavailable (%s)", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusAccepted:
+		return nil
+
+	case http.StatusNotFound:
+		return ErrContainerNotExist
+
+	case http.StatusConflict:
+		return ErrContainerNotRunning
+
+	default:
+		buf, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("HTTP %d (%s)", resp.StatusCode, bytes.TrimSpace(buf))
+	}
 }
 
-// Log implements the Agent interface.
-func (c client) Log(id string, history int) (<-chan string, Stopper, error) {
-	c.URL.Path = APIVersionPrefix + APIGetContainerLogPath
+// Exec implements the Agent interface.
+func (c client) Exec(id string, cmd []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	c.URL.Path = APIVersionPrefix + APIExecContainerPath
 	c.URL.Path = strings.Replace(c.URL.Path, ":id", id, 1)
-	c.URL.RawQuery = fmt.Sprintf("history=%d", history)
-	req, err := http.NewRequest("GET", c.URL.String(), nil)
+	c.URL.RawQuery = url.Values{"cmd": cmd}.Encode()
+
+	req, err := http.NewRequest("POST", c.URL.String(), nil)
 	if err != nil {
-		return nil, nil, fmt.Errorf("problem constructing HTTP request (%s)", err)
+		return -1, fmt.Errorf("problem constructing HTTP request (%s)", err)
 	}
-	req.Header.Set("Accept", "text/event-stream")
 
-	resp, err := http.DefaultClient.Do(req)
+	// The agent hijacks the connection, so we can't use an http.Client.
+	conn, err := net.Dial("tcp", c.URL.Host)
 	if err != nil {
-		return nil, nil, fmt.Errorf("agent unavailable (%s)", err)
+		return -1, fmt.Errorf("agent unavailable (%s)", err)
+	}
+	defer conn.Close()
+
+	if err := req.Write(conn); err != nil {
+		return -1, fmt.Errorf("agent unavailable (%s)", err)
+	}
+
+	r := bufio.NewReader(conn)
+
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		return -1, fmt.Errorf("agent unavailable (%s)", err)
 	}
-	// Because we're streaming, we close the body in a different way.
 
 	switch resp.StatusCode {
 	case http.StatusOK:
-		c, stop := make(chan string), make(chan struct{})
-		go func() {
-			defer resp.Body.Close()
-			defer close(c)
 
-			dec := eventsource.NewDecoder(resp.Body)
+	case http.StatusNotFound:
+		return -1, ErrContainerNotExist
+
+	case http.StatusConflict:
+		return -1, ErrContainerNotRunning
+
+	default:
+		buf, _ := ioutil.ReadAll(resp.Body)
+		return -1, fmt.Errorf("HTTP %d (%s)", resp.StatusCode, bytes.TrimSpace(buf))
+	}
+
+	// Closing our side of the connection signals EOF on the command's stdin.
+	go func() {
+		if stdin != nil {
+			io.Copy(conn, stdin)
+		}
+
+		if c, ok := conn.(*net.TCPConn); ok {
+			c.CloseWrite()
+		}
+	}()
+
+	for {
+		stream, p, err := ReadExecFrame(r)
+		if err != nil {
+			return -1, fmt.Errorf("exec stream: %s", err)
+		}
+
+		switch stream {
+		case ExecStdout:
+			stdout.Write(p)
+
+		case ExecStderr:
+			stderr.Write(p)
+
+		case ExecExit:
+			return strconv.Atoi(string(p))
+
+		case ExecError:
+			return -1, errors.New(string(p))
+		}
+	}
+}
+
+// Replace implements the Agent interface.
+func (c client) Replace(newID, oldID string, cfg ContainerConfig) error {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(cfg); err != nil {
+		return fmt.Errorf("problem encoding container config (%s)", err)
+	}
+
+	c.URL.Path = APIVersionPrefix + APIReplaceContainerPath
+	c.URL.Path = strings.Replace(c.URL.Path, ":id", newID, 1)
+	c.URL.RawQuery = url.Values{"replace": []string{oldID}}.Encode()
+
+	req, err := http.NewRequest("PUT", c.URL.String(), &body)
+	if err != nil {
+		return fmt.Errorf("problem constructing HTTP request (%s)", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("agent unavailable (%s)", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusAccepted:
+		return nil
+
+	case http.StatusNotFound:
+		return ErrContainerNotExist
+
+	case http.StatusConflict:
+		return ErrContainerAlreadyExists
+
+	case StatusInsufficientResources:
+		return ErrInsufficientResources
+
+	case http.StatusServiceUnavailable:
+		return ErrAgentDraining
+
+	default:
+		buf, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("HTTP %d (%s)", resp.StatusCode, bytes.TrimSpace(buf))
+	}
+}
+
+// Log implements the Agent interface. If the stream is interrupted, Log
+// reconnects, and resumes after the last line received. Lines which are no
+// longer available are replaced by a line describing them (see LogGap). The
+// stream ends when the container no longer exists.
+func (c client) Log(id string, history int) (<-chan string, Stopper, error) {
+	resp, err := c.logStream(id, history, "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	linec, stop := make(chan string), make(chan struct{})
+
+	go func() {
+		defer close(linec)
+
+		var lastID string
+
+		for {
+			var ok bool
+
+			lastID, ok = readLog(resp.Body, lastID, linec, stop)
+			resp.Body.Close()
+
+			if !ok {
+				return
+			}
 
 			for {
-				var event eventsource.Event
-				if err := dec.Decode(&event); err != nil {
+				select {
+				case <-stop:
 					return
+				case <-time.After(logReconnectInterval):
 				}
 
-				lines := []string{}
-
-				if err := json.Unmarshal(event.Data, &lines); err != nil {
+				resp, err = c.logStream(id, 0, lastID)
+				if err == ErrContainerNotExist {
 					return
 				}
 
-				for _, line := range lines {
-					select {
-					case c <- string(line):
-					case <-stop:
-						return
-					}
+				if err == nil {
+					break
 				}
 			}
-		}()
-		return c, stopperChan(stop), nil
+		}
+	}()
+
+	return linec, stopperChan(stop), nil
+}
+
+// logReconnectInterval is how long Log waits before reconnecting.
+const logReconnectInterval = time.Second
+
+// logStream requests the log stream of a container, resuming after the line
+// lastID, if given.
+func (c client) logStream(id string, history int, lastID string) (*http.Response, error) {
+	c.URL.Path = APIVersionPrefix + APIGetContainerLogPath
+	c.URL.Path = strings.Replace(c.URL.Path, ":id", id, 1)
+	c.URL.RawQuery = fmt.Sprintf("history=%d", history)
+	req, err := http.NewRequest("GET", c.URL.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("problem constructing HTTP request (%s)", err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("agent unavailable (%s)", err)
+	}
+	// Because we're streaming, we close the body in a different way.
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp, nil
 
 	case http.StatusNotFound:
-		return nil, nil, ErrContainerNotExist
+		resp.Body.Close()
+		return nil, ErrContainerNotExist
 
 	default:
 		defer resp.Body.Close()
 		buf, _ := ioutil.ReadAll(resp.Body)
-		return nil, nil, fmt.Errorf("HTTP %d (%s)", resp.StatusCode, bytes.TrimSpace(buf))
+		return nil, fmt.Errorf("HTTP %d (%s)", resp.StatusCode, bytes.TrimSpace(buf))
+	}
+}
+
+// readLog sends the lines of a log stream to linec until the stream ends, and
+// returns the ID of the last line read. It returns false if it was stopped.
+func readLog(r io.Reader, lastID string, linec chan<- string, stop <-chan struct{}) (string, bool) {
+	dec := eventsource.NewDecoder(r)
+
+	for {
+		var event eventsource.Event
+		if err := dec.Decode(&event); err != nil {
+			return lastID, true
+		}
+
+		lines := []string{}
+
+		if event.Type == LogGapEvent {
+			var gap LogGap
+
+			if err := json.Unmarshal(event.Data, &gap); err != nil {
+				return lastID, true
+			}
+
+			lines = append(lines, gap.String())
+		} else if err := json.Unmarshal(event.Data, &lines); err != nil {
+			return lastID, true
+		}
+
+		for _, line := range lines {
+			select {
+			case linec <- line:
+			case <-stop:
+				return lastID, false
+			}
+		}
+
+		if event.ID != "" {
+			lastID = event.ID
+		}
 	}
 }
 
@@ -0,0 +1,111 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Constraint restricts the agents a container may be placed on, by the
+// labels the agents are started with (see HostResources).
+type Constraint struct {
+	Label    string             `json:"label"`
+	Operator ConstraintOperator `json:"operator"`
+	Values   []string           `json:"values,omitempty"`
+}
+
+// ConstraintOperator describes how a Constraint compares an agent's label
+// with the Constraint's values.
+type ConstraintOperator string
+
+const (
+	// ConstraintEquals requires the label to be set to the only value.
+	ConstraintEquals ConstraintOperator = "=="
+
+	// ConstraintNotEquals requires the label not to be set to the only
+	// value. Agents without the label satisfy it.
+	ConstraintNotEquals ConstraintOperator = "!="
+
+	// ConstraintIn requires the label to be set to one of the values.
+	ConstraintIn ConstraintOperator = "in"
+
+	// ConstraintExists requires the label to be set, to any value. It takes
+	// no values.
+	ConstraintExists ConstraintOperator = "exists"
+)
+
+// Valid performs a validation check, to ensure invalid structures may be
+// detected as early as possible.
+func (c Constraint) Valid() error {
+	if c.Label == "" {
+		return fmt.Errorf("label not set")
+	}
+
+	switch c.Operator {
+	case ConstraintEquals, ConstraintNotEquals:
+		if len(c.Values) != 1 {
+			return fmt.Errorf("%q takes exactly 1 value, not %d", c.Operator, len(c.Values))
+		}
+
+	case ConstraintIn:
+		if len(c.Values) == 0 {
+			return fmt.Errorf("%q takes at least 1 value", c.Operator)
+		}
+
+	case ConstraintExists:
+		if len(c.Values) != 0 {
+			return fmt.Errorf("%q takes no values", c.Operator)
+		}
+
+	default:
+		return fmt.Errorf(
+			"operator %q should be %s, %s, %s or %s",
+			c.Operator,
+			ConstraintEquals,
+			ConstraintNotEquals,
+			ConstraintIn,
+			ConstraintExists,
+		)
+	}
+
+	return nil
+}
+
+// Match reports whether an agent with the given labels satisfies the
+// constraint.
+func (c Constraint) Match(labels map[string]string) bool {
+	value, ok := labels[c.Label]
+
+	switch c.Operator {
+	case ConstraintEquals:
+		return ok && value == c.Values[0]
+
+	case ConstraintNotEquals:
+		return !ok || value != c.Values[0]
+
+	case ConstraintIn:
+		for _, v := range c.Values {
+			if ok && value == v {
+				return true
+			}
+		}
+		return false
+
+	case ConstraintExists:
+		return ok
+	}
+
+	return false
+}
+
+// String returns the constraint as an expression, e.g. `zone in (a, b)`.
+func (c Constraint) String() string {
+	switch c.Operator {
+	case ConstraintExists:
+		return fmt.Sprintf("%s exists", c.Label)
+
+	case ConstraintIn:
+		return fmt.Sprintf("%s in (%s)", c.Label, strings.Join(c.Values, ", "))
+	}
+
+	return fmt.Sprintf("%s %s %s", c.Label, c.Operator, strings.Join(c.Values, ", "))
+}
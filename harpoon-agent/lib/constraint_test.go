@@ -0,0 +1,48 @@
+package agent
+
+import "testing"
+
+func TestConstraintValid(t *testing.T) {
+	for i, input := range []struct {
+		Constraint
+		valid bool
+	}{
+		{Constraint{Label: "rack", Operator: ConstraintEquals, Values: []string{"r1"}}, true},
+		{Constraint{Label: "rack", Operator: ConstraintEquals}, false},
+		{Constraint{Label: "rack", Operator: ConstraintNotEquals, Values: []string{"r1", "r2"}}, false},
+		{Constraint{Label: "zone", Operator: ConstraintIn, Values: []string{"a", "b"}}, true},
+		{Constraint{Label: "zone", Operator: ConstraintIn}, false},
+		{Constraint{Label: "ssd", Operator: ConstraintExists}, true},
+		{Constraint{Label: "ssd", Operator: ConstraintExists, Values: []string{"yes"}}, false},
+		{Constraint{Operator: ConstraintExists}, false},
+		{Constraint{Label: "rack", Operator: "~=", Values: []string{"r1"}}, false},
+	} {
+		if want, have := input.valid, input.Constraint.Valid() == nil; want != have {
+			t.Errorf("%d: want valid %v, have %v", i, want, have)
+		}
+	}
+}
+
+func TestConstraintMatch(t *testing.T) {
+	labels := map[string]string{"rack": "r1", "zone": "b", "ssd": ""}
+
+	for _, input := range []struct {
+		Constraint
+		match bool
+	}{
+		{Constraint{Label: "rack", Operator: ConstraintEquals, Values: []string{"r1"}}, true},
+		{Constraint{Label: "rack", Operator: ConstraintEquals, Values: []string{"r2"}}, false},
+		{Constraint{Label: "rack", Operator: ConstraintNotEquals, Values: []string{"r1"}}, false},
+		{Constraint{Label: "rack", Operator: ConstraintNotEquals, Values: []string{"r2"}}, true},
+		{Constraint{Label: "env", Operator: ConstraintNotEquals, Values: []string{"prod"}}, true},
+		{Constraint{Label: "zone", Operator: ConstraintIn, Values: []string{"a", "b"}}, true},
+		{Constraint{Label: "zone", Operator: ConstraintIn, Values: []string{"c"}}, false},
+		{Constraint{Label: "env", Operator: ConstraintIn, Values: []string{""}}, false},
+		{Constraint{Label: "ssd", Operator: ConstraintExists}, true},
+		{Constraint{Label: "gpu", Operator: ConstraintExists}, false},
+	} {
+		if want, have := input.match, input.Constraint.Match(labels); want != have {
+			t.Errorf("%s: want match %v, have %v", input.Constraint, want, have)
+		}
+	}
+}
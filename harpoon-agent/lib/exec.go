@@ -0,0 +1,60 @@
+package agent
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// After a successful exec request, the agent hijacks the connection. The
+// client writes the command's stdin to it, and closes its write side at EOF.
+// The agent writes the command's output to it as frames, ending with either
+// an exit or an error frame. Each frame is a header of the stream type and 3
+// zero bytes, and the big-endian uint32 length of the payload, followed by
+// the payload.
+const (
+	ExecStdout byte = 1 // payload is output
+	ExecStderr byte = 2 // payload is output
+	ExecExit   byte = 3 // payload is the decimal exit status of the command
+	ExecError  byte = 4 // payload is why the command couldn't be run
+
+	// ExecContentType is the content type of the exec response, after which
+	// the frames follow.
+	ExecContentType = "application/vnd.harpoon.exec-stream"
+
+	maxExecFrameSize = 1 << 20
+)
+
+// WriteExecFrame writes a frame of the stream type with payload p.
+func WriteExecFrame(w io.Writer, stream byte, p []byte) error {
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(p)))
+
+	if _, err := w.Write(append(header, p...)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ReadExecFrame reads the next frame, and returns its stream type and
+// payload.
+func ReadExecFrame(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[4:])
+	if size > maxExecFrameSize {
+		return 0, nil, fmt.Errorf("exec frame too large (%d bytes)", size)
+	}
+
+	p := make([]byte, size)
+	if _, err := io.ReadFull(r, p); err != nil {
+		return 0, nil, err
+	}
+
+	return header[0], p, nil
+}
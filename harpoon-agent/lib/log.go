@@ -0,0 +1,24 @@
+package agent
+
+import "fmt"
+
+// Log streams are event streams, with each line numbered in sequence and
+// carried as the event ID. A client which reconnects with the Last-Event-ID
+// header receives the lines following that one. Sequence numbers start over
+// when the agent restarts.
+//
+// If lines a client hasn't received are no longer available, a gap event is
+// sent instead, with a JSON-encoded LogGap as its data.
+const LogGapEvent = "gap"
+
+// LogGap describes log lines which were lost to a client, by their inclusive
+// range of sequence numbers.
+type LogGap struct {
+	From uint64 `json:"from"`
+	To   uint64 `json:"to"`
+}
+
+// String returns the line the client delivers in place of the lost ones.
+func (g LogGap) String() string {
+	return fmt.Sprintf("harpoon-agent: %d log lines lost (%d-%d)", g.To-g.From+1, g.From, g.To)
+}
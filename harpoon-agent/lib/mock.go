@@ -24,12 +24,17 @@ type Mock struct {
 	hostResources         HostResources
 	listContainersCount   int32
 	createContainerCount  int32
+	replaceContainerCount int32
 	getContainerCount     int32
 	destroyContainerCount int32
 	startContainerCount   int32
 	stopContainerCount    int32
+	signalContainerCount  int32
+	execContainerCount    int32
 	getContainerLogCount  int32
 	getResourcesCount     int32
+	drainCount            int32
+	undrainCount          int32
 }
 
 // NewMock returns a new Mock, designed to be passed to httptest.NewServer.
@@ -52,8 +57,12 @@ func NewMock() *Mock {
 	m.Router.DELETE(APIVersionPrefix+APIDestroyContainerPath, m.destroyContainer)
 	m.Router.POST(APIVersionPrefix+APIStartContainerPath, m.startContainer)
 	m.Router.POST(APIVersionPrefix+APIStopContainerPath, m.stopContainer)
+	m.Router.POST(APIVersionPrefix+APISignalContainerPath, m.signalContainer)
+	m.Router.POST(APIVersionPrefix+APIExecContainerPath, m.execContainer)
 	m.Router.GET(APIVersionPrefix+APIGetContainerLogPath, m.getContainerLog)
 	m.Router.GET(APIVersionPrefix+APIGetResourcesPath, m.getResources)
+	m.Router.POST(APIVersionPrefix+APIDrainPath, m.drain)
+	m.Router.POST(APIVersionPrefix+APIUndrainPath, m.undrain)
 
 	return m
 }
@@ -169,6 +178,11 @@ func (m *Mock) getContainerEvents(w http.ResponseWriter, r *http.Request, p http
 }
 
 func (m *Mock) createContainer(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	if r.URL.Query().Get("replace") != "" {
+		m.replaceContainer(w, r, p)
+		return
+	}
+
 	defer atomic.AddInt32(&m.createContainerCount, 1)
 
 	id := p.ByName("id")
@@ -190,16 +204,89 @@ func (m *Mock) createContainer(w http.ResponseWriter, r *http.Request, p httprou
 	}
 
 	// PUT also starts.
-	func() {
+	if ok := func() bool {
 		m.Lock()
 		defer m.Unlock()
+		if m.hostResources.Draining {
+			return false
+		}
 		m.instances[id] = instance
 		m.hostResources.CPU.Reserved += instance.CPU
 		m.hostResources.Mem.Reserved += instance.Mem
 		broadcast(m.subscribers, StateEvent{Resources: m.hostResources, Containers: m.instances})
-	}()
+		return true
+	}(); !ok {
+		http.Error(w, "agent draining", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (m *Mock) replaceContainer(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	defer atomic.AddInt32(&m.replaceContainerCount, 1)
+
+	var (
+		newID = p.ByName("id")
+		oldID = r.URL.Query().Get("replace")
+	)
+
+	if newID == "" {
+		http.Error(w, fmt.Sprintf("%q required", "id"), http.StatusBadRequest)
+		return
+	}
+
+	var config ContainerConfig
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	if m.hostResources.Draining {
+		http.Error(w, "agent draining", http.StatusServiceUnavailable)
+		return
+	}
+
+	if _, ok := m.instances[newID]; ok {
+		http.Error(w, fmt.Sprintf("%q already exists", newID), http.StatusConflict)
+		return
+	}
+
+	old, ok := m.instances[oldID]
+	if !ok {
+		http.Error(w, fmt.Sprintf("%q not present", oldID), http.StatusNotFound)
+		return
+	}
+
+	if old.ContainerStatus != ContainerStatusRunning {
+		http.Error(w, fmt.Sprintf("%q not running (%s), can't replace", oldID, old.ContainerStatus), http.StatusPreconditionFailed)
+		return
+	}
+
+	// The new container comes up immediately, so the old one is destroyed
+	// straight away.
+	instance := ContainerInstance{
+		ID:              newID,
+		ContainerStatus: ContainerStatusRunning,
+		ContainerConfig: config,
+	}
+
+	m.instances[newID] = instance
+	m.hostResources.CPU.Reserved += instance.CPU
+	m.hostResources.Mem.Reserved += instance.Mem
+	broadcast(m.subscribers, StateEvent{Resources: m.hostResources, Containers: m.instances})
+
+	old.ContainerStatus = ContainerStatusDeleted
+	m.instances[oldID] = old
+	m.hostResources.CPU.Reserved -= old.CPU
+	m.hostResources.Mem.Reserved -= old.Mem
+	broadcast(m.subscribers, StateEvent{Resources: m.hostResources, Containers: m.instances})
+	delete(m.instances, oldID)
 
-	w.WriteHeader(http.StatusCreated)
+	w.WriteHeader(http.StatusAccepted)
 }
 
 func (m *Mock) getContainer(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
@@ -305,6 +392,43 @@ func (m *Mock) stopContainer(w http.ResponseWriter, r *http.Request, p httproute
 	}()
 }
 
+func (m *Mock) signalContainer(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	defer atomic.AddInt32(&m.signalContainerCount, 1)
+
+	id := p.ByName("id")
+	if id == "" {
+		http.Error(w, fmt.Sprintf("%q required", "id"), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := ParseSignal(r.URL.Query().Get("signal")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m.RLock()
+	defer m.RUnlock()
+
+	instance, ok := m.instances[id]
+	if !ok {
+		http.Error(w, fmt.Sprintf("%q unknown; can't signal", id), http.StatusNotFound)
+		return
+	}
+
+	if instance.ContainerStatus != ContainerStatusRunning {
+		http.Error(w, fmt.Sprintf("%q not running (%s), can't signal", id, instance.ContainerStatus), http.StatusConflict)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted) // The mock has no processes to signal.
+}
+
+func (m *Mock) execContainer(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	defer atomic.AddInt32(&m.execContainerCount, 1)
+
+	http.Error(w, fmt.Sprintf("exec not yet implemented"), http.StatusNotImplemented)
+}
+
 func (m *Mock) getContainerLog(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	defer atomic.AddInt32(&m.getContainerLogCount, 1)
 
@@ -313,5 +437,27 @@ func (m *Mock) getContainerLog(w http.ResponseWriter, r *http.Request, p httprou
 
 func (m *Mock) getResources(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	defer atomic.AddInt32(&m.getResourcesCount, 1)
+
+	m.RLock()
+	defer m.RUnlock()
 	json.NewEncoder(w).Encode(m.hostResources)
 }
+
+func (m *Mock) drain(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	defer atomic.AddInt32(&m.drainCount, 1)
+	m.setDraining(true)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (m *Mock) undrain(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	defer atomic.AddInt32(&m.undrainCount, 1)
+	m.setDraining(false)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (m *Mock) setDraining(draining bool) {
+	m.Lock()
+	defer m.Unlock()
+	m.hostResources.Draining = draining
+	broadcast(m.subscribers, StateEvent{Resources: m.hostResources, Containers: map[string]ContainerInstance{}})
+}
@@ -8,6 +8,7 @@ import (
 	"strings"
 	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestMockAgent(t *testing.T) {
@@ -29,12 +30,17 @@ func TestMockAgent(t *testing.T) {
 	}{
 		{"GET", APIVersionPrefix + r.Replace(APIListContainersPath), &a.listContainersCount},
 		{"PUT", APIVersionPrefix + r.Replace(APICreateContainerPath), &a.createContainerCount},
+		{"PUT", APIVersionPrefix + r.Replace(APIReplaceContainerPath) + "?replace=foobar", &a.replaceContainerCount},
 		{"GET", APIVersionPrefix + r.Replace(APIGetContainerPath), &a.getContainerCount},
 		{"DELETE", APIVersionPrefix + r.Replace(APIDestroyContainerPath), &a.destroyContainerCount},
 		{"POST", APIVersionPrefix + r.Replace(APIStartContainerPath), &a.startContainerCount},
 		{"POST", APIVersionPrefix + r.Replace(APIStopContainerPath), &a.stopContainerCount},
+		{"POST", APIVersionPrefix + r.Replace(APISignalContainerPath) + "?signal=HUP", &a.signalContainerCount},
+		{"POST", APIVersionPrefix + r.Replace(APIExecContainerPath) + "?cmd=true", &a.execContainerCount},
 		{"GET", APIVersionPrefix + r.Replace(APIGetContainerLogPath), &a.getContainerLogCount},
 		{"GET", APIVersionPrefix + r.Replace(APIGetResourcesPath), &a.getResourcesCount},
+		{"POST", APIVersionPrefix + r.Replace(APIDrainPath), &a.drainCount},
+		{"POST", APIVersionPrefix + r.Replace(APIUndrainPath), &a.undrainCount},
 	} {
 		method, path, count := tuple.method, tuple.path, tuple.count
 		pre := atomic.LoadInt32(count)
@@ -59,3 +65,84 @@ func TestMockAgent(t *testing.T) {
 		t.Logf("%s %s: OK (%d -> %d)", method, path, pre, post)
 	}
 }
+
+func TestMockReplace(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+
+	var (
+		a = NewMock()
+		s = httptest.NewServer(a)
+		c = MustNewClient(s.URL)
+	)
+
+	defer s.Close()
+
+	if err := c.Replace("new", "old", ContainerConfig{}); err != ErrContainerNotExist {
+		t.Fatalf("want %v, have %v", ErrContainerNotExist, err)
+	}
+
+	if err := c.Put("old", ContainerConfig{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Replace("new", "old", ContainerConfig{}); err != nil {
+		t.Fatal(err)
+	}
+
+	containers, err := c.Containers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := containers["old"]; ok {
+		t.Errorf("old container still present after replace")
+	}
+
+	if want, have := ContainerStatusRunning, containers["new"].ContainerStatus; want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+
+	if err := c.Replace("new", "old", ContainerConfig{}); err != ErrContainerAlreadyExists {
+		t.Errorf("want %v, have %v", ErrContainerAlreadyExists, err)
+	}
+}
+
+func TestMockSignal(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+
+	var (
+		a = NewMock()
+		s = httptest.NewServer(a)
+		c = MustNewClient(s.URL)
+	)
+
+	defer s.Close()
+
+	if err := c.Signal("foo", "HUP"); err != ErrContainerNotExist {
+		t.Fatalf("want %v, have %v", ErrContainerNotExist, err)
+	}
+
+	if err := c.Put("foo", ContainerConfig{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Signal("foo", "SIGUSR1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Signal("foo", "BOGUS"); err == nil {
+		t.Error("expected error for unknown signal, got none")
+	}
+
+	if err := c.Stop("foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Wait("foo", map[ContainerStatus]struct{}{ContainerStatusFinished: struct{}{}}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Signal("foo", "HUP"); err != ErrContainerNotRunning {
+		t.Errorf("want %v, have %v", ErrContainerNotRunning, err)
+	}
+}
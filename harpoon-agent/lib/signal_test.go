@@ -0,0 +1,30 @@
+package agent
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestParseSignal(t *testing.T) {
+	for name, want := range map[string]syscall.Signal{
+		"HUP":     syscall.SIGHUP,
+		"SIGUSR1": syscall.SIGUSR1,
+		"term":    syscall.SIGTERM,
+	} {
+		have, err := ParseSignal(name)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+
+		if want != have {
+			t.Errorf("%s: want %d, have %d", name, want, have)
+		}
+	}
+
+	for _, name := range []string{"", "SIG", "BOGUS", "9"} {
+		if _, err := ParseSignal(name); err == nil {
+			t.Errorf("%q: expected error, got none", name)
+		}
+	}
+}
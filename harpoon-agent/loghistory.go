@@ -0,0 +1,411 @@
+package main
+
+// Provide retrieval of the logs which svlogd persisted for a container.
+//
+// svlogd writes to the current file in a container's log directory, and
+// rotates it to @<tai64n>.s (or .u, if the rotation was unclean). The rotated
+// files, oldest first, followed by current, are the container's log history.
+// Byte offsets refer to that concatenation, which shifts when svlogd removes
+// the oldest rotated file.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// logTimestampLayout is the format of the timestamp svlogd -tt prefixes each
+// line with. The fraction of a second following it is accepted by
+// time.Parse.
+const logTimestampLayout = "2006-01-02_15:04:05"
+
+// logTailChunkSize is how many bytes are read at a time, when reading the
+// log history backwards.
+var logTailChunkSize int64 = 64 * 1024
+
+// logQuery selects lines of a container's log history.
+type logQuery struct {
+	since, until time.Time      // unbounded if zero
+	byteOffset   int64          // lines starting before it are skipped
+	offset       int            // selected lines to skip; -1 to return the last ones
+	count        int            // maximum number of lines returned
+	grep         *regexp.Regexp // lines must match, if set
+}
+
+// timed reports whether the query selects lines by their timestamp.
+func (q logQuery) timed() bool {
+	return !q.since.IsZero() || !q.until.IsZero()
+}
+
+// persistent reports whether the query can only be answered from the log
+// history on disk.
+func (q logQuery) persistent() bool {
+	return q.timed() || q.offset >= 0 || q.byteOffset > 0
+}
+
+// match reports whether a line passes the query's filters.
+func (q logQuery) match(line string) bool {
+	if q.timed() {
+		t, ok := logLineTime(line)
+		if !ok {
+			return false
+		}
+
+		if !q.since.IsZero() && t.Before(q.since) {
+			return false
+		}
+
+		if !q.until.IsZero() && t.After(q.until) {
+			return false
+		}
+	}
+
+	if q.grep != nil && !q.grep.MatchString(line) {
+		return false
+	}
+
+	return true
+}
+
+// logHistory is the result of a logQuery.
+type logHistory struct {
+	lines []string
+	next  int64 // byte offset to continue reading at
+}
+
+// readLogHistory returns the lines of the log history in logdir which the
+// query selects. The last line of current is skipped until svlogd terminates
+// it. Queries for the last lines read the history backwards, and only as far
+// as needed; others read it from the start.
+func readLogHistory(logdir string, q logQuery) (logHistory, error) {
+	files, err := logFiles(logdir)
+	if err != nil {
+		return logHistory{}, err
+	}
+
+	if !q.persistent() {
+		return readLogTail(files, q)
+	}
+
+	var (
+		h       = logHistory{lines: []string{}}
+		skipped = 0
+		pos     int64
+	)
+
+	for i, file := range files {
+		fi, err := os.Stat(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue // rotated away in the meantime
+			}
+
+			return logHistory{}, err
+		}
+
+		if pos+fi.Size() <= q.byteOffset || q.rotatedBefore(file) {
+			pos += fi.Size()
+			continue
+		}
+
+		f, err := os.Open(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return logHistory{}, err
+		}
+
+		var (
+			r     = bufio.NewReaderSize(f, 64*1024)
+			start = pos
+		)
+
+		if pos < q.byteOffset {
+			// Start at the first line at or after the offset, i.e. the one
+			// following the newline just before it.
+			skip := q.byteOffset - pos - 1
+
+			if _, err := f.Seek(skip, os.SEEK_SET); err != nil {
+				f.Close()
+				return logHistory{}, err
+			}
+
+			pos += skip
+
+			partial, err := r.ReadString('\n')
+			pos += int64(len(partial))
+
+			if err != nil {
+				f.Close()
+
+				if i < len(files)-1 {
+					pos = start + fi.Size()
+				} else {
+					pos = q.byteOffset // the line is still being written
+				}
+
+				continue
+			}
+		}
+
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				break // incomplete lines are still being written
+			}
+
+			pos += int64(len(line))
+			line = strings.TrimSuffix(line, "\n")
+
+			if !q.match(line) {
+				continue
+			}
+
+			if q.offset >= 0 && skipped < q.offset {
+				skipped++
+				continue
+			}
+
+			if q.offset >= 0 && len(h.lines) == q.count {
+				f.Close()
+				h.next = pos - int64(len(line)) - 1 // continue at this line
+				return h, nil
+			}
+
+			h.lines = append(h.lines, line)
+
+			if len(h.lines) > q.count {
+				h.lines = h.lines[1:]
+			}
+		}
+
+		f.Close()
+
+		if i < len(files)-1 {
+			// Only current can end in an incomplete line.
+			pos = start + fi.Size()
+		}
+	}
+
+	h.next = pos
+	return h, nil
+}
+
+// readLogTail returns the last lines of the log history which the query
+// selects, reading the files backwards, newest first. Older files are only
+// opened while there are too few lines.
+func readLogTail(files []string, q logQuery) (logHistory, error) {
+	var (
+		h     = logHistory{lines: []string{}}
+		sizes = make([]int64, len(files))
+		lines = []string{} // newest first
+	)
+
+	// The next byte offset follows the last complete line of current, so it
+	// depends on the size of all files.
+	for i, file := range files {
+		fi, err := os.Stat(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue // rotated away in the meantime
+			}
+
+			return logHistory{}, err
+		}
+
+		sizes[i] = fi.Size()
+
+		if i < len(files)-1 {
+			h.next += fi.Size()
+		}
+	}
+
+	for i := len(files) - 1; i >= 0; i-- {
+		if len(lines) >= q.count && i < len(files)-1 {
+			break
+		}
+
+		if sizes[i] == 0 {
+			continue
+		}
+
+		f, err := os.Open(files[i])
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return logHistory{}, err
+		}
+
+		end, err := readLinesBackwards(f, sizes[i], func(line string) bool {
+			if q.match(line) {
+				lines = append(lines, line)
+			}
+
+			return len(lines) < q.count
+		})
+		f.Close()
+
+		if err != nil {
+			return logHistory{}, err
+		}
+
+		if i == len(files)-1 {
+			h.next += end
+		}
+	}
+
+	if len(lines) > q.count {
+		lines = lines[:q.count]
+	}
+
+	for i := len(lines) - 1; i >= 0; i-- {
+		h.lines = append(h.lines, lines[i])
+	}
+
+	return h, nil
+}
+
+// readLinesBackwards calls fn with the complete lines of the first size bytes
+// of f, last first, until fn returns false. An incomplete last line, still
+// being written, is skipped. It returns the offset following the last
+// complete line.
+func readLinesBackwards(f *os.File, size int64, fn func(string) bool) (int64, error) {
+	var (
+		buf     []byte // read from off, and ending in a newline once trimmed
+		off     = size
+		end     int64
+		trimmed bool
+		newline = []byte("\n")
+	)
+
+	for off > 0 {
+		n := logTailChunkSize
+		if n > off {
+			n = off
+		}
+		off -= n
+
+		chunk := make([]byte, n, n+int64(len(buf)))
+		if _, err := f.ReadAt(chunk, off); err != nil {
+			return 0, err
+		}
+		buf = append(chunk, buf...)
+
+		if !trimmed {
+			i := bytes.LastIndex(buf, newline)
+			if i < 0 {
+				continue
+			}
+
+			end = off + int64(i) + 1
+			buf = buf[:i+1]
+			trimmed = true
+		}
+
+		for len(buf) > 0 {
+			// A line is complete once the newline before it was read, or
+			// it's the first one.
+			i := bytes.LastIndex(buf[:len(buf)-1], newline)
+			if i < 0 && off > 0 {
+				break
+			}
+
+			if !fn(string(buf[i+1 : len(buf)-1])) {
+				return end, nil
+			}
+
+			buf = buf[:i+1]
+		}
+	}
+
+	return end, nil
+}
+
+// rotatedBefore reports whether file was rotated before since, i.e. all of
+// its lines are older.
+func (q logQuery) rotatedBefore(file string) bool {
+	if q.since.IsZero() || filepath.Base(file) == "current" {
+		return false
+	}
+
+	rotatedAt, ok := tai64nTime(strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)))
+	return ok && rotatedAt.Before(q.since)
+}
+
+// logFiles returns the files of the log history in logdir, oldest first.
+func logFiles(logdir string) ([]string, error) {
+	rotated, err := filepath.Glob(filepath.Join(logdir, "@*"))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(logdir); err != nil {
+		return nil, err
+	}
+
+	// TAI64N labels are fixed-width hex, and sort chronologically.
+	sort.Strings(rotated)
+
+	files := make([]string, 0, len(rotated)+1)
+
+	for _, file := range rotated {
+		if ext := filepath.Ext(file); ext != ".s" && ext != ".u" {
+			continue
+		}
+
+		files = append(files, file)
+	}
+
+	return append(files, filepath.Join(logdir, "current")), nil
+}
+
+// logLineTime returns the timestamp svlogd prefixed the line with.
+func logLineTime(line string) (time.Time, bool) {
+	i := strings.IndexByte(line, ' ')
+	if i < 0 {
+		return time.Time{}, false
+	}
+
+	t, err := time.Parse(logTimestampLayout, line[:i])
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return t, true
+}
+
+// tai64nTime returns the time of a TAI64N label, as svlogd names rotated
+// files with, e.g. @400000005446a3d1271b5e84. The difference between TAI and
+// UTC is ignored, and a minute is added to the result, to err on the side of
+// reading a file.
+func tai64nTime(label string) (time.Time, bool) {
+	if len(label) != 25 || label[0] != '@' {
+		return time.Time{}, false
+	}
+
+	b, err := hex.DecodeString(label[1:17])
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	var secs uint64
+	for _, c := range b {
+		secs = secs<<8 | uint64(c)
+	}
+
+	if secs < 1<<62 {
+		return time.Time{}, false
+	}
+
+	return time.Unix(int64(secs-1<<62), 0).Add(time.Minute), true
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestReadLogHistory(t *testing.T) {
+	logdir, err := ioutil.TempDir("", "harpoon-agent-log-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(logdir)
+
+	var (
+		rotated = "2014-10-22_09:00:00.00001 m1\n2014-10-22_09:00:01.00001 m2\n"
+		current = "2014-10-22_09:00:02.00001 m3\n2014-10-22_09:00:03.00001 m4\n2014-10-22_09:00:04"
+	)
+
+	for file, content := range map[string]string{
+		"@400000005447725c00000000.s": rotated,
+		"current":                     current,
+		"config":                      "s5242880\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(logdir, file), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	at := func(s string) time.Time {
+		t, _ := time.Parse(time.RFC3339, s)
+		return t
+	}
+
+	for i, input := range []struct {
+		q     logQuery
+		lines []string
+		next  int64
+	}{
+		{
+			q:     logQuery{offset: -1, count: 10},
+			lines: []string{"2014-10-22_09:00:00.00001 m1", "2014-10-22_09:00:01.00001 m2", "2014-10-22_09:00:02.00001 m3", "2014-10-22_09:00:03.00001 m4"},
+			next:  116,
+		},
+		{
+			q:     logQuery{offset: -1, count: 1},
+			lines: []string{"2014-10-22_09:00:03.00001 m4"},
+			next:  116,
+		},
+		{
+			q:     logQuery{offset: 1, count: 2},
+			lines: []string{"2014-10-22_09:00:01.00001 m2", "2014-10-22_09:00:02.00001 m3"},
+			next:  87,
+		},
+		{
+			q:     logQuery{offset: -1, count: 10, byteOffset: 31},
+			lines: []string{"2014-10-22_09:00:02.00001 m3", "2014-10-22_09:00:03.00001 m4"},
+			next:  116,
+		},
+		{
+			q:     logQuery{offset: -1, count: 10, byteOffset: 29},
+			lines: []string{"2014-10-22_09:00:01.00001 m2", "2014-10-22_09:00:02.00001 m3", "2014-10-22_09:00:03.00001 m4"},
+			next:  116,
+		},
+		{
+			q:     logQuery{offset: -1, count: 10, since: at("2014-10-22T09:00:01Z"), until: at("2014-10-22T09:00:02Z")},
+			lines: []string{"2014-10-22_09:00:01.00001 m2"},
+			next:  116,
+		},
+		{
+			q:     logQuery{offset: -1, count: 10, grep: regexp.MustCompile(`m[13]$`)},
+			lines: []string{"2014-10-22_09:00:00.00001 m1", "2014-10-22_09:00:02.00001 m3"},
+			next:  116,
+		},
+	} {
+		h, err := readLogHistory(logdir, input.q)
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+
+		if !reflect.DeepEqual(h.lines, input.lines) {
+			t.Errorf("%d: expected lines %q, got %q", i, input.lines, h.lines)
+		}
+
+		if h.next != input.next {
+			t.Errorf("%d: expected next offset %d, got %d", i, input.next, h.next)
+		}
+	}
+
+	if _, err := readLogHistory(filepath.Join(logdir, "missing"), logQuery{count: 10}); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error for missing log dir, got %v", err)
+	}
+}
+
+func TestReadLogTail(t *testing.T) {
+	logdir, err := ioutil.TempDir("", "harpoon-agent-log-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(logdir)
+
+	defer func(n int64) { logTailChunkSize = n }(logTailChunkSize)
+	logTailChunkSize = 4 // lines span chunks
+
+	for file, content := range map[string]string{
+		"@400000005447725c00000000.s": "a1\na2\n",
+		"@400000005447726000000000.s": "b1\nb2\nb3\n",
+		"current":                     "c1\nc2\nc",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(logdir, file), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The oldest rotated file can't be read, so queries only succeed if they
+	// don't get that far.
+	if err := os.Mkdir(filepath.Join(logdir, "@400000005447725000000000.s"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(filepath.Join(logdir, "@400000005447725000000000.s"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := fi.Size() + 6 + 9 + 6
+
+	for i, input := range []struct {
+		q     logQuery
+		lines []string
+	}{
+		{logQuery{offset: -1, count: 0}, []string{}},
+		{logQuery{offset: -1, count: 1}, []string{"c2"}},
+		{logQuery{offset: -1, count: 3}, []string{"b3", "c1", "c2"}},
+		{logQuery{offset: -1, count: 5}, []string{"b1", "b2", "b3", "c1", "c2"}},
+		{logQuery{offset: -1, count: 2, grep: regexp.MustCompile(`[ac]1`)}, []string{"a1", "c1"}},
+	} {
+		h, err := readLogHistory(logdir, input.q)
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+
+		if !reflect.DeepEqual(h.lines, input.lines) {
+			t.Errorf("%d: expected lines %q, got %q", i, input.lines, h.lines)
+		}
+
+		if h.next != next {
+			t.Errorf("%d: expected next offset %d, got %d", i, next, h.next)
+		}
+	}
+}
+
+func TestTAI64NTime(t *testing.T) {
+	got, ok := tai64nTime("@400000005447725c00000000")
+	if !ok {
+		t.Fatal("expected valid label")
+	}
+
+	if want := time.Unix(0x5447725c, 0).Add(time.Minute); !got.Equal(want) {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+
+	if _, ok := tai64nTime("current"); ok {
+		t.Error("expected invalid label")
+	}
+}
@@ -6,7 +6,9 @@ package main
 // single container.
 //
 // ringBuffer implements rolling log storage and retrieval of the last N log
-// lines.
+// lines. Each line is numbered in sequence, starting at 1, so that listeners
+// which fell behind can retrieve the lines they missed, or learn that they
+// are gone.
 
 import (
 	"container/ring"
@@ -26,7 +28,8 @@ type containerLog struct {
 	notifications map[chan string]struct{}
 	addc          chan string
 	lastc         chan logLast
-	notifyc       chan chan string
+	afterc        chan logAfter
+	notifyc       chan logNotify
 	stopc         chan chan string
 	quitc         chan chan struct{}
 }
@@ -39,7 +42,8 @@ func newContainerLog(bufferSize int) *containerLog {
 		notifications: make(map[chan string]struct{}),
 		addc:          make(chan string),
 		lastc:         make(chan logLast),
-		notifyc:       make(chan chan string),
+		afterc:        make(chan logAfter),
+		notifyc:       make(chan logNotify),
 		stopc:         make(chan chan string),
 		quitc:         make(chan chan struct{}),
 	}
@@ -52,6 +56,22 @@ type logLast struct {
 	last  chan []string // passes result to caller
 }
 
+type logAfter struct {
+	seq     uint64          // supplied by caller
+	entries chan []logEntry // passes result to caller
+}
+
+type logNotify struct {
+	linec chan string // supplied by caller
+	seq   chan uint64 // passes result to caller
+}
+
+// logEntry is a log line, and its sequence number.
+type logEntry struct {
+	seq  uint64
+	line string
+}
+
 // addLogLine feeds a log entry into a log buffer and notifies all listeners.
 //
 // METRICS:
@@ -75,11 +95,24 @@ func (cl *containerLog) last(n int) []string {
 	return <-msg.last
 }
 
-// notify subscribes a listener to a container. New log lines are sent to all
-// linecs in the notifications set. A linec does not receive messages
-// while it is blocked. All of those messages are lost like tears in the rain.
-func (cl *containerLog) notify(linec chan string) {
-	cl.notifyc <- linec
+// after retrieves the log lines following the one numbered seq, which are
+// still in the buffer, from oldest to newest. If the first one isn't numbered
+// seq+1, the lines in between are lost.
+func (cl *containerLog) after(seq uint64) []logEntry {
+	msg := logAfter{seq: seq, entries: make(chan []logEntry)}
+	cl.afterc <- msg
+	return <-msg.entries
+}
+
+// notify subscribes a listener to a container, and returns the sequence
+// number of the newest line, which it won't be notified of. New log lines
+// are sent to all linecs in the notifications set. A linec does not receive
+// messages while it is blocked. Listeners which can't afford to lose lines
+// retrieve them with after, once they're notified again.
+func (cl *containerLog) notify(linec chan string) uint64 {
+	msg := logNotify{linec: linec, seq: make(chan uint64)}
+	cl.notifyc <- msg
+	return <-msg.seq
 }
 
 // stop removes the linec from the notifications set.
@@ -102,8 +135,11 @@ func (cl *containerLog) loop() {
 			cl.insert(line)
 		case msg := <-cl.lastc:
 			msg.last <- cl.entries.last(msg.count)
-		case linec := <-cl.notifyc:
-			cl.addNotifier(linec)
+		case msg := <-cl.afterc:
+			msg.entries <- cl.entries.after(msg.seq)
+		case msg := <-cl.notifyc:
+			cl.addNotifier(msg.linec)
+			msg.seq <- cl.entries.newest()
 		case linec := <-cl.stopc:
 			cl.removeNotifier(linec)
 		case q := <-cl.quitc:
@@ -200,6 +236,7 @@ type ringBuffer struct {
 	sync.Mutex
 	elements *ring.Ring
 	length   int
+	seq      uint64 // of the newest entry
 }
 
 // newRingBuffer creates a new ring buffer of the specified size.
@@ -207,12 +244,50 @@ func newRingBuffer(size int) *ringBuffer {
 	return &ringBuffer{elements: ring.New(size), length: size}
 }
 
-// insert a message into the ring buffer.
-func (b *ringBuffer) insert(x string) {
+// insert a message into the ring buffer, and return its sequence number.
+func (b *ringBuffer) insert(x string) uint64 {
 	b.Lock()
 	defer b.Unlock()
-	b.elements.Value = x
+	b.seq++
+	b.elements.Value = logEntry{seq: b.seq, line: x}
 	b.elements = b.elements.Next()
+	return b.seq
+}
+
+// newest returns the sequence number of the newest entry, or 0 if the ring
+// buffer is empty.
+func (b *ringBuffer) newest() uint64 {
+	b.Lock()
+	defer b.Unlock()
+	return b.seq
+}
+
+// after returns the entries numbered after seq, from oldest to newest. It
+// returns all entries if seq is beyond the newest one.
+func (b *ringBuffer) after(seq uint64) []logEntry {
+	b.Lock()
+	defer b.Unlock()
+
+	if seq > b.seq {
+		seq = 0
+	}
+
+	results := []logEntry{}
+
+	prev := b.elements
+	for i := 0; i < b.length; i++ {
+		prev = prev.Prev()
+		if prev.Value == nil || prev.Value.(logEntry).seq <= seq {
+			break
+		}
+		results = append(results, prev.Value.(logEntry))
+	}
+
+	for i := 0; i < len(results)/2; i++ {
+		results[i], results[len(results)-i-1] = results[len(results)-i-1], results[i]
+	}
+
+	return results
 }
 
 // Last returns the last count entries from the ring buffer. These
@@ -233,7 +308,7 @@ func (b *ringBuffer) last(count int) []string {
 		if prev.Value == nil {
 			break
 		}
-		results = append(results, prev.Value.(string))
+		results = append(results, prev.Value.(logEntry).line)
 	}
 
 	return reverse(results)
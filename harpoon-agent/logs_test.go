@@ -201,6 +201,25 @@ func TestLastLimitsRetrievalToTheRingBufferSize(t *testing.T) {
 	ExpectArraysEqual(t, rb.last(4), []string{"m2", "m3", "m4"})
 }
 
+func TestAfterReturnsEntriesFollowingSeq(t *testing.T) {
+	rb := newRingBuffer(3)
+	rb.insert("m1")
+	rb.insert("m2")
+	rb.insert("m3")
+	rb.insert("m4")
+
+	for seq, expected := range map[uint64][]logEntry{
+		4: {},
+		2: {{3, "m3"}, {4, "m4"}},
+		0: {{2, "m2"}, {3, "m3"}, {4, "m4"}}, // m1 is lost
+		9: {{2, "m2"}, {3, "m3"}, {4, "m4"}}, // numbered before a restart
+	} {
+		if have := rb.after(seq); !reflect.DeepEqual(have, expected) {
+			t.Errorf("after %d: expected %v, got %v", seq, expected, have)
+		}
+	}
+}
+
 func TestReverse(t *testing.T) {
 	ExpectArraysEqual(t, reverse([]string{}), []string{})
 	ExpectArraysEqual(t, reverse([]string{"1"}), []string{"1"})
@@ -7,7 +7,10 @@ import (
 	"math"
 	"net/http"
 	"os"
+	"strings"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 var (
@@ -25,8 +28,13 @@ var (
 var (
 	heartbeatInterval = 3 * time.Second
 	configuredVolumes = volumes{} // TODO: de-globalize
+	configuredLabels  = labels{}  // TODO: de-globalize
 	agentCPU          float64     // TODO: de-globalize
 	agentMem          int64       // TODO: de-globalize
+	agentStorage      int64       // TODO: de-globalize
+	overcommitCPU     = 1.0       // TODO: de-globalize
+	overcommitMem     = 1.0       // TODO: de-globalize
+	overcommitStorage = 1.0       // TODO: de-globalize
 	debug             bool        // TODO: de-globalize
 	logAddr           string      // TODO: de-globalize
 )
@@ -38,10 +46,20 @@ func main() {
 		addr          = flag.String("addr", ":3333", "address to listen on")
 		portsStart    = flag.Uint64("ports.start", 30000, "starting of port allocation range")
 		portsEnd      = flag.Uint64("ports.end", 32767, "ending of port allocation range")
+		artifactsMax  = flag.Int64("artifacts.max", 10240, "size (MB) of the artifact cache, beyond which unused artifacts are removed")
+		netBridge     = flag.String("net.bridge", "harpoon0", "bridge connecting containers with private networks to the host")
+		netSubnet     = flag.String("net.subnet", "", "subnet (CIDR) for containers with private networks; if empty, private networks are disabled")
+		gcMaxAge      = flag.Duration("gc.max-age", 0, "time after which finished and failed containers, other than scheduled tasks, are destroyed; 0 keeps them")
+		gcMaxCount    = flag.Int("gc.max-count", 0, "number of finished and failed containers, other than scheduled tasks, to keep, beyond which the oldest are destroyed; 0 keeps all")
 	)
 	flag.Var(&configuredVolumes, "vol", "repeatable list of available volumes")
+	flag.Var(&configuredLabels, "label", "repeatable list of labels (key=value) describing the agent, for placement constraints")
 	flag.Float64Var(&agentCPU, "cpu", systemCPU(), "CPU resources to make available")
 	flag.Int64Var(&agentMem, "mem", systemMem(), "memory (MB) resources to make available")
+	flag.Int64Var(&agentStorage, "storage", systemMem()/2, "storage (MB) for sized tmpfs mounts to make available")
+	flag.Float64Var(&overcommitCPU, "overcommit.cpu", overcommitCPU, "ratio of CPU resources containers may reserve to those made available")
+	flag.Float64Var(&overcommitMem, "overcommit.mem", overcommitMem, "ratio of memory containers may reserve to that made available")
+	flag.Float64Var(&overcommitStorage, "overcommit.storage", overcommitStorage, "ratio of storage containers may reserve to that made available")
 	flag.BoolVar(&debug, "debug", false, "debug logging")
 	flag.StringVar(&logAddr, "log.addr", ":3334", "address for log communications")
 
@@ -52,6 +70,20 @@ func main() {
 		os.Exit(0)
 	}
 
+	for name, ratio := range map[string]float64{
+		"cpu":     overcommitCPU,
+		"mem":     overcommitMem,
+		"storage": overcommitStorage,
+	} {
+		if ratio <= 0 {
+			log.Fatalf("overcommit.%s must be positive", name)
+		}
+	}
+
+	if *gcMaxAge < 0 || *gcMaxCount < 0 {
+		log.Fatal("gc.max-age and gc.max-count must not be negative")
+	}
+
 	if *portsStart > math.MaxUint16 {
 		log.Fatalf("port range start must be between 0 and %d", math.MaxUint16)
 	}
@@ -69,17 +101,47 @@ func main() {
 	r := newRegistry()
 	pdb := newPortDB(portsStart16, portsEnd16)
 	defer pdb.exit()
-	api := newAPI(*containerRoot, r, pdb)
+
+	var adb *addressDB
+	if *netSubnet != "" {
+		var err error
+		if adb, err = newAddressDB(*netBridge, *netSubnet); err != nil {
+			log.Fatalf("private networks: %s", err)
+		}
+		defer adb.exit()
+
+		if err := setupBridge(adb); err != nil {
+			log.Fatalf("private networks: %s", err)
+		}
+	}
+
+	am, err := newArtifactManager("/srv/harpoon/artifacts", *artifactsMax*1024*1024)
+	if err != nil {
+		log.Fatalf("artifact manager: %s", err)
+	}
+	defer am.exit()
+
+	api := newAPI(*containerRoot, r, pdb, adb, am)
 
 	go receiveLogs(r)
 
+	prometheus.MustRegister(containerCollector{r})
+
+	http.Handle("/metrics", prometheus.Handler())
 	http.Handle("/", api)
 
 	go func() {
-		recoverContainers(*containerRoot, r, pdb)
+		recoverContainers(*containerRoot, r, pdb, adb, am)
+
+		// All recovered containers have claimed their artifacts.
+		am.enableGC()
 
 		r.acceptStateUpdates()
 
+		if *gcMaxAge > 0 || *gcMaxCount > 0 {
+			newReaper(r, *gcMaxAge, *gcMaxCount)
+		}
+
 		if r.len() > 0 {
 			time.Sleep(3 * heartbeatInterval) // wait for runners to check in
 		}
@@ -95,3 +157,17 @@ type volumes map[string]struct{}
 
 func (*volumes) String() string           { return "" }
 func (v *volumes) Set(value string) error { (*v)[value] = struct{}{}; return nil }
+
+type labels map[string]string
+
+func (*labels) String() string { return "" }
+
+func (l *labels) Set(value string) error {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return fmt.Errorf("label %q should be key=value", value)
+	}
+
+	(*l)[kv[0]] = kv[1]
+	return nil
+}
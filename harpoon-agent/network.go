@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// natChain is the chain of the nat table holding the DNAT rules which map
+// host ports to the ports of containers with private networks. It's jumped
+// to for traffic to any local address, except loopback addresses, which
+// can't be routed to the bridge.
+const natChain = "HARPOON"
+
+// addressDB allocates addresses to containers with private networks, from the
+// subnet of the bridge which connects them to the host. The first address of
+// the subnet is the gateway, and is assigned to the bridge.
+//
+// It provides threadsafe operations.
+type addressDB struct {
+	bridge  string
+	gateway net.IP
+	subnet  *net.IPNet
+
+	addrs map[string]struct{} // set of claimed IPs
+	first uint32              // inclusive
+	last  uint32              // inclusive
+	next  uint32              // this will be the next address tried by acquire
+
+	acquirec chan acquireAddressCmd
+	claimc   chan addressCmd
+	releasec chan addressCmd
+	exitc    chan chan struct{}
+}
+
+type acquireAddressCmd struct {
+	addrc chan string
+	errc  chan error
+}
+
+type addressCmd struct {
+	addr string
+	errc chan error
+}
+
+func newAddressDB(bridge, subnet string) (*addressDB, error) {
+	_, ipnet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil, err
+	}
+
+	if ipnet.IP.To4() == nil {
+		return nil, fmt.Errorf("subnet %s is not IPv4", subnet)
+	}
+
+	if ones, _ := ipnet.Mask.Size(); ones > 30 {
+		return nil, fmt.Errorf("subnet %s too small", subnet)
+	}
+
+	var (
+		network   = binary.BigEndian.Uint32(ipnet.IP.To4())
+		broadcast = network | ^binary.BigEndian.Uint32(net.IP(ipnet.Mask).To4())
+	)
+
+	adb := &addressDB{
+		bridge:  bridge,
+		gateway: ipFromUint32(network + 1),
+		subnet:  ipnet,
+
+		addrs: map[string]struct{}{},
+		first: network + 2,
+		last:  broadcast - 1,
+		next:  network + 2,
+
+		acquirec: make(chan acquireAddressCmd),
+		claimc:   make(chan addressCmd),
+		releasec: make(chan addressCmd),
+		exitc:    make(chan chan struct{}),
+	}
+
+	go adb.loop()
+
+	return adb, nil
+}
+
+// gatewayAddress returns the address of the bridge, in CIDR notation.
+func (adb *addressDB) gatewayAddress() string {
+	return adb.cidr(adb.gateway)
+}
+
+// acquire chooses a free address, and returns it in CIDR notation. The address
+// will not be available to other callers until it's released.
+func (adb *addressDB) acquire() (string, error) {
+	cmd := acquireAddressCmd{addrc: make(chan string, 1), errc: make(chan error, 1)}
+	adb.acquirec <- cmd
+
+	select {
+	case addr := <-cmd.addrc:
+		return addr, nil
+	case err := <-cmd.errc:
+		return "", err
+	}
+}
+
+// claim claims a specific address, e.g. when recovering a container.
+func (adb *addressDB) claim(addr string) error {
+	errc := make(chan error)
+	adb.claimc <- addressCmd{addr: addr, errc: errc}
+	return <-errc
+}
+
+// release returns an address to the pool.
+func (adb *addressDB) release(addr string) {
+	errc := make(chan error)
+	adb.releasec <- addressCmd{addr: addr, errc: errc}
+	<-errc
+}
+
+func (adb *addressDB) exit() {
+	exitc := make(chan struct{})
+	adb.exitc <- exitc
+	<-exitc
+}
+
+func (adb *addressDB) loop() {
+	for {
+		select {
+		case cmd := <-adb.acquirec:
+			addr, err := adb.acquireUnsafe()
+			if err != nil {
+				cmd.errc <- err
+				continue
+			}
+			cmd.addrc <- addr
+
+		case cmd := <-adb.claimc:
+			cmd.errc <- adb.claimUnsafe(cmd.addr)
+
+		case cmd := <-adb.releasec:
+			if ip, _, err := net.ParseCIDR(cmd.addr); err == nil {
+				delete(adb.addrs, ip.String())
+			}
+			close(cmd.errc)
+
+		case exitc := <-adb.exitc:
+			close(exitc)
+			return
+		}
+	}
+}
+
+// acquireUnsafe picks addresses round-robin, so recently released addresses
+// aren't reused right away, while they may still be cached by peers.
+func (adb *addressDB) acquireUnsafe() (string, error) {
+	n := adb.last - adb.first + 1
+
+	for i := uint32(0); i < n; i++ {
+		ip := ipFromUint32(adb.next)
+
+		adb.next++
+		if adb.next > adb.last {
+			adb.next = adb.first
+		}
+
+		if _, ok := adb.addrs[ip.String()]; ok {
+			continue
+		}
+
+		adb.addrs[ip.String()] = struct{}{}
+
+		return adb.cidr(ip), nil
+	}
+
+	return "", errors.New("no free addresses")
+}
+
+func (adb *addressDB) claimUnsafe(addr string) error {
+	ip, _, err := net.ParseCIDR(addr)
+	if err != nil {
+		return err
+	}
+
+	if !adb.subnet.Contains(ip) || ip.Equal(adb.gateway) {
+		return fmt.Errorf("address %s not in %s", addr, adb.subnet)
+	}
+
+	if _, ok := adb.addrs[ip.String()]; ok {
+		return fmt.Errorf("address %s already claimed", addr)
+	}
+
+	adb.addrs[ip.String()] = struct{}{}
+
+	return nil
+}
+
+func (adb *addressDB) cidr(ip net.IP) string {
+	ones, _ := adb.subnet.Mask.Size()
+	return fmt.Sprintf("%s/%d", ip, ones)
+}
+
+func ipFromUint32(n uint32) net.IP {
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, n)
+	return ip
+}
+
+// setupBridge creates the bridge if it doesn't exist yet, and configures the
+// host to forward and masquerade the traffic of the containers behind it, and
+// to map host ports to them.
+func setupBridge(adb *addressDB) error {
+	if _, err := net.InterfaceByName(adb.bridge); err != nil {
+		if err := runCommand("ip", "link", "add", "name", adb.bridge, "type", "bridge"); err != nil {
+			return err
+		}
+
+		if err := runCommand("ip", "addr", "add", adb.gatewayAddress(), "dev", adb.bridge); err != nil {
+			return err
+		}
+	}
+
+	if err := runCommand("ip", "link", "set", adb.bridge, "up"); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile("/proc/sys/net/ipv4/ip_forward", []byte("1\n"), 0644); err != nil {
+		return fmt.Errorf("enable IP forwarding: %s", err)
+	}
+
+	if err := appendRule("POSTROUTING", "-s", adb.subnet.String(), "!", "-o", adb.bridge, "-j", "MASQUERADE"); err != nil {
+		return err
+	}
+
+	if err := runCommand("iptables", "-t", "nat", "-n", "-L", natChain); err != nil {
+		if err := runCommand("iptables", "-t", "nat", "-N", natChain); err != nil {
+			return err
+		}
+	}
+
+	if err := appendRule("PREROUTING", "-m", "addrtype", "--dst-type", "LOCAL", "-j", natChain); err != nil {
+		return err
+	}
+
+	return appendRule("OUTPUT", "!", "-d", "127.0.0.0/8", "-m", "addrtype", "--dst-type", "LOCAL", "-j", natChain)
+}
+
+// mapPorts maps the host ports of a container with a private network to its
+// ports on the passed address, for both TCP and UDP. Rules which are already
+// in place are left alone, so recovered containers can map their ports again.
+func mapPorts(address string, hostPorts, ports map[string]uint16) error {
+	rules, err := portMappingRules(address, hostPorts, ports)
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		if err := appendRule(rule...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unmapPorts removes the rules added by mapPorts. All rules are tried, and
+// the first error is returned.
+func unmapPorts(address string, hostPorts, ports map[string]uint16) error {
+	rules, err := portMappingRules(address, hostPorts, ports)
+	if err != nil {
+		return err
+	}
+
+	var first error
+
+	for _, rule := range rules {
+		if err := runCommand("iptables", append([]string{"-t", "nat", "-D"}, rule...)...); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
+
+// portMappingRules returns the DNAT rules of the nat table which map each
+// host port to the port of the same name on the address, given in CIDR
+// notation. Rules are sorted by host port.
+func portMappingRules(address string, hostPorts, ports map[string]uint16) ([][]string, error) {
+	ip, _, err := net.ParseCIDR(address)
+	if err != nil {
+		return nil, fmt.Errorf("container address %q invalid: %s", address, err)
+	}
+
+	names := make([]string, 0, len(hostPorts))
+	for name := range hostPorts {
+		names = append(names, name)
+	}
+	sort.Sort(byHostPort{names: names, ports: hostPorts})
+
+	rules := [][]string{}
+
+	for _, name := range names {
+		port, ok := ports[name]
+		if !ok {
+			return nil, fmt.Errorf("host port %q not in ports", name)
+		}
+
+		var (
+			hostPort    = strconv.Itoa(int(hostPorts[name]))
+			destination = net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+		)
+
+		for _, protocol := range []string{"tcp", "udp"} {
+			rules = append(rules, []string{natChain, "-p", protocol, "--dport", hostPort, "-j", "DNAT", "--to-destination", destination})
+		}
+	}
+
+	return rules, nil
+}
+
+// appendRule appends the rule to its chain of the nat table, unless it's
+// already there.
+func appendRule(rule ...string) error {
+	if err := runCommand("iptables", append([]string{"-t", "nat", "-C"}, rule...)...); err == nil {
+		return nil // already set up
+	}
+
+	return runCommand("iptables", append([]string{"-t", "nat", "-A"}, rule...)...)
+}
+
+// runCommand executes a command, and returns its output as part of the error
+// if it fails.
+func runCommand(name string, args ...string) error {
+	if buf, err := exec.Command(name, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s %s: %s (%s)", name, strings.Join(args, " "), err, strings.TrimSpace(string(buf)))
+	}
+
+	return nil
+}
+
+// byHostPort orders port names by their host ports.
+type byHostPort struct {
+	names []string
+	ports map[string]uint16
+}
+
+func (s byHostPort) Len() int           { return len(s.names) }
+func (s byHostPort) Less(i, j int) bool { return s.ports[s.names[i]] < s.ports[s.names[j]] }
+func (s byHostPort) Swap(i, j int)      { s.names[i], s.names[j] = s.names[j], s.names[i] }
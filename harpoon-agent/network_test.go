@@ -0,0 +1,109 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAddressDB(t *testing.T) {
+	adb, err := newAddressDB("harpoon0", "10.0.0.0/29")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adb.exit()
+
+	if want, have := "10.0.0.1/29", adb.gatewayAddress(); want != have {
+		t.Errorf("want gateway %s, have %s", want, have)
+	}
+
+	// The network, gateway, and broadcast addresses are never handed out.
+	var addrs []string
+	for i := 0; i < 5; i++ {
+		addr, err := adb.acquire()
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		addrs = append(addrs, addr)
+	}
+
+	if want, have := "10.0.0.2/29", addrs[0]; want != have {
+		t.Errorf("want first address %s, have %s", want, have)
+	}
+
+	if want, have := "10.0.0.6/29", addrs[4]; want != have {
+		t.Errorf("want last address %s, have %s", want, have)
+	}
+
+	if _, err := adb.acquire(); err == nil {
+		t.Fatal("expected exhausted subnet, got an address")
+	}
+
+	if err := adb.claim(addrs[2]); err == nil {
+		t.Errorf("expected claim of acquired address %s to fail", addrs[2])
+	}
+
+	adb.release(addrs[2])
+
+	addr, err := adb.acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := addrs[2], addr; want != have {
+		t.Errorf("want released address %s, have %s", want, have)
+	}
+
+	adb.release(addr)
+
+	if err := adb.claim(addr); err != nil {
+		t.Errorf("expected claim of released address %s to pass, got %s", addr, err)
+	}
+
+	for _, addr := range []string{"10.0.0.1/29", "10.0.1.2/29", "garbage"} {
+		if err := adb.claim(addr); err == nil {
+			t.Errorf("expected claim of %s to fail", addr)
+		}
+	}
+}
+
+func TestNewAddressDBInvalidSubnet(t *testing.T) {
+	for _, subnet := range []string{"10.0.0.0/31", "fd00::/64", "10.0.0.0"} {
+		if _, err := newAddressDB("harpoon0", subnet); err == nil {
+			t.Errorf("%s: expected error, got none", subnet)
+		}
+	}
+}
+
+func TestPortMappingRules(t *testing.T) {
+	rules, err := portMappingRules(
+		"10.0.0.2/24",
+		map[string]uint16{"http": 31001, "dns": 31000},
+		map[string]uint16{"http": 8080, "dns": 53},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{
+		{natChain, "-p", "tcp", "--dport", "31000", "-j", "DNAT", "--to-destination", "10.0.0.2:53"},
+		{natChain, "-p", "udp", "--dport", "31000", "-j", "DNAT", "--to-destination", "10.0.0.2:53"},
+		{natChain, "-p", "tcp", "--dport", "31001", "-j", "DNAT", "--to-destination", "10.0.0.2:8080"},
+		{natChain, "-p", "udp", "--dport", "31001", "-j", "DNAT", "--to-destination", "10.0.0.2:8080"},
+	}
+
+	if !reflect.DeepEqual(want, rules) {
+		t.Errorf("want %v, have %v", want, rules)
+	}
+
+	for i, input := range []struct {
+		address          string
+		hostPorts, ports map[string]uint16
+	}{
+		{"10.0.0.2", map[string]uint16{"http": 31001}, map[string]uint16{"http": 8080}},
+		{"10.0.0.2/24", map[string]uint16{"http": 31001}, map[string]uint16{}},
+	} {
+		if _, err := portMappingRules(input.address, input.hostPorts, input.ports); err == nil {
+			t.Errorf("%d: expected error, got none", i)
+		}
+	}
+}
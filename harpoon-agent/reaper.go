@@ -0,0 +1,190 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/soundcloud/harpoon/harpoon-agent/lib"
+)
+
+// reapInterval is how often the reaper looks for containers to
+// garbage-collect.
+var reapInterval = 30 * time.Second
+
+// reaper garbage-collects finished and failed containers, according to the
+// agent's retention policy: terminal containers are destroyed once they've
+// been terminal for longer than maxAge, and the oldest ones are destroyed
+// while there are more than maxCount. Zero means no limit. Destroying a
+// container releases its ports, address and artifact, removes its rundir, and
+// emits a ContainerStatusDeleted event. The registry keeps a tombstone.
+//
+// Tasks of harpoon-scheduler are left alone (see scheduled).
+type reaper struct {
+	registry *registry
+	maxAge   time.Duration
+	maxCount int
+
+	// terminal records when containers were first seen finished or failed,
+	// by ID. Containers recovered on startup count as terminal from then on.
+	terminal map[string]time.Time
+
+	quitc chan chan struct{}
+}
+
+func newReaper(r *registry, maxAge time.Duration, maxCount int) *reaper {
+	rp := &reaper{
+		registry: r,
+		maxAge:   maxAge,
+		maxCount: maxCount,
+		terminal: map[string]time.Time{},
+		quitc:    make(chan chan struct{}),
+	}
+
+	go rp.loop()
+
+	return rp
+}
+
+func (rp *reaper) exit() {
+	q := make(chan struct{})
+	rp.quitc <- q
+	<-q
+}
+
+func (rp *reaper) loop() {
+	ticker := time.NewTicker(reapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			rp.reap(now)
+
+		case q := <-rp.quitc:
+			close(q)
+			return
+		}
+	}
+}
+
+// reap destroys the terminal containers the retention policy doesn't keep,
+// oldest first.
+func (rp *reaper) reap(now time.Time) {
+	seen := map[string]struct{}{}
+
+	for id, instance := range rp.registry.instances() {
+		if !terminal(instance) || scheduled(instance) {
+			continue
+		}
+
+		seen[id] = struct{}{}
+
+		if _, ok := rp.terminal[id]; !ok {
+			rp.terminal[id] = now
+		}
+	}
+
+	// Forget containers which were destroyed, or restarted.
+	for id := range rp.terminal {
+		if _, ok := seen[id]; !ok {
+			delete(rp.terminal, id)
+		}
+	}
+
+	ids := make([]string, 0, len(rp.terminal))
+	for id := range rp.terminal {
+		ids = append(ids, id)
+	}
+	sort.Sort(byTerminalSince{ids: ids, since: rp.terminal})
+
+	remaining := len(ids)
+
+	for _, id := range ids {
+		var (
+			expired = rp.maxAge > 0 && now.Sub(rp.terminal[id]) >= rp.maxAge
+			excess  = rp.maxCount > 0 && remaining > rp.maxCount
+		)
+
+		if !expired && !excess {
+			break // the remaining containers are younger
+		}
+
+		if err := rp.destroy(id, now); err != nil {
+			log.Printf("[%s] garbage collection: %s", id, err)
+			continue
+		}
+
+		delete(rp.terminal, id)
+		remaining--
+	}
+}
+
+func (rp *reaper) destroy(id string, now time.Time) error {
+	c, ok := rp.registry.get(id)
+	if !ok {
+		return nil // destroyed in the meantime
+	}
+
+	instance := c.Instance()
+	if !terminal(instance) {
+		return nil // restarted in the meantime
+	}
+
+	if err := c.Destroy(); err != nil {
+		return err
+	}
+
+	rp.registry.bury(instance, now)
+	incContainersReaped(1)
+
+	log.Printf("[%s] garbage-collected %s container", id, instance.ContainerStatus)
+
+	return nil
+}
+
+// terminal reports whether a container is finished or failed, and therefore
+// subject to garbage collection.
+func terminal(instance agent.ContainerInstance) bool {
+	switch instance.ContainerStatus {
+	case agent.ContainerStatusFinished, agent.ContainerStatusFailed:
+		return true
+	}
+
+	return false
+}
+
+// scheduled reports whether the container is a task of harpoon-scheduler,
+// which labels them with their job. The scheduler considers finished and
+// failed tasks done, and destroys those it no longer wants. If they were
+// reaped, it would schedule them again, and crash-looping tasks would loop
+// across agents.
+func scheduled(instance agent.ContainerInstance) bool {
+	_, ok := instance.Labels["job"]
+	return ok
+}
+
+// byTerminalSince orders container IDs by when their containers became
+// terminal, oldest first, and then by ID.
+type byTerminalSince struct {
+	ids   []string
+	since map[string]time.Time
+}
+
+func (s byTerminalSince) Less(i, j int) bool {
+	a, b := s.since[s.ids[i]], s.since[s.ids[j]]
+
+	if !a.Equal(b) {
+		return a.Before(b)
+	}
+
+	return s.ids[i] < s.ids[j]
+}
+
+func (s byTerminalSince) Len() int {
+	return len(s.ids)
+}
+
+func (s byTerminalSince) Swap(i, j int) {
+	s.ids[i], s.ids[j] = s.ids[j], s.ids[i]
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/harpoon/harpoon-agent/lib"
+)
+
+func TestReaper(t *testing.T) {
+	var (
+		registry = newRegistry()
+		reaper   = newReaper(registry, time.Minute, 1)
+		t0       = time.Now()
+	)
+	defer reaper.exit()
+
+	for id, status := range map[string]agent.ContainerStatus{
+		"a": agent.ContainerStatusFinished,
+		"b": agent.ContainerStatusFailed,
+		"c": agent.ContainerStatusRunning,
+		"d": agent.ContainerStatusFinished,
+	} {
+		c := newFakeContainer(id)
+		c.ContainerStatus = status
+		registry.register(c)
+	}
+
+	// e is a crash-looping task of harpoon-scheduler, which would schedule it
+	// again if it were reaped.
+	e := newFakeContainer("e")
+	e.ContainerStatus = agent.ContainerStatusFailed
+	e.CrashLooping = true
+	e.Labels = map[string]string{"job": "bazooka"}
+	registry.register(e)
+
+	for i, input := range []struct {
+		now        time.Time
+		containers []string
+		tombstones []string
+	}{
+		{t0, []string{"c", "d", "e"}, []string{"a", "b"}},                  // max count
+		{t0.Add(time.Second), []string{"c", "d", "e"}, []string{"a", "b"}}, // young enough
+		{t0.Add(time.Minute), []string{"c", "e"}, []string{"a", "b", "d"}}, // max age
+		{t0.Add(time.Hour), []string{"c", "e"}, []string{"a", "b", "d"}},   // running, or scheduled
+	} {
+		reaper.reap(input.now)
+
+		containers := []string{}
+		for id := range registry.instances() {
+			containers = append(containers, id)
+		}
+		sort.Strings(containers)
+
+		if want, have := input.containers, containers; !reflect.DeepEqual(want, have) {
+			t.Errorf("%d: want containers %v, have %v", i, want, have)
+		}
+
+		tombstones := []string{}
+		for _, tombstone := range registry.tombstoneList() {
+			tombstones = append(tombstones, tombstone.ID)
+		}
+
+		if want, have := input.tombstones, tombstones; !reflect.DeepEqual(want, have) {
+			t.Errorf("%d: want tombstones %v, have %v", i, want, have)
+		}
+	}
+}
+
+func TestTombstoneAPI(t *testing.T) {
+	var (
+		registry = newRegistry()
+		pdb      = newPortDB(lowTestPort, highTestPort)
+		api      = newAPI(fixtureContainerRoot, registry, pdb, nil, nil)
+		server   = httptest.NewServer(api)
+		reaped   = time.Now().UTC()
+	)
+	defer pdb.exit()
+	defer server.Close()
+
+	registry.bury(agent.ContainerInstance{
+		ID:              "failed",
+		ContainerStatus: agent.ContainerStatusFailed,
+		ContainerProcessState: agent.ContainerProcessState{
+			Err: "artifact download failed",
+		},
+	}, reaped)
+
+	resp, err := http.Get(server.URL + "/api/v0/tombstones/failed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var tombstone agent.Tombstone
+	if err := json.NewDecoder(resp.Body).Decode(&tombstone); err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := agent.ContainerStatusFailed, tombstone.ContainerStatus; want != have {
+		t.Errorf("want status %q, have %q", want, have)
+	}
+
+	if want, have := "artifact download failed", tombstone.Err; want != have {
+		t.Errorf("want err %q, have %q", want, have)
+	}
+
+	if !tombstone.Reaped.Equal(reaped) {
+		t.Errorf("want reaped %s, have %s", reaped, tombstone.Reaped)
+	}
+
+	resp, err = http.Get(server.URL + "/api/v0/tombstones/unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if want, have := http.StatusNotFound, resp.StatusCode; want != have {
+		t.Errorf("want %d for unknown tombstone, have %d", want, have)
+	}
+}
@@ -12,7 +12,7 @@ import (
 
 // recoverContainers restores container states from disk, e.g., after
 // harpoon-agent is restarted.
-func recoverContainers(containerRoot string, r *registry, pdb *portDB) {
+func recoverContainers(containerRoot string, r *registry, pdb *portDB, adb *addressDB, am *artifactManager) {
 	// Get only containers which have been successfully started
 	containerFilePaths, err := filepath.Glob(filepath.Join(containerRoot, "*", "container.json"))
 	if err != nil {
@@ -32,7 +32,7 @@ func recoverContainers(containerRoot string, r *registry, pdb *portDB) {
 		containerRoot := filepath.Dir(containerDir)
 		id := filepath.Base(containerDir)
 
-		err := recoverContainer(id, containerRoot, r, pdb)
+		err := recoverContainer(id, containerRoot, r, pdb, adb, am)
 		if err == nil {
 			log.Printf("recovered container %q from %s", id, containerDir)
 			continue
@@ -41,7 +41,7 @@ func recoverContainers(containerRoot string, r *registry, pdb *portDB) {
 	}
 }
 
-func recoverContainer(id string, containerRoot string, r *registry, pdb *portDB) error {
+func recoverContainer(id string, containerRoot string, r *registry, pdb *portDB, adb *addressDB, am *artifactManager) error {
 	agentFilePath := filepath.Join(containerRoot, id, "agent.json")
 	agentFile, err := os.Open(agentFilePath)
 	if err != nil {
@@ -54,7 +54,7 @@ func recoverContainer(id string, containerRoot string, r *registry, pdb *portDB)
 		return fmt.Errorf("could not parse agent file: %s", err)
 	}
 
-	c := newContainer(id, containerRoot, agentConfig, pdb)
+	c := newContainer(id, containerRoot, agentConfig, pdb, adb, am)
 	if err := c.Recover(); err != nil {
 		c.Exit()
 		return err
@@ -2,25 +2,52 @@ package main
 
 import (
 	"sync"
+	"time"
 
 	"github.com/soundcloud/harpoon/harpoon-agent/lib"
 )
 
+// stateHistorySize is the number of state changes the registry keeps, for
+// subscribers resuming after an interruption.
+const stateHistorySize = 1000
+
+// tombstoneHistorySize is the number of tombstones the registry keeps, of
+// containers that were garbage-collected.
+const tombstoneHistorySize = 100
+
 type registry struct {
 	m           map[string]container
 	statec      chan agent.ContainerInstance
-	subscribers map[chan<- agent.ContainerInstance]struct{}
+	subscribers map[chan<- stateChange]struct{}
+
+	// Every state change is numbered with the next generation. Generations
+	// start at the time the registry is created, so that they keep
+	// increasing when the agent restarts.
+	generation uint64
+	history    []stateChange
+	historyMu  sync.Mutex
+
+	// tombstones records the final state of the most recently
+	// garbage-collected containers, oldest first.
+	tombstones []agent.Tombstone
 
 	acceptUpdates bool
 
 	sync.RWMutex
 }
 
+// stateChange is the state of a container, and the generation it changed in.
+type stateChange struct {
+	generation uint64
+	instance   agent.ContainerInstance
+}
+
 func newRegistry() *registry {
 	r := &registry{
 		m:           map[string]container{},
 		statec:      make(chan agent.ContainerInstance),
-		subscribers: map[chan<- agent.ContainerInstance]struct{}{},
+		subscribers: map[chan<- stateChange]struct{}{},
+		generation:  uint64(time.Now().UnixNano()),
 	}
 
 	go r.loop()
@@ -35,6 +62,37 @@ func (r *registry) remove(id string) {
 	delete(r.m, id)
 }
 
+// bury removes a container which was garbage-collected, and keeps a
+// tombstone of its final state.
+func (r *registry) bury(instance agent.ContainerInstance, reaped time.Time) {
+	r.Lock()
+	defer r.Unlock()
+
+	delete(r.m, instance.ID)
+
+	r.tombstones = append(r.tombstones, agent.Tombstone{
+		ID:                    instance.ID,
+		ContainerStatus:       instance.ContainerStatus,
+		ContainerProcessState: instance.ContainerProcessState,
+		Reaped:                reaped,
+	})
+	if len(r.tombstones) > tombstoneHistorySize {
+		r.tombstones = r.tombstones[len(r.tombstones)-tombstoneHistorySize:]
+	}
+}
+
+// tombstoneList returns the tombstones of garbage-collected containers,
+// oldest first.
+func (r *registry) tombstoneList() []agent.Tombstone {
+	r.RLock()
+	defer r.RUnlock()
+
+	tombstones := make([]agent.Tombstone, len(r.tombstones))
+	copy(tombstones, r.tombstones)
+
+	return tombstones
+}
+
 func (r *registry) get(id string) (container, bool) {
 	r.RLock()
 	defer r.RUnlock()
@@ -97,14 +155,47 @@ func (r *registry) acceptStateUpdates() {
 	r.acceptUpdates = true // TODO(pb): this isn't used anywhere
 }
 
-func (r *registry) notify(c chan<- agent.ContainerInstance) {
+// notify subscribes c to state changes, and returns the current generation,
+// i.e. that of the last change c won't receive.
+func (r *registry) notify(c chan<- stateChange) uint64 {
 	r.Lock()
 	defer r.Unlock()
 
 	r.subscribers[c] = struct{}{}
+
+	r.historyMu.Lock()
+	defer r.historyMu.Unlock()
+
+	return r.generation
+}
+
+// changes returns the state of every container which changed after
+// generation from, up to and including generation to. It returns false if
+// some of these changes are no longer in the history.
+func (r *registry) changes(from, to uint64) (map[string]agent.ContainerInstance, bool) {
+	r.historyMu.Lock()
+	defer r.historyMu.Unlock()
+
+	if from > to || to > r.generation {
+		return nil, false
+	}
+
+	if from < to && (len(r.history) == 0 || r.history[0].generation > from+1) {
+		return nil, false
+	}
+
+	changed := map[string]agent.ContainerInstance{}
+
+	for _, change := range r.history {
+		if change.generation > from && change.generation <= to {
+			changed[change.instance.ID] = change.instance
+		}
+	}
+
+	return changed, true
 }
 
-func (r *registry) stop(c chan<- agent.ContainerInstance) {
+func (r *registry) stop(c chan<- stateChange) {
 	r.Lock()
 	defer r.Unlock()
 
@@ -119,9 +210,29 @@ func (r *registry) loop() {
 			r.RLock()
 			defer r.RUnlock()
 
+			change := r.record(containerInstance)
+
 			for subc := range r.subscribers {
-				subc <- containerInstance
+				subc <- change
 			}
 		}()
 	}
 }
+
+// record numbers a state change with the next generation, and adds it to the
+// history.
+func (r *registry) record(instance agent.ContainerInstance) stateChange {
+	r.historyMu.Lock()
+	defer r.historyMu.Unlock()
+
+	r.generation++
+
+	change := stateChange{generation: r.generation, instance: instance}
+
+	r.history = append(r.history, change)
+	if len(r.history) > stateHistorySize {
+		r.history = r.history[len(r.history)-stateHistorySize:]
+	}
+
+	return change
+}
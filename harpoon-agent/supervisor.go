@@ -22,6 +22,7 @@ type supervisor struct {
 
 	exitc        chan chan error
 	stopc        chan time.Duration
+	signalc      chan string
 	subscribec   chan chan<- agent.ContainerProcessState
 	unsubscribec chan chan<- agent.ContainerProcessState
 	statec       chan agent.ContainerProcessState
@@ -35,6 +36,7 @@ func newSupervisor(id string, rundir string) *supervisor {
 		rundir:       rundir,
 		exitc:        make(chan chan error),
 		stopc:        make(chan time.Duration),
+		signalc:      make(chan string),
 		subscribec:   make(chan chan<- agent.ContainerProcessState),
 		unsubscribec: make(chan chan<- agent.ContainerProcessState),
 		statec:       make(chan agent.ContainerProcessState),
@@ -44,8 +46,11 @@ func newSupervisor(id string, rundir string) *supervisor {
 
 // Start starts the supervisor and connects to its control socket. If an error
 // is returned, the supervisor was not started.
-func (s *supervisor) Start(config agent.ContainerConfig, stdout, stderr io.Writer) error {
+func (s *supervisor) Start(config agent.ContainerConfig, adb *addressDB, stdout, stderr io.Writer) error {
 	args := []string{"--hostname", systemHostname(), "--id", s.ID}
+	if config.Network == agent.NetworkPrivate {
+		args = append(args, "--bridge", adb.bridge, "--gateway", adb.gateway.String())
+	}
 	args = append(args, "--")
 	args = append(args, config.Command.Exec...)
 
@@ -150,6 +155,12 @@ func (s *supervisor) Stop(grace time.Duration) {
 	s.stopc <- grace
 }
 
+// Signal sends the named signal to the container. Unlike Stop, it doesn't
+// affect restarts.
+func (s *supervisor) Signal(name string) {
+	s.signalc <- name
+}
+
 func (s *supervisor) Subscribe(c chan<- agent.ContainerProcessState) {
 	s.subscribec <- c
 }
@@ -280,6 +291,12 @@ func (s *supervisor) loop(rwc io.ReadWriteCloser) {
 
 			killTimer = time.After(grace)
 
+		case name := <-s.signalc:
+			enc.Encode(eventsource.Event{
+				Type: "signal",
+				Data: []byte(name),
+			})
+
 		case <-killTimer:
 			incContainerStatusKilled(1)
 
@@ -12,6 +12,10 @@ import (
 const maxLogLineLength = 50000
 
 var (
+	// logRoot holds the log directory of each container, which svlogd writes
+	// to.
+	logRoot = "/srv/harpoon/log"
+
 	// persist container logs to disk
 	logConfig = `
 # rotate if current log is larger than 5242880 bytes
@@ -19,6 +19,7 @@ type transition int
 const (
 	schedule   transition = iota // async request made; from user
 	unschedule                   // async request made; from user
+	preparing                    // from event stream
 	created                      // from event stream
 	running                      // from event stream
 	stopped                      // == failed/finished; from event stream
@@ -47,6 +48,8 @@ func (t transition) String() string {
 		return "schedule"
 	case unschedule:
 		return "unschedule"
+	case preparing:
+		return "preparing"
 	case created:
 		return "created"
 	case running:
@@ -63,6 +66,8 @@ func (t transition) String() string {
 
 func s2t(s agent.ContainerStatus) transition {
 	switch s {
+	case agent.ContainerStatusPreparing:
+		return preparing
 	case agent.ContainerStatusCreated:
 		return created
 	case agent.ContainerStatusRunning:
@@ -84,6 +89,8 @@ func initialState(t transition) stateFn {
 		return pendingScheduleState
 	case unschedule:
 		return nil
+	case preparing:
+		return preparingState
 	case created:
 		return createdState
 	case running:
@@ -105,6 +112,8 @@ func pendingScheduleState(t transition) stateFn {
 		return pendingScheduleState
 	case unschedule:
 		return pendingScheduleState
+	case preparing:
+		return preparingState
 	case created:
 		return createdState
 	case running:
@@ -120,12 +129,37 @@ func pendingScheduleState(t transition) stateFn {
 	}
 }
 
+func preparingState(t transition) stateFn {
+	switch t {
+	case schedule:
+		return preparingState
+	case unschedule:
+		return createdPendingUnscheduleState
+	case preparing:
+		return preparingState
+	case created:
+		return createdState
+	case running:
+		return runningState
+	case stopped:
+		return createdState
+	case deleted:
+		return nil
+	case timeout:
+		return preparingState // the agent is still working on it
+	default:
+		panic("unreachable")
+	}
+}
+
 func createdState(t transition) stateFn {
 	switch t {
 	case schedule:
 		return createdState
 	case unschedule:
 		return createdPendingUnscheduleState
+	case preparing:
+		return preparingState
 	case created:
 		return createdState
 	case running:
@@ -147,6 +181,8 @@ func runningState(t transition) stateFn {
 		return runningState
 	case unschedule:
 		return runningPendingUnscheduleState
+	case preparing:
+		return preparingState
 	case created:
 		return createdState
 	case running:
@@ -168,6 +204,8 @@ func createdPendingUnscheduleState(t transition) stateFn {
 		return createdPendingUnscheduleState // ignore
 	case unschedule:
 		return createdPendingUnscheduleState // ignore
+	case preparing:
+		return createdPendingUnscheduleState // ignore
 	case created:
 		return createdPendingUnscheduleState // ignore
 	case running:
@@ -189,6 +227,8 @@ func runningPendingUnscheduleState(t transition) stateFn {
 		return runningPendingUnscheduleState // ignore
 	case unschedule:
 		return runningPendingUnscheduleState // ignore
+	case preparing:
+		return createdPendingUnscheduleState // shift
 	case created:
 		return createdPendingUnscheduleState // shift
 	case running:
@@ -113,6 +113,11 @@ func (c *fakeClient) Events() (<-chan agent.StateEvent, agent.Stopper, error) {
 	return outc, stopper(stopc), nil
 }
 
+// EventsSince ignores the generation, and always starts with a snapshot.
+func (c *fakeClient) EventsSince(uint64) (<-chan agent.StateEvent, agent.Stopper, error) {
+	return c.Events()
+}
+
 func (c *fakeClient) Create(id string, _ agent.ContainerConfig) error {
 	c.Lock()
 	defer c.Unlock()
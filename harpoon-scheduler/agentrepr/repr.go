@@ -27,12 +27,19 @@ var (
 	// Those containers will be re-scheduled on other agents.
 	AbandonTimeout = 20 * time.Second //5 * time.Minute
 
+	// HeartbeatTimeout is how long the state machine will wait for any event
+	// from a remote agent, which sends heartbeats every few seconds, before
+	// it considers the connection hung, and reconnects.
+	HeartbeatTimeout = 10 * time.Second
+
 	// PendingOperationTimeout dictates how long a schedule or unschedule
 	// command may stay pending and unrealized before we give up and allow the
 	// client to repeat the command.
 	PendingOperationTimeout = 20 * time.Second //1 * time.Minute
 
 	errAgentConnectionInterrupted = errors.New("agent connection interrupted")
+	errAgentHeartbeatTimeout      = errors.New("agent heartbeat timeout")
+	errAgentResumeAbandoned       = errors.New("agent abandoned since the resumed generation")
 	errTransactionPending         = errors.New("transaction already pending for this container")
 )
 
@@ -62,7 +69,7 @@ type representation struct {
 	subc          chan chan<- map[string]agent.StateEvent
 	unsubc        chan chan<- map[string]agent.StateEvent
 	connectedc    chan bool
-	initializec   chan agent.StateEvent
+	initializec   chan initialization
 	updatec       chan agent.StateEvent
 	snapshotc     chan map[string]agent.StateEvent
 	interruptionc chan struct{}
@@ -76,6 +83,16 @@ type representation struct {
 	*outstanding
 	*connection
 
+	// generation of the last event read from the agent, to resume the event
+	// stream from after an interruption. Only used by the connectionLoop.
+	generation uint64
+
+	// Timeouts and intervals, as they were set when the representation was
+	// created, so changing them doesn't affect running representations.
+	reconnectInterval time.Duration
+	abandonTimeout    time.Duration
+	heartbeatTimeout  time.Duration
+
 	Client
 
 	sync.WaitGroup
@@ -85,7 +102,7 @@ type representation struct {
 // representation. Client may be satisfied by an agent.NewClient.
 type Client interface {
 	Endpoint() string
-	Events() (<-chan agent.StateEvent, agent.Stopper, error)
+	EventsSince(uint64) (<-chan agent.StateEvent, agent.Stopper, error)
 	Put(string, agent.ContainerConfig) error
 	Start(string) error
 	Stop(string) error
@@ -98,7 +115,7 @@ func New(c Client) *representation {
 		subc:          make(chan chan<- map[string]agent.StateEvent),
 		unsubc:        make(chan chan<- map[string]agent.StateEvent),
 		connectedc:    make(chan bool),
-		initializec:   make(chan agent.StateEvent),
+		initializec:   make(chan initialization),
 		updatec:       make(chan agent.StateEvent),
 		snapshotc:     make(chan map[string]agent.StateEvent),
 		interruptionc: make(chan struct{}),
@@ -112,6 +129,10 @@ func New(c Client) *representation {
 		outstanding: newOutstanding(),
 		connection:  newConnection(),
 
+		reconnectInterval: ReconnectInterval,
+		abandonTimeout:    AbandonTimeout,
+		heartbeatTimeout:  HeartbeatTimeout,
+
 		Client: c,
 	}
 
@@ -156,9 +177,10 @@ func (r *representation) Schedule(id string, c agent.ContainerConfig) error {
 
 	// Schedule is a command, and commands are processed asynchronously, i.e.
 	// out of the primary requestLoop. Therefore, when we signal Put to the
-	// remote agent, the response events (Created, then Running) may get to
-	// the requestLoop before we actually return from the invocation! So, we
-	// register our "outstanding" ahead of time, and cancel if the Put fails.
+	// remote agent, the response events (Preparing, Created, then Running)
+	// may get to the requestLoop before we actually return from the
+	// invocation! So, we register our "outstanding" ahead of time, and cancel
+	// if the Put fails.
 
 	r.outstanding.want(id, agent.ContainerStatusRunning, r.successc, r.failurec)
 
@@ -224,14 +246,14 @@ func (r *representation) connectionLoop() {
 	defer r.WaitGroup.Done()
 
 	for {
-		statec, stopper, err := r.Client.Events()
+		statec, stopper, err := r.Client.EventsSince(r.generation)
 		if err != nil {
 			log.Printf("%s: %s", r.Endpoint(), err)
 
 			select {
 			case <-r.quitc:
 				return
-			case <-xtime.After(ReconnectInterval):
+			case <-xtime.After(r.reconnectInterval):
 				continue
 			}
 		}
@@ -239,7 +261,7 @@ func (r *representation) connectionLoop() {
 		log.Printf("%s: connection established", r.Endpoint())
 		metrics.IncAgentConnectionsEstablished(1)
 
-		if err := r.readLoop(statec, stopper); err != nil {
+		if err := r.readLoop(statec, stopper, r.generation > 0); err != nil {
 			log.Printf("%s: %s", r.Endpoint(), err)
 			metrics.IncAgentConnectionsInterrupted(1)
 
@@ -248,7 +270,7 @@ func (r *representation) connectionLoop() {
 			select {
 			case <-r.quitc:
 				return
-			case <-xtime.After(ReconnectInterval):
+			case <-xtime.After(r.reconnectInterval):
 				continue
 			}
 		}
@@ -257,22 +279,34 @@ func (r *representation) connectionLoop() {
 	}
 }
 
-func (r *representation) readLoop(statec <-chan agent.StateEvent, stopper agent.Stopper) error {
+// readLoop reads events from the agent until the connection breaks. If the
+// connection resumed the event stream from a generation, the first event only
+// reflects the containers which changed since.
+func (r *representation) readLoop(statec <-chan agent.StateEvent, stopper agent.Stopper, resumed bool) error {
 	defer stopper.Stop()
 
 	// When this function exits, we've lost our connection to the agent. When
 	// that happens, in the request loop, we start an "abandon" timer. When
 	// that timer fires, it flushes all container instance state, and thereby
 	// signals all containers as lost. The abandon timer is reset when we send
-	// the first successful state update over initializec.
+	// the first successful state update over initializec. Once the agent has
+	// been abandoned, the changes since the resumed generation no longer
+	// suffice, and the request loop rejects them. We then reconnect from
+	// scratch, to get the state of all containers.
 
 	first := true
 
 	for {
+		// Agents send heartbeats, so a quiet connection is a hung one.
+		deadline := xtime.After(r.heartbeatTimeout)
+
 		select {
 		case <-r.quitc:
 			return nil
 
+		case <-deadline:
+			return errAgentHeartbeatTimeout
+
 		case state, ok := <-statec:
 			if !ok {
 				// When we detect a connection error, we'll trigger the lost
@@ -282,10 +316,23 @@ func (r *representation) readLoop(statec <-chan agent.StateEvent, stopper agent.
 				return errAgentConnectionInterrupted
 			}
 
+			if state.Generation > 0 {
+				r.generation = state.Generation
+			}
+
+			if state.Heartbeat {
+				continue
+			}
+
 			metrics.IncContainerEventsReceived(1)
 
 			if first {
-				r.initializec <- state // clears previous abandon timer
+				acceptedc := make(chan bool)
+				r.initializec <- initialization{state, resumed, acceptedc} // clears previous abandon timer
+				if !<-acceptedc {
+					r.generation = 0
+					return errAgentResumeAbandoned
+				}
 				first = false
 				continue
 			}
@@ -299,7 +346,8 @@ func (r *representation) requestLoop() {
 	defer r.WaitGroup.Done()
 
 	var (
-		abandonc <-chan time.Time // initially nil
+		abandonc  <-chan time.Time // initially nil
+		abandoned bool
 	)
 
 	// Any logic in a case block should be moved to a method with the same
@@ -321,10 +369,15 @@ func (r *representation) requestLoop() {
 		case r.connectedc <- r.connection.connected():
 			Debugf("%s: connectedc", r.Endpoint())
 
-		case state := <-r.initializec:
+		case init := <-r.initializec:
 			Debugf("%s: initializec", r.Endpoint())
-			abandonc = nil
-			r.initialize(state)
+			if init.resumed && abandoned {
+				init.acceptedc <- false
+				continue
+			}
+			abandonc, abandoned = nil, false
+			init.acceptedc <- true
+			r.initialize(init.StateEvent)
 
 		case state := <-r.updatec:
 			//Debugf("%s: updatec", r.Endpoint())
@@ -336,7 +389,7 @@ func (r *representation) requestLoop() {
 		case <-r.interruptionc:
 			Debugf("%s: interruptionc", r.Endpoint())
 			if abandonc == nil {
-				abandonc = xtime.After(AbandonTimeout)
+				abandonc = xtime.After(r.abandonTimeout)
 			}
 			r.interruption()
 
@@ -350,6 +403,7 @@ func (r *representation) requestLoop() {
 
 		case <-abandonc:
 			Debugf("%s: abandonc", r.Endpoint())
+			abandoned = true
 			r.abandon()
 
 		case <-r.quitc:
@@ -374,6 +428,13 @@ func (r *representation) initialize(e agent.StateEvent) {
 }
 
 func (r *representation) update(e agent.StateEvent) {
+	for id, instance := range e.Containers {
+		if instance.ContainerStatus == agent.ContainerStatusFailed && instance.CrashLooping {
+			log.Printf("%s: container %s: %s", r.Endpoint(), id, instance.Err)
+			metrics.IncContainersCrashLooping(1)
+		}
+	}
+
 	r.resources.set(e.Resources)
 	r.instances.advanceMany(e.Containers)
 	r.outstanding.signal(e.Containers)
@@ -427,6 +488,14 @@ func (r *representation) broadcast() {
 	r.subscribers.broadcast(r.snapshot())
 }
 
+// initialization is the first event of a connection to the agent. It's only
+// accepted if it reflects all containers, or the agent wasn't abandoned.
+type initialization struct {
+	agent.StateEvent
+	resumed   bool
+	acceptedc chan bool
+}
+
 type instances struct {
 	sync.RWMutex
 	m map[string]stateInstance
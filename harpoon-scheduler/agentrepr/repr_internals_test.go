@@ -184,3 +184,133 @@ func TestOutstanding(t *testing.T) {
 		t.Errorf("want %v, have %v", want, have)
 	}
 }
+
+func TestReadLoopHeartbeat(t *testing.T) {
+	var (
+		r = &representation{
+			initializec:      make(chan initialization, 1),
+			quitc:            make(chan struct{}),
+			heartbeatTimeout: 50 * time.Millisecond,
+		}
+		statec  = make(chan agent.StateEvent)
+		errc    = make(chan error)
+		stopper = stopper(make(chan struct{}))
+	)
+
+	go func() { errc <- r.readLoop(statec, stopper, false) }()
+
+	// Heartbeats keep the connection alive, and are remembered to resume
+	// from.
+	for i := 0; i < 3; i++ {
+		time.Sleep(r.heartbeatTimeout / 2)
+		statec <- agent.StateEvent{Generation: uint64(i + 1), Heartbeat: true}
+	}
+
+	select {
+	case err := <-errc:
+		if want, have := errAgentHeartbeatTimeout, err; want != have {
+			t.Errorf("want %v, have %v", want, have)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("hung connection not detected")
+	}
+
+	if want, have := uint64(3), r.generation; want != have {
+		t.Errorf("want generation %d, have %d", want, have)
+	}
+}
+
+func TestAbandonedResumeGetsSnapshot(t *testing.T) {
+	defer func(d time.Duration) { AbandonTimeout = d }(AbandonTimeout)
+	defer func(d time.Duration) { ReconnectInterval = d }(ReconnectInterval)
+	AbandonTimeout = 10 * time.Millisecond
+	ReconnectInterval = time.Millisecond
+
+	var (
+		c = &resumeClient{
+			generationc: make(chan uint64),
+			streamc:     make(chan chan agent.StateEvent),
+		}
+		r       = New(c)
+		running = agent.ContainerInstance{ContainerStatus: agent.ContainerStatusRunning}
+	)
+	defer r.Quit()
+
+	connect := func(generation uint64) chan agent.StateEvent {
+		select {
+		case have := <-c.generationc:
+			if want := generation; want != have {
+				t.Fatalf("want to resume from generation %d, have %d", want, have)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no connection")
+		}
+
+		statec := make(chan agent.StateEvent)
+		c.streamc <- statec
+		return statec
+	}
+
+	statec := connect(0)
+	statec <- agent.StateEvent{Generation: 5, Containers: map[string]agent.ContainerInstance{"a": running, "b": running}}
+	waitForContainers(t, r, "a", "b")
+
+	// The agent is abandoned after the connection breaks.
+	close(statec)
+	waitForContainers(t, r)
+
+	// Only b changed since generation 5, so resuming doesn't bring back a.
+	// That's rejected, and the representation reconnects from scratch.
+	statec = connect(5)
+	statec <- agent.StateEvent{Generation: 6, Containers: map[string]agent.ContainerInstance{"b": running}}
+
+	statec = connect(0)
+	statec <- agent.StateEvent{Generation: 6, Containers: map[string]agent.ContainerInstance{"a": running, "b": running}}
+	waitForContainers(t, r, "a", "b")
+}
+
+func waitForContainers(t *testing.T, r *representation, ids ...string) {
+	deadline := time.After(time.Second)
+
+	for {
+		have := r.Snapshot()[r.Endpoint()].Containers
+
+		if len(have) == len(ids) {
+			found := 0
+			for _, id := range ids {
+				if _, ok := have[id]; ok {
+					found++
+				}
+			}
+
+			if found == len(ids) {
+				return
+			}
+		}
+
+		select {
+		case <-deadline:
+			t.Fatalf("want containers %v, have %v", ids, have)
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+// resumeClient hands out the event streams the test sends on streamc, after
+// sending the generation they resume from on generationc.
+type resumeClient struct {
+	generationc chan uint64
+	streamc     chan chan agent.StateEvent
+}
+
+func (c *resumeClient) Endpoint() string { return "resume" }
+
+func (c *resumeClient) EventsSince(generation uint64) (<-chan agent.StateEvent, agent.Stopper, error) {
+	c.generationc <- generation
+	return <-c.streamc, stopper(make(chan struct{})), nil
+}
+
+func (c *resumeClient) Put(string, agent.ContainerConfig) error { return nil }
+func (c *resumeClient) Start(string) error                      { return nil }
+func (c *resumeClient) Stop(string) error                       { return nil }
+func (c *resumeClient) Delete(string) error                     { return nil }
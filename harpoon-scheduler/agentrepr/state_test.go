@@ -15,6 +15,7 @@ func TestStateTransitions(t *testing.T) {
 		// from initialState
 		route{[]transition{schedule}, pendingScheduleState},
 		route{[]transition{unschedule}, nil}, // this is important; it can happen!
+		route{[]transition{preparing}, preparingState},
 		route{[]transition{created}, createdState},
 		route{[]transition{running}, runningState},
 		route{[]transition{stopped}, createdState},
@@ -24,15 +25,27 @@ func TestStateTransitions(t *testing.T) {
 		// from pendingSchedule
 		route{[]transition{schedule, schedule}, pendingScheduleState},
 		route{[]transition{schedule, unschedule}, pendingScheduleState},
+		route{[]transition{schedule, preparing}, preparingState},
 		route{[]transition{schedule, created}, createdState},
 		route{[]transition{schedule, running}, runningState},
 		route{[]transition{schedule, stopped}, createdState},
 		route{[]transition{schedule, deleted}, nil},
 		route{[]transition{schedule, timeout}, nil},
 
+		// from preparingState
+		route{[]transition{preparing, schedule}, preparingState},
+		route{[]transition{preparing, unschedule}, createdPendingUnscheduleState},
+		route{[]transition{preparing, preparing}, preparingState},
+		route{[]transition{preparing, created}, createdState},
+		route{[]transition{preparing, running}, runningState},
+		route{[]transition{preparing, stopped}, createdState}, // e.g. failed to fetch the artifact
+		route{[]transition{preparing, deleted}, nil},
+		route{[]transition{preparing, timeout}, preparingState}, // a large artifact may take a while
+
 		// from createdState
 		route{[]transition{created, schedule}, createdState},
 		route{[]transition{created, unschedule}, createdPendingUnscheduleState},
+		route{[]transition{created, preparing}, preparingState},
 		route{[]transition{created, created}, createdState},
 		route{[]transition{created, running}, runningState},
 		route{[]transition{created, stopped}, createdState},
@@ -42,6 +55,7 @@ func TestStateTransitions(t *testing.T) {
 		// from runningState
 		route{[]transition{running, schedule}, runningState}, // this is important; it can happen!
 		route{[]transition{running, unschedule}, runningPendingUnscheduleState},
+		route{[]transition{running, preparing}, preparingState},
 		route{[]transition{running, created}, createdState},
 		route{[]transition{running, running}, runningState},
 		route{[]transition{running, stopped}, createdState},
@@ -51,18 +65,20 @@ func TestStateTransitions(t *testing.T) {
 		// from createdPendingUnscheduleState
 		route{[]transition{created, unschedule, schedule}, createdPendingUnscheduleState},
 		route{[]transition{created, unschedule, unschedule}, createdPendingUnscheduleState},
-		route{[]transition{created, unschedule, created}, createdPendingUnscheduleState}, // command might not have arrived yet
-		route{[]transition{created, unschedule, running}, runningPendingUnscheduleState}, // command might not have arrived yet
-		route{[]transition{created, unschedule, stopped}, createdPendingUnscheduleState}, // command might not have arrived yet
+		route{[]transition{created, unschedule, preparing}, createdPendingUnscheduleState}, // command might not have arrived yet
+		route{[]transition{created, unschedule, created}, createdPendingUnscheduleState},   // command might not have arrived yet
+		route{[]transition{created, unschedule, running}, runningPendingUnscheduleState},   // command might not have arrived yet
+		route{[]transition{created, unschedule, stopped}, createdPendingUnscheduleState},   // command might not have arrived yet
 		route{[]transition{created, unschedule, deleted}, nil},
 		route{[]transition{created, unschedule, timeout}, createdState},
 
 		// from runningPendingUnscheduleState
 		route{[]transition{running, unschedule, schedule}, runningPendingUnscheduleState},
 		route{[]transition{running, unschedule, unschedule}, runningPendingUnscheduleState},
-		route{[]transition{running, unschedule, created}, createdPendingUnscheduleState}, // command might not have arrived yet
-		route{[]transition{running, unschedule, running}, runningPendingUnscheduleState}, // command might not have arrived yet
-		route{[]transition{running, unschedule, stopped}, createdPendingUnscheduleState}, // command might not have arrived yet
+		route{[]transition{running, unschedule, preparing}, createdPendingUnscheduleState}, // command might not have arrived yet
+		route{[]transition{running, unschedule, created}, createdPendingUnscheduleState},   // command might not have arrived yet
+		route{[]transition{running, unschedule, running}, runningPendingUnscheduleState},   // command might not have arrived yet
+		route{[]transition{running, unschedule, stopped}, createdPendingUnscheduleState},   // command might not have arrived yet
 		route{[]transition{running, unschedule, deleted}, nil},
 		route{[]transition{running, unschedule, timeout}, runningState},
 	} {
@@ -2,6 +2,8 @@ package algo
 
 import (
 	"math/rand"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/soundcloud/harpoon/harpoon-agent/lib"
@@ -10,12 +12,26 @@ import (
 // PendingTask represents a task that has already been un/scheduled but it's still pending
 // Located here in order to avoid circular dependency with "xf" package.
 type PendingTask struct {
-	Schedule bool // true = pending schedule; false = pending unschedule
+	Schedule bool   // true = pending schedule; false = pending unschedule
+	Replace  bool   // true = pending schedule of a replacement for an unhealthy or draining instance
+	Previous string // endpoint of the replaced instance, for replacements
 	Deadline time.Time
 	Endpoint string
 	agent.ContainerConfig
 }
 
+// Algorithm places tasks (want) on agents (have), taking the resources of
+// pending tasks into account. It returns the tasks it placed, by endpoint,
+// and those it couldn't place.
+type Algorithm func(
+	want map[string]agent.ContainerConfig,
+	have map[string]agent.StateEvent,
+	pending map[string]PendingTask,
+) (
+	mapped map[string]map[string]agent.ContainerConfig,
+	failed map[string]agent.ContainerConfig,
+)
+
 // RandomChoice implements a demo scheduling algorithm. It's intended to be a
 // demo, and it's not suitable for actual use.
 func RandomChoice(
@@ -26,19 +42,29 @@ func RandomChoice(
 	mapped map[string]map[string]agent.ContainerConfig,
 	failed map[string]agent.ContainerConfig,
 ) {
+	mapped = map[string]map[string]agent.ContainerConfig{}
+	failed = map[string]agent.ContainerConfig{}
+
 	if len(want) <= 0 {
 		return mapped, failed
 	}
 
-	var (
-		endpoints = make([]string, 0, len(have))
-	)
+	for id, config := range want {
+		endpoints := make([]string, 0, len(have))
 
-	for endpoint := range have {
-		endpoints = append(endpoints, endpoint)
-	}
+		for endpoint, state := range have {
+			if state.Resources.Draining || !satisfies(config, state.Resources.Labels) {
+				continue
+			}
+
+			endpoints = append(endpoints, endpoint)
+		}
+
+		if len(endpoints) <= 0 {
+			failed[id] = config
+			continue
+		}
 
-	for id, config := range want {
 		endpoint := endpoints[rand.Intn(len(endpoints))]
 
 		placed, ok := mapped[endpoint]
@@ -77,6 +103,7 @@ func RandomFit(
 			r := resources[task.Endpoint]
 			r.CPU.Reserved += task.ContainerConfig.CPU
 			r.Mem.Reserved += task.ContainerConfig.Mem
+			r.Storage.Reserved += task.ContainerConfig.TempSize()
 			resources[task.Endpoint] = r
 		}
 	}
@@ -104,6 +131,7 @@ func RandomFit(
 		r := resources[chosen]
 		r.CPU.Reserved += config.CPU
 		r.Mem.Reserved += config.Mem
+		r.Storage.Reserved += config.TempSize()
 		resources[chosen] = r
 	}
 
@@ -139,6 +167,7 @@ func LeastUsed(
 			r := resources[task.Endpoint]
 			r.CPU.Reserved += task.ContainerConfig.CPU
 			r.Mem.Reserved += task.ContainerConfig.Mem
+			r.Storage.Reserved += task.ContainerConfig.TempSize()
 			resources[task.Endpoint] = r
 		}
 		e2c[task.Endpoint]++
@@ -169,6 +198,7 @@ func LeastUsed(
 		r := resources[chosen]
 		r.CPU.Reserved += config.CPU
 		r.Mem.Reserved += config.Mem
+		r.Storage.Reserved += config.TempSize()
 		resources[chosen] = r
 
 		e2c[chosen]++
@@ -177,6 +207,87 @@ func LeastUsed(
 	return mapped, failed
 }
 
+// Spread returns a scheduling algorithm which spreads the tasks of a job
+// over as many agents as possible, so a single failure only takes down some
+// of them. Containers are placed on an agent that meets their constraints,
+// and runs the fewest tasks of the same job. If label is set, tasks are
+// spread over the values of that label first, e.g. racks or zones. Agents
+// without the label are considered one more rack or zone. Remaining ties go
+// to the agent running the fewest containers.
+func Spread(label string) Algorithm {
+	return func(
+		want map[string]agent.ContainerConfig,
+		have map[string]agent.StateEvent,
+		pending map[string]PendingTask,
+	) (
+		mapped map[string]map[string]agent.ContainerConfig,
+		failed map[string]agent.ContainerConfig,
+	) {
+		mapped = map[string]map[string]agent.ContainerConfig{}
+		failed = map[string]agent.ContainerConfig{}
+
+		d := newDomain(have, pending)
+
+		// Place the tasks in a stable order, so the spread is predictable.
+		ids := make([]string, 0, len(want))
+		for id := range want {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		for _, id := range ids {
+			config := want[id]
+
+			// Find all candidates
+			valid := filter(d.Resources, config)
+			if len(valid) <= 0 {
+				failed[id] = config
+				continue
+			}
+
+			var (
+				j        = job(id)
+				strategy = spread{
+					e2d: map[string]int{},
+					e2j: d.Tasks[j],
+					e2c: d.Containers,
+				}
+			)
+
+			for _, endpoint := range valid {
+				strategy.e2d[endpoint] = d.TasksIn(j, label, d.Resources[endpoint].Labels[label])
+			}
+
+			strategy.sort(valid)
+
+			// Select the candidate running the fewest tasks of the job
+			chosen := valid[0]
+
+			// Place the container
+			target, ok := mapped[chosen]
+			if !ok {
+				target = map[string]agent.ContainerConfig{}
+			}
+			target[id] = config
+			mapped[chosen] = target
+
+			d.place(id, chosen, config)
+		}
+
+		return mapped, failed
+	}
+}
+
+// job returns the job of a task. Task IDs are the hash of their job's
+// config, followed by the task's index (see package xf).
+func job(id string) string {
+	if i := strings.LastIndex(id, "-"); i >= 0 {
+		return id[:i]
+	}
+
+	return id
+}
+
 func filter(have map[string]agent.HostResources, c agent.ContainerConfig) []string {
 	valid := make([]string, 0, len(have))
 
@@ -191,7 +302,37 @@ func filter(have map[string]agent.HostResources, c agent.ContainerConfig) []stri
 	return valid
 }
 
+// Constrained reports whether no agent satisfies the container's
+// constraints, regardless of the agents' resources.
+func Constrained(c agent.ContainerConfig, have map[string]agent.StateEvent) bool {
+	for _, state := range have {
+		if satisfies(c, state.Resources.Labels) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// satisfies reports whether an agent with the given labels satisfies all of
+// the container's constraints.
+func satisfies(c agent.ContainerConfig, labels map[string]string) bool {
+	for _, constraint := range c.Constraints {
+		if !constraint.Match(labels) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// match reports whether the container fits on the agent. Draining agents
+// take no new containers.
 func match(c agent.ContainerConfig, r agent.HostResources) bool {
+	if r.Draining || !satisfies(c, r.Labels) {
+		return false
+	}
+
 	if want, have := c.CPU, r.CPU.Total-r.CPU.Reserved; want > have {
 		return false
 	}
@@ -200,6 +341,10 @@ func match(c agent.ContainerConfig, r agent.HostResources) bool {
 		return false
 	}
 
+	if want, have := c.TempSize(), r.Storage.Total-r.Storage.Reserved; want > have {
+		return false
+	}
+
 	m := map[string]struct{}{}
 	for _, v := range r.Volumes {
 		m[v] = struct{}{}
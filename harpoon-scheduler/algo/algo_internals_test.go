@@ -39,6 +39,21 @@ func TestMatch(t *testing.T) {
 			agent.HostResources{CPU: agent.TotalReserved{Total: 16.0, Reserved: 12.1}},
 			false,
 		},
+		{
+			agent.ContainerConfig{Storage: agent.Storage{Temp: map[string]int{"/tmp": 64, "/var/tmp": -1}}},
+			agent.HostResources{Storage: agent.TotalReserved{Total: 128, Reserved: 64}},
+			true,
+		},
+		{
+			agent.ContainerConfig{Storage: agent.Storage{Temp: map[string]int{"/tmp": 64, "/var/tmp": 1}}},
+			agent.HostResources{Storage: agent.TotalReserved{Total: 128, Reserved: 64}},
+			false,
+		},
+		{
+			agent.ContainerConfig{Storage: agent.Storage{Temp: map[string]int{"/tmp": -1}}},
+			agent.HostResources{},
+			true,
+		},
 		{
 			agent.ContainerConfig{Storage: agent.Storage{Volumes: map[string]string{"/container/path": "/data/1"}}},
 			agent.HostResources{Volumes: []string{"/data/1"}},
@@ -54,6 +69,21 @@ func TestMatch(t *testing.T) {
 			agent.HostResources{Volumes: []string{"/data/1", "/data/2", "/data/3"}},
 			true,
 		},
+		{
+			agent.ContainerConfig{},
+			agent.HostResources{Draining: true},
+			false,
+		},
+		{
+			agent.ContainerConfig{Constraints: []agent.Constraint{{Label: "rack", Operator: agent.ConstraintEquals, Values: []string{"r1"}}}},
+			agent.HostResources{Labels: map[string]string{"rack": "r1"}},
+			true,
+		},
+		{
+			agent.ContainerConfig{Constraints: []agent.Constraint{{Label: "rack", Operator: agent.ConstraintEquals, Values: []string{"r1"}}}},
+			agent.HostResources{Labels: map[string]string{"rack": "r2"}},
+			false,
+		},
 	} {
 		if want, have := pair.want, match(pair.ContainerConfig, pair.HostResources); want != have {
 			t.Errorf("%d: want %v, have %v", i, want, have)
@@ -83,3 +83,231 @@ func TestLeastUsed(t *testing.T) {
 		t.Errorf("agent resources should not be changed want %f , have %f", want, have)
 	}
 }
+
+func TestConstraints(t *testing.T) {
+	var (
+		onWimpy = agent.ContainerConfig{Constraints: []agent.Constraint{{Label: "class", Operator: agent.ConstraintEquals, Values: []string{"wimpy"}}}}
+		nowhere = agent.ContainerConfig{Constraints: []agent.Constraint{{Label: "gpu", Operator: agent.ConstraintExists}}}
+		have    = map[string]agent.StateEvent{}
+	)
+
+	for endpoint, state := range testAgents {
+		state.Resources.Labels = map[string]string{"class": endpoint[:len(endpoint)-len(".net")]}
+		have[endpoint] = state
+	}
+
+	for name, algorithm := range map[string]algo.Algorithm{
+		"RandomChoice": algo.RandomChoice,
+		"RandomFit":    algo.RandomFit,
+		"LeastUsed":    algo.LeastUsed,
+		"Spread":       algo.Spread("class"),
+		"BestFit":      algo.BestFit,
+	} {
+		mapped, failed := algorithm(map[string]agent.ContainerConfig{"a": onWimpy, "b": onWimpy, "c": nowhere}, have, map[string]algo.PendingTask{})
+
+		if want, have := 2, len(mapped["wimpy.net"]); want != have {
+			t.Errorf("%s: want %d task(s) on wimpy.net, have %d", name, want, have)
+		}
+
+		if _, ok := failed["c"]; !ok || len(failed) != 1 {
+			t.Errorf("%s: want unsatisfiable task to fail, have failed %v", name, failed)
+		}
+	}
+
+	if algo.Constrained(onWimpy, have) {
+		t.Error("constraint satisfied by wimpy.net reported as unsatisfiable")
+	}
+
+	if !algo.Constrained(nowhere, have) {
+		t.Error("unsatisfiable constraint not reported")
+	}
+}
+
+func TestSpread(t *testing.T) {
+	spreadAgents := func(containers map[string][]string) map[string]agent.StateEvent {
+		have := map[string]agent.StateEvent{}
+
+		for endpoint, zone := range map[string]string{"a.net": "z1", "b.net": "z1", "c.net": "z2"} {
+			state := agent.StateEvent{
+				Resources: agent.HostResources{
+					Mem:    agent.TotalReservedInt{Total: 1024},
+					CPU:    agent.TotalReserved{Total: 4},
+					Labels: map[string]string{"zone": zone},
+				},
+				Containers: map[string]agent.ContainerInstance{},
+			}
+
+			for _, id := range containers[endpoint] {
+				state.Containers[id] = agent.ContainerInstance{ID: id, ContainerStatus: agent.ContainerStatusRunning}
+			}
+
+			have[endpoint] = state
+		}
+
+		return have
+	}
+
+	for i, input := range []struct {
+		label      string
+		containers map[string][]string // endpoint: ids
+		pending    map[string]algo.PendingTask
+		want       map[string]string // id: endpoint
+	}{
+		{
+			want: map[string]string{"j-1234567-0": "a.net", "j-1234567-1": "b.net", "j-1234567-2": "c.net"},
+		},
+		{
+			label: "zone",
+			want:  map[string]string{"j-1234567-0": "a.net", "j-1234567-1": "c.net"},
+		},
+		{
+			containers: map[string][]string{"a.net": {"j-1234567-0"}},
+			want:       map[string]string{"j-1234567-1": "b.net"},
+		},
+		{
+			label:      "zone",
+			containers: map[string][]string{"a.net": {"j-1234567-0"}},
+			want:       map[string]string{"j-1234567-1": "c.net"},
+		},
+		{
+			containers: map[string][]string{"b.net": {"k-1234567-0", "k-1234567-1"}},
+			want:       map[string]string{"j-1234567-0": "a.net", "j-1234567-1": "c.net"},
+		},
+		{
+			pending: map[string]algo.PendingTask{"j-1234567-0": algo.PendingTask{Schedule: true, Endpoint: "a.net"}},
+			want:    map[string]string{"j-1234567-1": "b.net"},
+		},
+		{
+			label:      "zone",
+			containers: map[string][]string{"a.net": {"j-1234567-0"}, "c.net": {"j-1234567-1"}},
+			want:       map[string]string{"j-1234567-2": "b.net"},
+		},
+	} {
+		want := map[string]agent.ContainerConfig{}
+		for id := range input.want {
+			want[id] = agent.ContainerConfig{Resources: agent.Resources{Mem: 64, CPU: 0.1}}
+		}
+
+		pending := input.pending
+		if pending == nil {
+			pending = map[string]algo.PendingTask{}
+		}
+
+		mapped, failed := algo.Spread(input.label)(want, spreadAgents(input.containers), pending)
+		if len(failed) > 0 {
+			t.Errorf("%d: failed to place %d task(s)", i, len(failed))
+		}
+
+		have := map[string]string{}
+		for endpoint, configs := range mapped {
+			for id := range configs {
+				have[id] = endpoint
+			}
+		}
+
+		if fmt.Sprint(input.want) != fmt.Sprint(have) {
+			t.Errorf("%d: want %v, have %v", i, input.want, have)
+		}
+	}
+}
+
+func TestBestFit(t *testing.T) {
+	agents := func(reserved map[string]uint64) map[string]agent.StateEvent {
+		have := map[string]agent.StateEvent{}
+
+		for endpoint, mem := range reserved {
+			have[endpoint] = agent.StateEvent{
+				Resources: agent.HostResources{
+					Mem: agent.TotalReservedInt{Total: 1024, Reserved: mem},
+					CPU: agent.TotalReserved{Total: 4},
+				},
+				Containers: map[string]agent.ContainerInstance{},
+			}
+		}
+
+		return have
+	}
+
+	for i, input := range []struct {
+		reserved map[string]uint64 // endpoint: reserved memory
+		want     map[string]uint64 // id: memory
+		placed   map[string]string // id: endpoint
+	}{
+		{
+			reserved: map[string]uint64{"a.net": 0, "b.net": 512},
+			want:     map[string]uint64{"x-1234567-0": 256},
+			placed:   map[string]string{"x-1234567-0": "b.net"},
+		},
+		{
+			// Random or least-used placement of the small tasks would leave
+			// no room for the large one.
+			reserved: map[string]uint64{"a.net": 0, "b.net": 0},
+			want:     map[string]uint64{"x-1234567-0": 512, "y-1234567-0": 512, "z-1234567-0": 1024},
+			placed:   map[string]string{"x-1234567-0": "b.net", "y-1234567-0": "b.net", "z-1234567-0": "a.net"},
+		},
+		{
+			reserved: map[string]uint64{"a.net": 768, "b.net": 0},
+			want:     map[string]uint64{"x-1234567-0": 512},
+			placed:   map[string]string{"x-1234567-0": "b.net"},
+		},
+	} {
+		want := map[string]agent.ContainerConfig{}
+		for id, mem := range input.want {
+			want[id] = agent.ContainerConfig{Resources: agent.Resources{Mem: mem}}
+		}
+
+		mapped, failed := algo.BestFit(want, agents(input.reserved), map[string]algo.PendingTask{})
+		if len(failed) > 0 {
+			t.Errorf("%d: failed to place %d task(s)", i, len(failed))
+		}
+
+		placed := map[string]string{}
+		for endpoint, configs := range mapped {
+			for id := range configs {
+				placed[id] = endpoint
+			}
+		}
+
+		if fmt.Sprint(input.placed) != fmt.Sprint(placed) {
+			t.Errorf("%d: want %v, have %v", i, input.placed, placed)
+		}
+	}
+}
+
+func TestScored(t *testing.T) {
+	var (
+		config = agent.ContainerConfig{ArtifactURL: "http://artifacts/web.tar.gz", Resources: agent.Resources{Mem: 64}}
+		have   = map[string]agent.StateEvent{
+			"a.net": agent.StateEvent{
+				Resources: agent.HostResources{Mem: agent.TotalReservedInt{Total: 1024, Reserved: 512}},
+				Containers: map[string]agent.ContainerInstance{
+					"db-1234567-0": agent.ContainerInstance{ContainerStatus: agent.ContainerStatusRunning},
+				},
+			},
+			"b.net": agent.StateEvent{
+				Resources: agent.HostResources{Mem: agent.TotalReservedInt{Total: 1024, Reserved: 64}},
+				Containers: map[string]agent.ContainerInstance{
+					"web-1234567-0": agent.ContainerInstance{ContainerStatus: agent.ContainerStatusRunning, ContainerConfig: config},
+				},
+			},
+		}
+	)
+
+	for i, input := range []struct {
+		scorers []algo.Weighted
+		want    string
+	}{
+		{[]algo.Weighted{{Scorer: algo.FitScorer, Weight: 1}}, "a.net"},
+		{[]algo.Weighted{{Scorer: algo.LocalityScorer, Weight: 1}}, "b.net"},
+		{[]algo.Weighted{{Scorer: algo.SpreadScorer(""), Weight: 1}}, "a.net"},
+		{[]algo.Weighted{{Scorer: algo.FitScorer, Weight: 1}, {Scorer: algo.LocalityScorer, Weight: 2}}, "b.net"},
+		{[]algo.Weighted{{Scorer: algo.SpreadScorer(""), Weight: 1}, {Scorer: algo.LocalityScorer, Weight: 2}}, "b.net"},
+		{[]algo.Weighted{{Scorer: algo.FitScorer, Weight: -10}, {Scorer: algo.LocalityScorer, Weight: -10}}, "a.net"}, // all scores below -1
+	} {
+		mapped, _ := algo.Scored(input.scorers...)(map[string]agent.ContainerConfig{"web-1234567-1": config}, have, map[string]algo.PendingTask{})
+
+		if _, ok := mapped[input.want]["web-1234567-1"]; !ok {
+			t.Errorf("%d: want task on %s, have %v", i, input.want, mapped)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package algo
+
+import (
+	"math"
+	"sort"
+
+	"github.com/soundcloud/harpoon/harpoon-agent/lib"
+)
+
+// Domain is the state of the scheduling domain, as scoring algorithms see it
+// while placing tasks. It includes the existing containers, the pending
+// tasks, and the tasks placed so far.
+type Domain struct {
+	Resources  map[string]agent.HostResources // endpoint: resources, including pending and placed tasks
+	Containers map[string]int                 // endpoint: container count
+	Tasks      map[string]map[string]int      // job: endpoint: task count
+	Artifacts  map[string]map[string]struct{} // endpoint: artifacts of its containers
+}
+
+func newDomain(have map[string]agent.StateEvent, pending map[string]PendingTask) Domain {
+	d := Domain{
+		Resources:  map[string]agent.HostResources{},
+		Containers: map[string]int{},
+		Tasks:      map[string]map[string]int{},
+		Artifacts:  map[string]map[string]struct{}{},
+	}
+
+	for endpoint, state := range have {
+		d.Resources[endpoint] = state.Resources
+	}
+
+	for endpoint, state := range have {
+		for id, instance := range state.Containers {
+			if instance.ContainerStatus == agent.ContainerStatusDeleted {
+				continue
+			}
+
+			d.add(id, endpoint, instance.ContainerConfig)
+		}
+	}
+
+	for id, task := range pending {
+		if !task.Schedule {
+			continue
+		}
+
+		d.reserve(task.Endpoint, task.ContainerConfig)
+
+		if _, ok := have[task.Endpoint].Containers[id]; !ok {
+			d.add(id, task.Endpoint, task.ContainerConfig)
+		}
+	}
+
+	return d
+}
+
+// place records a task placed on the endpoint.
+func (d Domain) place(id, endpoint string, config agent.ContainerConfig) {
+	d.reserve(endpoint, config)
+	d.add(id, endpoint, config)
+}
+
+func (d Domain) reserve(endpoint string, config agent.ContainerConfig) {
+	r := d.Resources[endpoint]
+	r.CPU.Reserved += config.CPU
+	r.Mem.Reserved += config.Mem
+	r.Storage.Reserved += config.TempSize()
+	d.Resources[endpoint] = r
+}
+
+func (d Domain) add(id, endpoint string, config agent.ContainerConfig) {
+	j := job(id)
+
+	if _, ok := d.Tasks[j]; !ok {
+		d.Tasks[j] = map[string]int{}
+	}
+
+	if _, ok := d.Artifacts[endpoint]; !ok {
+		d.Artifacts[endpoint] = map[string]struct{}{}
+	}
+
+	d.Tasks[j][endpoint]++
+	d.Containers[endpoint]++
+	d.Artifacts[endpoint][artifact(config)] = struct{}{}
+}
+
+// TasksIn returns the number of tasks of the job on agents whose label has
+// the given value. Agents without the label count as having an empty one.
+func (d Domain) TasksIn(job, label, value string) int {
+	n := 0
+
+	for endpoint, count := range d.Tasks[job] {
+		if d.Resources[endpoint].Labels[label] == value {
+			n += count
+		}
+	}
+
+	return n
+}
+
+// artifact identifies the artifact of a container: by its digest, if it
+// declares one, as the agent caches it.
+func artifact(config agent.ContainerConfig) string {
+	if config.ArtifactSHA256 != "" {
+		return config.ArtifactSHA256
+	}
+
+	return config.ArtifactURL
+}
+
+// Scorer rates placing a task on an agent, which the task fits on, between 0
+// (worst) and 1 (best).
+type Scorer func(id string, config agent.ContainerConfig, endpoint string, d Domain) float64
+
+// Weighted is a Scorer, and how much its score counts.
+type Weighted struct {
+	Scorer
+	Weight float64
+}
+
+// Scored returns a scheduling algorithm which places every task on the agent
+// with the highest weighted sum of scores, among those that meet the task's
+// constraints. Larger tasks (by memory, then CPU) are placed first, as
+// they're the hardest to fit. Ties go to the first agent by name.
+func Scored(scorers ...Weighted) Algorithm {
+	return func(
+		want map[string]agent.ContainerConfig,
+		have map[string]agent.StateEvent,
+		pending map[string]PendingTask,
+	) (
+		mapped map[string]map[string]agent.ContainerConfig,
+		failed map[string]agent.ContainerConfig,
+	) {
+		mapped = map[string]map[string]agent.ContainerConfig{}
+		failed = map[string]agent.ContainerConfig{}
+
+		var (
+			d   = newDomain(have, pending)
+			ids = make([]string, 0, len(want))
+		)
+
+		for id := range want {
+			ids = append(ids, id)
+		}
+		sort.Sort(largestFirst{ids: ids, want: want})
+
+		for _, id := range ids {
+			config := want[id]
+
+			// Find all candidates
+			valid := filter(d.Resources, config)
+			if len(valid) <= 0 {
+				failed[id] = config
+				continue
+			}
+			sort.Strings(valid)
+
+			// Select the best candidate
+			var (
+				chosen string
+				best   = math.Inf(-1)
+			)
+
+			for _, endpoint := range valid {
+				score := 0.0
+				for _, s := range scorers {
+					score += s.Weight * s.Scorer(id, config, endpoint, d)
+				}
+
+				if chosen == "" || score > best {
+					chosen, best = endpoint, score
+				}
+			}
+
+			// Place the container
+			target, ok := mapped[chosen]
+			if !ok {
+				target = map[string]agent.ContainerConfig{}
+			}
+			target[id] = config
+			mapped[chosen] = target
+
+			d.place(id, chosen, config)
+		}
+
+		return mapped, failed
+	}
+}
+
+// BestFit implements a bin-packing scheduling algorithm. Containers are
+// placed on the agent that meets their constraints, and is left with the
+// least room (see FitScorer). That keeps other agents free for large tasks.
+var BestFit = Scored(Weighted{Scorer: FitScorer, Weight: 1})
+
+// FitScorer is a dominant-resource best-fit Scorer. It scores an agent by the
+// share of its most utilized resource (memory, CPU or storage) once the task
+// is placed on it. The fuller an agent gets, the better.
+func FitScorer(id string, config agent.ContainerConfig, endpoint string, d Domain) float64 {
+	var (
+		r        = d.Resources[endpoint]
+		dominant = 0.0
+	)
+
+	for _, share := range []struct{ reserved, total float64 }{
+		{float64(r.Mem.Reserved + config.Mem), float64(r.Mem.Total)},
+		{r.CPU.Reserved + config.CPU, r.CPU.Total},
+		{r.Storage.Reserved + config.TempSize(), r.Storage.Total},
+	} {
+		if share.total <= 0 {
+			continue
+		}
+
+		if u := share.reserved / share.total; u > dominant {
+			dominant = u
+		}
+	}
+
+	if dominant > 1 {
+		dominant = 1
+	}
+
+	return dominant
+}
+
+// SpreadScorer returns a Scorer which prefers agents running fewer tasks of
+// the task's job. If label is set, agents whose value of the label (e.g. their
+// rack) has fewer tasks of the job are preferred just as much.
+func SpreadScorer(label string) Scorer {
+	return func(id string, config agent.ContainerConfig, endpoint string, d Domain) float64 {
+		var (
+			j     = job(id)
+			score = 1 / float64(1+d.Tasks[j][endpoint])
+		)
+
+		if label == "" {
+			return score
+		}
+
+		inDomain := d.TasksIn(j, label, d.Resources[endpoint].Labels[label])
+
+		return (score + 1/float64(1+inDomain)) / 2
+	}
+}
+
+// LocalityScorer prefers agents which already run containers with the task's
+// artifact, and therefore probably have it in their artifact cache.
+func LocalityScorer(id string, config agent.ContainerConfig, endpoint string, d Domain) float64 {
+	if _, ok := d.Artifacts[endpoint][artifact(config)]; ok {
+		return 1
+	}
+
+	return 0
+}
+
+// largestFirst orders task IDs by the memory, and then CPU, their tasks
+// want, largest first, and finally by ID.
+type largestFirst struct {
+	ids  []string
+	want map[string]agent.ContainerConfig
+}
+
+func (s largestFirst) Less(i, j int) bool {
+	a, b := s.want[s.ids[i]], s.want[s.ids[j]]
+
+	if a.Mem != b.Mem {
+		return a.Mem > b.Mem
+	}
+
+	if a.CPU != b.CPU {
+		return a.CPU > b.CPU
+	}
+
+	return s.ids[i] < s.ids[j]
+}
+
+func (s largestFirst) Len() int {
+	return len(s.ids)
+}
+
+func (s largestFirst) Swap(i, j int) {
+	s.ids[i], s.ids[j] = s.ids[j], s.ids[i]
+}
@@ -32,3 +32,38 @@ func (s leastUsed) Len() int {
 func (s leastUsed) Swap(i, j int) {
 	s.endpoints[i], s.endpoints[j] = s.endpoints[j], s.endpoints[i]
 }
+
+// spread orders endpoints by the number of tasks of a job in their domain
+// (e2d), then on them (e2j), then by their number of containers (e2c), and
+// finally by name.
+type spread struct {
+	e2d       map[string]int // endpoint to job's task count in its domain
+	e2j       map[string]int // endpoint to job's task count
+	e2c       map[string]int // endpoint to container's count
+	endpoints []string
+}
+
+func (s spread) sort(endpoints []string) {
+	s.endpoints = endpoints
+	sort.Sort(s)
+}
+
+func (s spread) Less(i, j int) bool {
+	a, b := s.endpoints[i], s.endpoints[j]
+
+	for _, m := range []map[string]int{s.e2d, s.e2j, s.e2c} {
+		if m[a] != m[b] {
+			return m[a] < m[b]
+		}
+	}
+
+	return a < b
+}
+
+func (s spread) Len() int {
+	return len(s.endpoints)
+}
+
+func (s spread) Swap(i, j int) {
+	s.endpoints[i], s.endpoints[j] = s.endpoints[j], s.endpoints[i]
+}
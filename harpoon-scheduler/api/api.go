@@ -9,6 +9,7 @@ import (
 
 	"github.com/soundcloud/harpoon/harpoon-agent/lib"
 	"github.com/soundcloud/harpoon/harpoon-configstore/lib"
+	"github.com/soundcloud/harpoon/harpoon-scheduler/registry"
 )
 
 const (
@@ -21,11 +22,20 @@ const (
 	// APIUnschedulePath for schedule calls.
 	APIUnschedulePath = "/unschedule"
 
+	// APIScalePath for scale calls.
+	APIScalePath = "/scale"
+
 	// APIProxyPath to get the actual state of the scheduling domain.
 	APIProxyPath = "/proxy"
 
 	// APIRegistryPath to get the desired state of the scheduling domain.
 	APIRegistryPath = "/registry"
+
+	// APIMigratePath for migrate calls.
+	APIMigratePath = "/migrate"
+
+	// APIMigrationsPath to get, resume, and roll back migrations.
+	APIMigrationsPath = "/migrations"
 )
 
 type handler struct {
@@ -39,12 +49,18 @@ type Proxy interface {
 	Snapshot() map[string]agent.StateEvent
 }
 
-// JobScheduler captures job schedule and unschedule methods, and a way to
-// introspect the desired state of the scheduling domain.
+// JobScheduler captures job schedule, unschedule, scale, and migrate methods,
+// and a way to introspect the desired state of the scheduling domain.
 type JobScheduler interface {
 	Schedule(configstore.JobConfig) error
 	Unschedule(jobConfigHash string) error
+	Scale(jobConfigHash string, scale int) error
 	Snapshot() map[string]configstore.JobConfig
+
+	Migrate(fromJobConfigHash string, to configstore.JobConfig, batchSize int, onFailure string) error
+	Migrations() map[string]registry.Migration
+	Resume(toJobConfigHash string) error
+	Rollback(toJobConfigHash string) error
 }
 
 // NewHandler returns a http.Handler that serves the API endpoints.
@@ -62,10 +78,18 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h.handleSchedule(w, r)
 	case r.Method == "PUT" && strings.HasPrefix(r.URL.Path, APIVersionPrefix+APIUnschedulePath):
 		h.handleUnschedule(w, r)
+	case r.Method == "PUT" && strings.HasPrefix(r.URL.Path, APIVersionPrefix+APIScalePath+"/"):
+		h.handleScale(w, r)
 	case r.Method == "GET" && r.URL.Path == APIVersionPrefix+APIProxyPath:
 		h.handleProxy(w, r)
 	case r.Method == "GET" && r.URL.Path == APIVersionPrefix+APIRegistryPath:
 		h.handleRegistry(w, r)
+	case r.Method == "PUT" && r.URL.Path == APIVersionPrefix+APIMigratePath:
+		h.handleMigrate(w, r)
+	case r.Method == "GET" && r.URL.Path == APIVersionPrefix+APIMigrationsPath:
+		h.handleMigrations(w, r)
+	case r.Method == "PUT" && strings.HasPrefix(r.URL.Path, APIVersionPrefix+APIMigrationsPath+"/"):
+		h.handleMigrationAction(w, r)
 	default:
 		http.NotFoundHandler().ServeHTTP(w, r)
 	}
@@ -110,7 +134,7 @@ func (h *handler) handleUnschedule(w http.ResponseWriter, r *http.Request) {
 		}
 
 		job = c.Job
-		hash = c.Hash()
+		hash = h.scheduledHash(c)
 	} else {
 		toks := strings.Split(r.URL.Path, "/")
 
@@ -126,6 +150,65 @@ func (h *handler) handleUnschedule(w http.ResponseWriter, r *http.Request) {
 	writeResponse(w, http.StatusAccepted, fmt.Sprintf("request to unschedule %s (%s) has been accepted", job, hash))
 }
 
+// scheduledHash returns the hash under which the passed job config is
+// scheduled. Scaled jobs stay scheduled under the hash of the config they
+// were scheduled with, so their current config is looked up by its hash, too.
+func (h *handler) scheduledHash(c configstore.JobConfig) string {
+	var (
+		hash      = c.Hash()
+		scheduled = h.JobScheduler.Snapshot()
+	)
+
+	if _, ok := scheduled[hash]; ok {
+		return hash
+	}
+
+	for scheduledHash, config := range scheduled {
+		if config.Hash() == hash {
+			return scheduledHash
+		}
+	}
+
+	return hash
+}
+
+func (h *handler) handleScale(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Scale int `json:"scale"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	hash := strings.TrimPrefix(r.URL.Path, APIVersionPrefix+APIScalePath+"/")
+	if hash == "" || strings.Contains(hash, "/") {
+		http.NotFoundHandler().ServeHTTP(w, r)
+		return
+	}
+
+	c, ok := h.JobScheduler.Snapshot()[hash]
+	if !ok {
+		writeResponse(w, http.StatusNotFound, fmt.Sprintf("%s not scheduled", hash))
+		return
+	}
+
+	c.Scale = req.Scale
+
+	if err := c.Valid(); err != nil {
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.JobScheduler.Scale(hash, req.Scale); err != nil {
+		writeResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeResponse(w, http.StatusAccepted, fmt.Sprintf("request to scale %s to %d has been accepted", hash, req.Scale))
+}
+
 func (h *handler) handleProxy(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	json.NewEncoder(w).Encode(h.Proxy.Snapshot())
@@ -136,6 +219,81 @@ func (h *handler) handleRegistry(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(h.JobScheduler.Snapshot())
 }
 
+func (h *handler) handleMigrate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		From      string                `json:"from"`
+		To        configstore.JobConfig `json:"to"`
+		BatchSize int                   `json:"batch_size"`
+		OnFailure string                `json:"on_failure"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := req.To.Valid(); err != nil {
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.From == "" {
+		writeResponse(w, http.StatusBadRequest, `"from" not set`)
+		return
+	}
+
+	if req.BatchSize == 0 {
+		req.BatchSize = 1
+	}
+
+	if req.OnFailure == "" {
+		req.OnFailure = registry.OnFailurePause
+	}
+
+	if err := h.JobScheduler.Migrate(req.From, req.To, req.BatchSize, req.OnFailure); err != nil {
+		writeResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeResponse(w, http.StatusAccepted, fmt.Sprintf("request to migrate %s to %q (%s) has been accepted", req.From, req.To.Job, req.To.Hash()))
+}
+
+func (h *handler) handleMigrations(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(h.JobScheduler.Migrations())
+}
+
+func (h *handler) handleMigrationAction(w http.ResponseWriter, r *http.Request) {
+	toks := strings.Split(strings.TrimPrefix(r.URL.Path, APIVersionPrefix+APIMigrationsPath+"/"), "/")
+	if len(toks) != 2 {
+		http.NotFoundHandler().ServeHTTP(w, r)
+		return
+	}
+
+	var (
+		hash   = toks[0]
+		action = toks[1]
+		err    error
+	)
+
+	switch action {
+	case "resume":
+		err = h.JobScheduler.Resume(hash)
+	case "rollback":
+		err = h.JobScheduler.Rollback(hash)
+	default:
+		http.NotFoundHandler().ServeHTTP(w, r)
+		return
+	}
+
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeResponse(w, http.StatusAccepted, fmt.Sprintf("request to %s migration to %s has been accepted", action, hash))
+}
+
 func writeResponse(w http.ResponseWriter, code int, msg string) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.WriteHeader(code)
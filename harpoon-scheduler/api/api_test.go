@@ -1,15 +1,20 @@
 package api_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/soundcloud/harpoon/harpoon-scheduler/api"
 
 	"github.com/soundcloud/harpoon/harpoon-agent/lib"
 	"github.com/soundcloud/harpoon/harpoon-configstore/lib"
+	"github.com/soundcloud/harpoon/harpoon-scheduler/registry"
 )
 
 // https://github.com/soundcloud/harpoon/pull/107
@@ -39,6 +44,162 @@ func TestUnscheduleFailed(t *testing.T) {
 	}
 }
 
+func TestUnscheduleScaled(t *testing.T) {
+	var (
+		r = registry.New("")
+		h = api.NewHandler(fakeProxy{}, r)
+		c = testJobConfig
+	)
+	defer r.Quit()
+
+	if err := r.Schedule(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Scale(c.Hash(), 3); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Scale = 3 // the job's current config
+
+	body, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("PUT", "http://cats.biz"+api.APIVersionPrefix+api.APIUnschedulePath, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.ServeHTTP(w, req)
+
+	if want, have := http.StatusAccepted, w.Code; want != have {
+		t.Errorf("want HTTP %d, have %d (%s)", want, have, strings.TrimSpace(w.Body.String()))
+	}
+
+	if want, have := 0, len(r.Snapshot()); want != have {
+		t.Errorf("want %d scheduled job(s), have %d", want, have)
+	}
+}
+
+func TestScale(t *testing.T) {
+	var (
+		r = registry.New("")
+		h = api.NewHandler(fakeProxy{}, r)
+		c = testJobConfig
+	)
+	defer r.Quit()
+
+	if err := r.Schedule(c); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, input := range []struct {
+		hash string
+		body string
+		want int
+	}{
+		{c.Hash(), `{"scale":6}`, http.StatusAccepted},
+		{c.Hash(), `{"scale":0}`, http.StatusBadRequest},
+		{c.Hash(), `{"scale":-1}`, http.StatusBadRequest},
+		{c.Hash(), `{"scale":`, http.StatusBadRequest},
+		{"b-1234567", `{"scale":6}`, http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("PUT", "http://cats.biz"+api.APIVersionPrefix+api.APIScalePath+"/"+input.hash, strings.NewReader(input.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h.ServeHTTP(w, req)
+
+		if want, have := input.want, w.Code; want != have {
+			t.Errorf("%d: want HTTP %d, have %d (%s)", i, want, have, strings.TrimSpace(w.Body.String()))
+		}
+	}
+
+	if want, have := 6, r.Snapshot()[c.Hash()].Scale; want != have {
+		t.Errorf("want scale %d, have %d", want, have)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	var (
+		p = fakeProxy{}
+		s = &fakeJobScheduler{}
+		h = api.NewHandler(p, s)
+	)
+
+	valid := configstore.JobConfig{
+		Job:         "a",
+		Environment: "prod",
+		Product:     "p",
+		Scale:       2,
+		ContainerConfig: agent.ContainerConfig{
+			ArtifactURL: "http://a.tar.gz",
+			Command:     agent.Command{WorkingDir: "/", Exec: []string{"./a"}},
+			Resources:   agent.Resources{Mem: 32, CPU: 0.1},
+			Grace:       agent.Grace{Startup: agent.JSONDuration{Duration: time.Second}, Shutdown: agent.JSONDuration{Duration: time.Second}},
+			Restart:     agent.Restart{Policy: agent.NoRestart},
+		},
+	}
+
+	for i, input := range []struct {
+		from string
+		to   configstore.JobConfig
+		want int
+	}{
+		{"a-1234567", valid, http.StatusAccepted},
+		{"", valid, http.StatusBadRequest},
+		{"a-1234567", configstore.JobConfig{Job: "a"}, http.StatusBadRequest},
+	} {
+		body, err := json.Marshal(map[string]interface{}{"from": input.from, "to": input.to})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		r, err := http.NewRequest("PUT", "http://cats.biz"+api.APIVersionPrefix+api.APIMigratePath, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h.ServeHTTP(w, r)
+
+		if want, have := input.want, w.Code; want != have {
+			t.Errorf("%d: want HTTP %d, have %d (%s)", i, want, have, strings.TrimSpace(w.Body.String()))
+		}
+	}
+
+	if want, have := int32(1), atomic.LoadInt32(&s.migrations); want != have {
+		t.Errorf("want %d migration(s), have %d", want, have)
+	}
+
+	if want, have := 1, s.batchSize; want != have {
+		t.Errorf("want default batch size %d, have %d", want, have)
+	}
+
+	if want, have := registry.OnFailurePause, s.onFailure; want != have {
+		t.Errorf("want default failure policy %q, have %q", want, have)
+	}
+}
+
+var testJobConfig = configstore.JobConfig{
+	Job:         "a",
+	Environment: "prod",
+	Product:     "p",
+	Scale:       2,
+	ContainerConfig: agent.ContainerConfig{
+		ArtifactURL: "http://a.tar.gz",
+		Command:     agent.Command{WorkingDir: "/", Exec: []string{"./a"}},
+		Resources:   agent.Resources{Mem: 32, CPU: 0.1},
+		Grace:       agent.Grace{Startup: agent.JSONDuration{Duration: time.Second}, Shutdown: agent.JSONDuration{Duration: time.Second}},
+		Restart:     agent.Restart{Policy: agent.NoRestart},
+	},
+}
+
 type fakeProxy map[string]agent.StateEvent
 
 func (p fakeProxy) Snapshot() map[string]agent.StateEvent {
@@ -49,6 +210,9 @@ type fakeJobScheduler struct {
 	schedules   int32
 	unschedules int32
 	snapshots   int32
+	migrations  int32
+	batchSize   int
+	onFailure   string
 }
 
 func (s *fakeJobScheduler) Schedule(configstore.JobConfig) error {
@@ -61,7 +225,29 @@ func (s *fakeJobScheduler) Unschedule(jobConfigHash string) error {
 	return nil
 }
 
+func (s *fakeJobScheduler) Scale(jobConfigHash string, scale int) error {
+	return nil
+}
+
 func (s fakeJobScheduler) Snapshot() map[string]configstore.JobConfig {
 	atomic.AddInt32(&s.snapshots, 1)
 	return map[string]configstore.JobConfig{}
 }
+
+func (s *fakeJobScheduler) Migrate(from string, to configstore.JobConfig, batchSize int, onFailure string) error {
+	atomic.AddInt32(&s.migrations, 1)
+	s.batchSize, s.onFailure = batchSize, onFailure
+	return nil
+}
+
+func (s *fakeJobScheduler) Migrations() map[string]registry.Migration {
+	return map[string]registry.Migration{}
+}
+
+func (s *fakeJobScheduler) Resume(to string) error {
+	return nil
+}
+
+func (s *fakeJobScheduler) Rollback(to string) error {
+	return nil
+}
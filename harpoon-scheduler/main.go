@@ -13,6 +13,7 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/soundcloud/harpoon/harpoon-scheduler/agentrepr"
+	"github.com/soundcloud/harpoon/harpoon-scheduler/algo"
 	"github.com/soundcloud/harpoon/harpoon-scheduler/api"
 	"github.com/soundcloud/harpoon/harpoon-scheduler/registry"
 	"github.com/soundcloud/harpoon/harpoon-scheduler/reprproxy"
@@ -36,15 +37,56 @@ func main() {
 	log.SetFlags(log.Lmicroseconds | log.Lshortfile)
 
 	var (
-		debug   = flag.Bool("debug", false, "enable debug logging")
-		listen  = flag.String("listen", ":4444", "HTTP listen address")
-		version = flag.Bool("version", false, "print version")
-		persist = flag.String("persist", "scheduler-registry.json", "filename to persist registry state")
-		agents  = multiagent{}
+		debug          = flag.Bool("debug", false, "enable debug logging")
+		listen         = flag.String("listen", ":4444", "HTTP listen address")
+		version        = flag.Bool("version", false, "print version")
+		persist        = flag.String("persist", "scheduler-registry.json", "filename to persist registry state")
+		algorithm      = flag.String("algorithm", "random-fit", "scheduling algorithm: random-fit, least-used, spread, best-fit or scored")
+		spreadLabel    = flag.String("spread.label", "", "agent label (e.g. rack or zone) to spread tasks of a job over, for -algorithm=spread or scored")
+		fitWeight      = flag.Float64("weight.fit", 1, "weight of the best-fit score, for -algorithm=scored")
+		spreadWeight   = flag.Float64("weight.spread", 0, "weight of the spread score, for -algorithm=scored")
+		localityWeight = flag.Float64("weight.locality", 0, "weight of the artifact locality score, for -algorithm=scored")
+		agents         = multiagent{}
 	)
 	flag.Var(&agents, "agent", "repeatable list of agent endpoints")
+	flag.DurationVar(&xf.MigrationTimeout, "migrate.timeout", xf.MigrationTimeout, "how long a migration batch may take to come up before it fails")
+	flag.DurationVar(&xf.UnhealthyWindow, "unhealthy.window", xf.UnhealthyWindow, "how long a container may be unhealthy before it's replaced")
+	flag.IntVar(&xf.DrainConcurrency, "drain.concurrency", xf.DrainConcurrency, "how many tasks may be migrated off each draining agent at a time")
 	flag.Parse()
 
+	if xf.DrainConcurrency <= 0 {
+		log.Fatal("-drain.concurrency must be positive")
+	}
+
+	for name, weight := range map[string]float64{
+		"-weight.fit":      *fitWeight,
+		"-weight.spread":   *spreadWeight,
+		"-weight.locality": *localityWeight,
+	} {
+		if weight < 0 {
+			log.Fatalf("%s must not be negative", name)
+		}
+	}
+
+	switch *algorithm {
+	case "random-fit":
+		xf.Algorithm = algo.RandomFit
+	case "least-used":
+		xf.Algorithm = algo.LeastUsed
+	case "spread":
+		xf.Algorithm = algo.Spread(*spreadLabel)
+	case "best-fit":
+		xf.Algorithm = algo.BestFit
+	case "scored":
+		xf.Algorithm = algo.Scored(
+			algo.Weighted{Scorer: algo.FitScorer, Weight: *fitWeight},
+			algo.Weighted{Scorer: algo.SpreadScorer(*spreadLabel), Weight: *spreadWeight},
+			algo.Weighted{Scorer: algo.LocalityScorer, Weight: *localityWeight},
+		)
+	default:
+		log.Fatalf("unknown -algorithm %q", *algorithm)
+	}
+
 	if *version {
 		fmt.Printf("version %s (%s) %s\n", Version, CommitID, ExternalReleaseVersion)
 		os.Exit(0)
@@ -66,6 +108,7 @@ func main() {
 	)
 
 	go xf.Transform(r, p, p)
+	go xf.Migrate(r, p)
 
 	http.Handle("/metrics", api.Log(w, prometheus.Handler()))
 	http.Handle("/api/v0/", api.Log(w, api.NewHandler(p, r)))
@@ -16,6 +16,10 @@ import (
 var (
 	expvarJobScheduleRequests         = expvar.NewInt("job_schedule_requests")
 	expvarJobUnscheduleRequests       = expvar.NewInt("job_unschedule_requests")
+	expvarJobScaleRequests            = expvar.NewInt("job_scale_requests")
+	expvarJobMigrateRequests          = expvar.NewInt("job_migrate_requests")
+	expvarMigrationBatchesCompleted   = expvar.NewInt("migration_batches_completed")
+	expvarMigrationBatchesFailed      = expvar.NewInt("migration_batches_failed")
 	expvarTransformsExecuted          = expvar.NewInt("transforms_executed")
 	expvarTransformsSkipped           = expvar.NewInt("transforms_skipped")
 	expvarTransactionsCreated         = expvar.NewInt("transactions_created")
@@ -24,10 +28,12 @@ var (
 	expvarContainersRequested         = expvar.NewInt("containers_requested")
 	expvarContainersPlaced            = expvar.NewInt("containers_placed")
 	expvarContainersFailed            = expvar.NewInt("containers_failed")
+	expvarContainersConstrained       = expvar.NewInt("containers_constrained")
 	expvarAgentsLost                  = expvar.NewInt("agents_lost")
 	expvarAgentConnectionsEstablished = expvar.NewInt("agent_connections_established")
 	expvarAgentConnectionsInterrupted = expvar.NewInt("agent_connections_interrupted")
 	expvarContainerEventsReceived     = expvar.NewInt("container_events_received")
+	expvarContainersCrashLooping      = expvar.NewInt("containers_crash_looping")
 )
 
 var (
@@ -43,6 +49,30 @@ var (
 		Name:      "job_unschedule_requests",
 		Help:      "Number of job unschedule requests received by the scheduler.",
 	})
+	prometheusJobScaleRequests = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "harpoon",
+		Subsystem: "scheduler",
+		Name:      "job_scale_requests",
+		Help:      "Number of job scale requests received by the scheduler.",
+	})
+	prometheusJobMigrateRequests = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "harpoon",
+		Subsystem: "scheduler",
+		Name:      "job_migrate_requests",
+		Help:      "Number of job migrate requests received by the scheduler.",
+	})
+	prometheusMigrationBatchesCompleted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "harpoon",
+		Subsystem: "scheduler",
+		Name:      "migration_batches_completed",
+		Help:      "Number of migration batches whose new tasks came up successfully.",
+	})
+	prometheusMigrationBatchesFailed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "harpoon",
+		Subsystem: "scheduler",
+		Name:      "migration_batches_failed",
+		Help:      "Number of migration batches whose new tasks failed or timed out.",
+	})
 	prometheusTransformsExecuted = prometheus.NewCounter(prometheus.CounterOpts{
 		Namespace: "harpoon",
 		Subsystem: "scheduler",
@@ -91,6 +121,12 @@ var (
 		Name:      "containers_failed",
 		Help:      "Number of containers failed to be placed by a scheduling algorithm.",
 	})
+	prometheusContainersConstrained = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "harpoon",
+		Subsystem: "scheduler",
+		Name:      "containers_constrained",
+		Help:      "Number of containers which couldn't be placed, because no agent satisfies their constraints.",
+	})
 	prometheusAgentsLost = prometheus.NewCounter(prometheus.CounterOpts{
 		Namespace: "harpoon",
 		Subsystem: "scheduler",
@@ -115,6 +151,12 @@ var (
 		Name:      "container_events_received",
 		Help:      "Number of complete events received from remote agents.",
 	})
+	prometheusContainersCrashLooping = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "harpoon",
+		Subsystem: "scheduler",
+		Name:      "containers_crash_looping",
+		Help:      "Number of containers reported by agents as crash-looping, and given up on.",
+	})
 )
 
 // IncJobScheduleRequests increments the number of requests to schedule new
@@ -131,6 +173,34 @@ func IncJobUnscheduleRequests(n int) {
 	prometheusJobUnscheduleRequests.Add(float64(n))
 }
 
+// IncJobScaleRequests increments the number of requests to change the scale
+// of a job.
+func IncJobScaleRequests(n int) {
+	expvarJobScaleRequests.Add(int64(n))
+	prometheusJobScaleRequests.Add(float64(n))
+}
+
+// IncJobMigrateRequests increments the number of requests to migrate a job
+// to a new job config.
+func IncJobMigrateRequests(n int) {
+	expvarJobMigrateRequests.Add(int64(n))
+	prometheusJobMigrateRequests.Add(float64(n))
+}
+
+// IncMigrationBatchesCompleted increments the number of migration batches
+// that completed successfully.
+func IncMigrationBatchesCompleted(n int) {
+	expvarMigrationBatchesCompleted.Add(int64(n))
+	prometheusMigrationBatchesCompleted.Add(float64(n))
+}
+
+// IncMigrationBatchesFailed increments the number of migration batches that
+// failed, either because a new task failed, or because they timed out.
+func IncMigrationBatchesFailed(n int) {
+	expvarMigrationBatchesFailed.Add(int64(n))
+	prometheusMigrationBatchesFailed.Add(float64(n))
+}
+
 // IncTransformsExecuted increments the number of transforms executed.
 func IncTransformsExecuted(n int) {
 	expvarTransformsExecuted.Add(int64(n))
@@ -184,6 +254,13 @@ func IncContainersFailed(n int) {
 	prometheusContainersFailed.Add(float64(n))
 }
 
+// IncContainersConstrained increments the number of containers that weren't
+// able to be placed, because no agent satisfies their constraints.
+func IncContainersConstrained(n int) {
+	expvarContainersConstrained.Add(int64(n))
+	prometheusContainersConstrained.Add(float64(n))
+}
+
 // IncAgentsLost increments the number of times the scheduler has lost
 // communication with an agent for long enough to consider its containers
 // abandoned.
@@ -213,3 +290,10 @@ func IncContainerEventsReceived(n int) {
 	expvarContainerEventsReceived.Add(int64(n))
 	prometheusContainerEventsReceived.Add(float64(n))
 }
+
+// IncContainersCrashLooping increments the number of containers which agents
+// stopped restarting, because they exited too often.
+func IncContainersCrashLooping(n int) {
+	expvarContainersCrashLooping.Add(int64(n))
+	prometheusContainersCrashLooping.Add(float64(n))
+}
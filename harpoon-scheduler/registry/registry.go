@@ -8,41 +8,96 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/soundcloud/harpoon/harpoon-configstore/lib"
 	"github.com/soundcloud/harpoon/harpoon-scheduler/metrics"
+	"github.com/soundcloud/harpoon/harpoon-scheduler/xtime"
 )
 
 // Registry accepts job schedule and unschedule requests, and persists them to
 // storage. It also broadcasts all updates to any subscribers who care to
 // listen.
 type Registry struct {
-	subc      chan chan<- map[string]configstore.JobConfig
-	unsubc    chan chan<- map[string]configstore.JobConfig
-	schedc    chan scheduleRequest
-	unschedc  chan unscheduleRequest
-	snapshotc chan map[string]configstore.JobConfig
-	quitc     chan chan struct{}
+	subc        chan chan<- map[string]configstore.JobConfig
+	unsubc      chan chan<- map[string]configstore.JobConfig
+	schedc      chan scheduleRequest
+	unschedc    chan unscheduleRequest
+	scalec      chan scaleRequest
+	migratec    chan migrateRequest
+	migrationc  chan migrationRequest
+	snapshotc   chan map[string]configstore.JobConfig
+	migrationsc chan map[string]Migration
+	quitc       chan chan struct{}
+}
+
+// Migration steps the instances of a scheduled job (from) over to a new job
+// (to) in batches. Both jobs are scheduled for the duration of the migration,
+// but their effective scales are derived from the migration's progress.
+type Migration struct {
+	From      string    `json:"from"`       // hash of the old job
+	To        string    `json:"to"`         // hash of the new job
+	FromScale int       `json:"from_scale"` // scale of the old job
+	ToScale   int       `json:"to_scale"`   // scale of the new job
+	BatchSize int       `json:"batch_size"`
+	OnFailure string    `json:"on_failure"` // pause, rollback
+	Step      int       `json:"step"`       // number of completed batches
+	Started   time.Time `json:"started"`    // when the current batch started
+	Paused    bool      `json:"paused"`
+	Reason    string    `json:"reason,omitempty"` // why the migration was paused
+}
+
+const (
+	// OnFailurePause stops a migration with a failed batch where it is,
+	// until it's resumed or rolled back.
+	OnFailurePause = "pause"
+
+	// OnFailureRollback rolls back a migration with a failed batch, i.e.
+	// restores the old job to its full scale, and unschedules the new job.
+	OnFailureRollback = "rollback"
+)
+
+// Scales returns the effective scales of the old and new job. The new job
+// includes the batch currently in flight. The old job only loses instances
+// for the batches that completed.
+func (m Migration) Scales() (from, to int) {
+	migrated := m.Step * m.BatchSize
+
+	from = m.FromScale - migrated
+	if from < 0 {
+		from = 0
+	}
+
+	to = migrated + m.BatchSize
+	if to > m.ToScale {
+		to = m.ToScale
+	}
+
+	return from, to
 }
 
 // New constructs a new Registry. It will restore state from the passed
 // filename, if it exists, and persist all mutations there.
 func New(filename string) *Registry {
-	scheduled, err := load(filename)
+	scheduled, migrations, err := load(filename)
 	if err != nil {
 		panic(err)
 	}
 
 	r := &Registry{
-		subc:      make(chan chan<- map[string]configstore.JobConfig),
-		unsubc:    make(chan chan<- map[string]configstore.JobConfig),
-		schedc:    make(chan scheduleRequest),
-		unschedc:  make(chan unscheduleRequest),
-		snapshotc: make(chan map[string]configstore.JobConfig),
-		quitc:     make(chan chan struct{}),
+		subc:        make(chan chan<- map[string]configstore.JobConfig),
+		unsubc:      make(chan chan<- map[string]configstore.JobConfig),
+		schedc:      make(chan scheduleRequest),
+		unschedc:    make(chan unscheduleRequest),
+		scalec:      make(chan scaleRequest),
+		migratec:    make(chan migrateRequest),
+		migrationc:  make(chan migrationRequest),
+		snapshotc:   make(chan map[string]configstore.JobConfig),
+		migrationsc: make(chan map[string]Migration),
+		quitc:       make(chan chan struct{}),
 	}
 
-	go r.loop(filename, scheduled)
+	go r.loop(filename, scheduled, migrations)
 
 	return r
 }
@@ -77,11 +132,83 @@ func (r *Registry) Unschedule(jobConfigHash string) error {
 	return <-req.err
 }
 
-// Snapshot implements api.JobScheduler.
+// Scale implements api.JobScheduler. It changes the scale of a scheduled job
+// in place. The job keeps the hash it was scheduled with, so its existing
+// tasks are left alone: only trailing tasks are added or removed.
+func (r *Registry) Scale(jobConfigHash string, scale int) error {
+	req := scaleRequest{
+		hash:  jobConfigHash,
+		scale: scale,
+		err:   make(chan error),
+	}
+	r.scalec <- req
+	return <-req.err
+}
+
+// Snapshot implements api.JobScheduler. Jobs which are part of a migration
+// are returned with their effective scale.
 func (r *Registry) Snapshot() map[string]configstore.JobConfig {
 	return <-r.snapshotc
 }
 
+// Migrate implements api.JobScheduler. It schedules the new job, and starts
+// stepping the instances of the scheduled job with the passed hash over to
+// it, batchSize instances at a time.
+func (r *Registry) Migrate(from string, to configstore.JobConfig, batchSize int, onFailure string) error {
+	req := migrateRequest{
+		from:      from,
+		JobConfig: to,
+		batchSize: batchSize,
+		onFailure: onFailure,
+		err:       make(chan error),
+	}
+	r.migratec <- req
+	return <-req.err
+}
+
+// Migrations implements api.JobScheduler. Migrations are keyed by the hash
+// of their new job.
+func (r *Registry) Migrations() map[string]Migration {
+	return <-r.migrationsc
+}
+
+// Advance completes the current batch of a migration, and starts the next
+// one. After the last batch, the old job is unscheduled. Step must match the
+// current step of the migration, so that stale verdicts are rejected.
+func (r *Registry) Advance(to string, step int) error {
+	return r.migration(migrationAdvance, to, step, "")
+}
+
+// Fail reports the current batch of a migration as failed, for the passed
+// reason. Depending on the migration, it's either paused or rolled back.
+func (r *Registry) Fail(to string, step int, reason string) error {
+	return r.migration(migrationFail, to, step, reason)
+}
+
+// Resume implements api.JobScheduler. It continues a paused migration with
+// its current batch.
+func (r *Registry) Resume(to string) error {
+	return r.migration(migrationResume, to, -1, "")
+}
+
+// Rollback implements api.JobScheduler. It stops a migration, restores the
+// old job to its full scale, and unschedules the new job.
+func (r *Registry) Rollback(to string) error {
+	return r.migration(migrationRollback, to, -1, "")
+}
+
+func (r *Registry) migration(action migrationAction, to string, step int, reason string) error {
+	req := migrationRequest{
+		action: action,
+		to:     to,
+		step:   step,
+		reason: reason,
+		err:    make(chan error),
+	}
+	r.migrationc <- req
+	return <-req.err
+}
+
 // Quit terminates the Registry.
 func (r *Registry) Quit() {
 	q := make(chan struct{})
@@ -89,7 +216,7 @@ func (r *Registry) Quit() {
 	<-q
 }
 
-func (r *Registry) loop(filename string, scheduled map[string]configstore.JobConfig) {
+func (r *Registry) loop(filename string, scheduled map[string]configstore.JobConfig, migrations map[string]Migration) {
 	var (
 		subs = map[chan<- map[string]configstore.JobConfig]struct{}{}
 	)
@@ -101,9 +228,35 @@ func (r *Registry) loop(filename string, scheduled map[string]configstore.JobCon
 			out[id] = spec
 		}
 
+		for _, m := range migrations {
+			from, to := out[m.From], out[m.To]
+			from.Scale, to.Scale = m.Scales()
+			out[m.From], out[m.To] = from, to
+		}
+
 		return out
 	}
 
+	cpMigrations := func() map[string]Migration {
+		out := make(map[string]Migration, len(migrations))
+
+		for to, m := range migrations {
+			out[to] = m
+		}
+
+		return out
+	}
+
+	migrating := func(hash string) bool {
+		for _, m := range migrations {
+			if m.From == hash || m.To == hash {
+				return true
+			}
+		}
+
+		return false
+	}
+
 	schedule := func(config configstore.JobConfig) error {
 		hash := config.Hash()
 
@@ -121,13 +274,140 @@ func (r *Registry) loop(filename string, scheduled map[string]configstore.JobCon
 			return fmt.Errorf("%s not scheduled", hash)
 		}
 
+		if migrating(hash) {
+			return fmt.Errorf("%s is being migrated", hash)
+		}
+
 		delete(scheduled, hash)
 
 		return nil
 	}
 
+	scale := func(hash string, n int) error {
+		config, ok := scheduled[hash]
+		if !ok {
+			return fmt.Errorf("%s not scheduled", hash)
+		}
+
+		if migrating(hash) {
+			return fmt.Errorf("%s is being migrated", hash)
+		}
+
+		config.Scale = n
+
+		if err := config.Valid(); err != nil {
+			return err
+		}
+
+		scheduled[hash] = config
+
+		return nil
+	}
+
+	migrate := func(req migrateRequest) error {
+		from, ok := scheduled[req.from]
+		if !ok {
+			return fmt.Errorf("%s not scheduled", req.from)
+		}
+
+		if migrating(req.from) {
+			return fmt.Errorf("%s is already being migrated", req.from)
+		}
+
+		if req.batchSize <= 0 {
+			return fmt.Errorf("invalid batch size %d", req.batchSize)
+		}
+
+		switch req.onFailure {
+		case OnFailurePause, OnFailureRollback:
+		default:
+			return fmt.Errorf("invalid failure policy %q", req.onFailure)
+		}
+
+		if err := schedule(req.JobConfig); err != nil {
+			return err
+		}
+
+		to := req.JobConfig.Hash()
+
+		migrations[to] = Migration{
+			From:      req.from,
+			To:        to,
+			FromScale: from.Scale,
+			ToScale:   req.JobConfig.Scale,
+			BatchSize: req.batchSize,
+			OnFailure: req.onFailure,
+			Started:   xtime.Now(),
+		}
+
+		return nil
+	}
+
+	rollback := func(m Migration) {
+		delete(migrations, m.To)
+		delete(scheduled, m.To)
+	}
+
+	migration := func(req migrationRequest) error {
+		m, ok := migrations[req.to]
+		if !ok {
+			return fmt.Errorf("%s not being migrated to", req.to)
+		}
+
+		if req.step >= 0 && req.step != m.Step {
+			return fmt.Errorf("%s is at step %d, not %d", req.to, m.Step, req.step)
+		}
+
+		switch req.action {
+		case migrationAdvance:
+			if m.Paused {
+				return fmt.Errorf("migration to %s is paused", req.to)
+			}
+
+			m.Step++
+			m.Started = xtime.Now()
+
+			if m.Step*m.BatchSize >= m.ToScale {
+				delete(migrations, m.To)
+				delete(scheduled, m.From) // done
+				return nil
+			}
+
+		case migrationFail:
+			if m.OnFailure == OnFailureRollback {
+				rollback(m)
+				return nil
+			}
+
+			m.Paused = true
+			m.Reason = req.reason
+
+		case migrationResume:
+			if !m.Paused {
+				return fmt.Errorf("migration to %s isn't paused", req.to)
+			}
+
+			m.Paused = false
+			m.Reason = ""
+			m.Started = xtime.Now()
+
+		case migrationRollback:
+			rollback(m)
+			return nil
+
+		default:
+			panic(fmt.Sprintf("unknown migration action %q", req.action))
+		}
+
+		migrations[m.To] = m
+
+		return nil
+	}
+
 	persist := func() {
-		if err := save(filename, scheduled); err != nil {
+		// Jobs and their migrations are saved together, so a crash never
+		// leaves jobs without their migration, which would scale them at once.
+		if err := save(filename, state{Scheduled: scheduled, Migrations: migrations}); err != nil {
 			panic(err) // TODO(pb): remove this before going live :)
 		}
 	}
@@ -170,8 +450,41 @@ func (r *Registry) loop(filename string, scheduled map[string]configstore.JobCon
 
 			req.err <- err
 
+		case req := <-r.scalec:
+			metrics.IncJobScaleRequests(1)
+
+			err := scale(req.hash, req.scale)
+			if err == nil {
+				persist()
+				broadcast()
+			}
+
+			req.err <- err
+
+		case req := <-r.migratec:
+			metrics.IncJobMigrateRequests(1)
+
+			err := migrate(req)
+			if err == nil {
+				persist()
+				broadcast()
+			}
+
+			req.err <- err
+
+		case req := <-r.migrationc:
+			err := migration(req)
+			if err == nil {
+				persist()
+				broadcast()
+			}
+
+			req.err <- err
+
 		case r.snapshotc <- cp():
 
+		case r.migrationsc <- cpMigrations():
+
 		case q := <-r.quitc:
 			close(q)
 			return
@@ -179,7 +492,7 @@ func (r *Registry) loop(filename string, scheduled map[string]configstore.JobCon
 	}
 }
 
-func save(filename string, scheduled map[string]configstore.JobConfig) error {
+func save(filename string, v interface{}) error {
 	if filename == "" {
 		return nil // no file (and no persistence) is OK
 	}
@@ -191,7 +504,7 @@ func save(filename string, scheduled map[string]configstore.JobConfig) error {
 		return err
 	}
 
-	if err := json.NewEncoder(f).Encode(scheduled); err != nil {
+	if err := json.NewEncoder(f).Encode(v); err != nil {
 		f.Close()
 		return err
 	}
@@ -206,24 +519,49 @@ func save(filename string, scheduled map[string]configstore.JobConfig) error {
 	return os.Rename(f.Name(), filename) // atomic
 }
 
-func load(filename string) (map[string]configstore.JobConfig, error) {
+// load restores the scheduled jobs and their migrations. Registries saved
+// before migrations were persisted contain only the scheduled jobs.
+func load(filename string) (map[string]configstore.JobConfig, map[string]Migration, error) {
+	var (
+		scheduled  = map[string]configstore.JobConfig{}
+		migrations = map[string]Migration{}
+	)
+
 	if _, err := os.Stat(filename); os.IsNotExist(err) {
-		return map[string]configstore.JobConfig{}, nil // no file is OK
+		return scheduled, migrations, nil // no file is OK
 	} else if err != nil {
-		return map[string]configstore.JobConfig{}, err
+		return scheduled, migrations, err
 	}
 
 	buf, err := ioutil.ReadFile(filename)
 	if err != nil {
-		return map[string]configstore.JobConfig{}, err
+		return scheduled, migrations, err
 	}
 
-	var scheduled map[string]configstore.JobConfig
-	if err := json.Unmarshal(buf, &scheduled); err != nil {
-		return map[string]configstore.JobConfig{}, err
+	var s state
+	if err := json.Unmarshal(buf, &s); err != nil {
+		return scheduled, migrations, err
 	}
 
-	return scheduled, nil
+	if s.Scheduled == nil {
+		if err := json.Unmarshal(buf, &scheduled); err != nil {
+			return map[string]configstore.JobConfig{}, migrations, err
+		}
+
+		return scheduled, migrations, nil
+	}
+
+	if s.Migrations == nil {
+		s.Migrations = migrations
+	}
+
+	return s.Scheduled, s.Migrations, nil
+}
+
+// state is what the registry persists.
+type state struct {
+	Scheduled  map[string]configstore.JobConfig `json:"scheduled"`
+	Migrations map[string]Migration             `json:"migrations"`
 }
 
 type scheduleRequest struct {
@@ -235,3 +573,34 @@ type unscheduleRequest struct {
 	hash string
 	err  chan error
 }
+
+type scaleRequest struct {
+	hash  string
+	scale int
+	err   chan error
+}
+
+type migrateRequest struct {
+	from string
+	configstore.JobConfig
+	batchSize int
+	onFailure string
+	err       chan error
+}
+
+type migrationAction string
+
+const (
+	migrationAdvance  migrationAction = "advance"
+	migrationFail                     = "fail"
+	migrationResume                   = "resume"
+	migrationRollback                 = "rollback"
+)
+
+type migrationRequest struct {
+	action migrationAction
+	to     string
+	step   int // -1 for any
+	reason string
+	err    chan error
+}
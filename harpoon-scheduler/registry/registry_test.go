@@ -6,7 +6,9 @@ import (
 	"os"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/soundcloud/harpoon/harpoon-agent/lib"
 	"github.com/soundcloud/harpoon/harpoon-configstore/lib"
 	"github.com/soundcloud/harpoon/harpoon-scheduler/registry"
 )
@@ -110,10 +112,13 @@ func TestRegistrySaveLoad(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	var fromDisk map[string]configstore.JobConfig
-	if err := json.Unmarshal(buf, &fromDisk); err != nil {
+	var state struct {
+		Scheduled map[string]configstore.JobConfig `json:"scheduled"`
+	}
+	if err := json.Unmarshal(buf, &state); err != nil {
 		t.Fatal(err)
 	}
+	fromDisk := state.Scheduled
 
 	check1c := make(chan map[string]configstore.JobConfig)
 	registry1.Subscribe(check1c)
@@ -139,3 +144,227 @@ func TestRegistrySaveLoad(t *testing.T) {
 		t.Fatalf("want %v, have %v", want, have)
 	}
 }
+
+func TestRegistryLoadJobsOnly(t *testing.T) {
+	var (
+		filename = "registry-test-load-jobs-only.json"
+		job      = configstore.JobConfig{Job: "π", Scale: 2}
+	)
+
+	defer os.Remove(filename)
+
+	// Registries used to persist only their scheduled jobs.
+
+	buf, err := json.Marshal(map[string]configstore.JobConfig{job.Hash(): job})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filename, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	registry := registry.New(filename)
+	defer registry.Quit()
+
+	checkScales(t, registry.Snapshot(), map[string]int{job.Hash(): 2})
+
+	if want, have := 0, len(registry.Migrations()); want != have {
+		t.Errorf("want %d migration(s), have %d", want, have)
+	}
+}
+
+func TestRegistryScale(t *testing.T) {
+	var (
+		registry = registry.New("")
+		job      = configstore.JobConfig{
+			Job:         "table",
+			Environment: "prod",
+			Product:     "furniture",
+			Scale:       4,
+			ContainerConfig: agent.ContainerConfig{
+				ArtifactURL: "http://table.tar.gz",
+				Command:     agent.Command{WorkingDir: "/", Exec: []string{"./table"}},
+				Resources:   agent.Resources{Mem: 32, CPU: 0.1},
+				Grace:       agent.Grace{Startup: agent.JSONDuration{Duration: time.Second}, Shutdown: agent.JSONDuration{Duration: time.Second}},
+				Restart:     agent.Restart{Policy: agent.NoRestart},
+			},
+		}
+	)
+
+	defer registry.Quit()
+
+	if err := registry.Schedule(job); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := registry.Scale(job.Hash(), 6); err != nil {
+		t.Fatal(err)
+	}
+
+	// The job keeps the hash it was scheduled with.
+
+	checkScales(t, registry.Snapshot(), map[string]int{job.Hash(): 6})
+
+	if err := registry.Scale(job.Hash(), 0); err == nil {
+		t.Errorf("want error when scaling to 0, have none")
+	}
+
+	if err := registry.Scale("nonexistent-1234567", 1); err == nil {
+		t.Errorf("want error when scaling an unscheduled job, have none")
+	}
+
+	if err := registry.Unschedule(job.Hash()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegistryMigrate(t *testing.T) {
+	var (
+		registry = registry.New("")
+		from     = configstore.JobConfig{Job: "table", Scale: 3}
+		to       = configstore.JobConfig{Job: "table", Scale: 3, Environment: "new"}
+	)
+
+	defer registry.Quit()
+
+	if err := registry.Schedule(from); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := registry.Migrate(from.Hash(), to, 2, "pause"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := registry.Unschedule(from.Hash()); err == nil {
+		t.Errorf("want error when unscheduling a job that's being migrated, have none")
+	}
+
+	checkScales(t, registry.Snapshot(), map[string]int{from.Hash(): 3, to.Hash(): 2})
+
+	if err := registry.Advance(to.Hash(), 1); err == nil {
+		t.Errorf("want error when advancing a stale step, have none")
+	}
+
+	if err := registry.Advance(to.Hash(), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	checkScales(t, registry.Snapshot(), map[string]int{from.Hash(): 1, to.Hash(): 3})
+
+	if err := registry.Fail(to.Hash(), 1, "it broke"); err != nil {
+		t.Fatal(err)
+	}
+
+	if m := registry.Migrations()[to.Hash()]; !m.Paused || m.Reason != "it broke" {
+		t.Fatalf("want paused migration, have %+v", m)
+	}
+
+	if err := registry.Advance(to.Hash(), 1); err == nil {
+		t.Errorf("want error when advancing a paused migration, have none")
+	}
+
+	if err := registry.Resume(to.Hash()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := registry.Advance(to.Hash(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// After the last batch, the old job is gone.
+
+	checkScales(t, registry.Snapshot(), map[string]int{to.Hash(): 3})
+
+	if want, have := 0, len(registry.Migrations()); want != have {
+		t.Errorf("want %d migration(s), have %d", want, have)
+	}
+}
+
+func TestRegistryMigrateRollback(t *testing.T) {
+	var (
+		registry = registry.New("")
+		from     = configstore.JobConfig{Job: "table", Scale: 4}
+		to       = configstore.JobConfig{Job: "table", Scale: 4, Environment: "new"}
+	)
+
+	defer registry.Quit()
+
+	if err := registry.Schedule(from); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := registry.Migrate(from.Hash(), to, 1, "rollback"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := registry.Advance(to.Hash(), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	checkScales(t, registry.Snapshot(), map[string]int{from.Hash(): 3, to.Hash(): 2})
+
+	if err := registry.Fail(to.Hash(), 1, "it broke"); err != nil {
+		t.Fatal(err)
+	}
+
+	checkScales(t, registry.Snapshot(), map[string]int{from.Hash(): 4})
+
+	if want, have := 0, len(registry.Migrations()); want != have {
+		t.Errorf("want %d migration(s), have %d", want, have)
+	}
+}
+
+func TestRegistryMigrationSaveLoad(t *testing.T) {
+	var (
+		filename  = "registry-test-migration-save-load.json"
+		registry1 = registry.New(filename)
+		from      = configstore.JobConfig{Job: "table", Scale: 2}
+		to        = configstore.JobConfig{Job: "table", Scale: 2, Environment: "new"}
+	)
+
+	defer os.Remove(filename)
+
+	defer registry1.Quit()
+
+	if err := registry1.Schedule(from); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := registry1.Migrate(from.Hash(), to, 1, "pause"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := registry1.Advance(to.Hash(), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restarted scheduler resumes the migration where it was.
+
+	registry2 := registry.New(filename)
+	defer registry2.Quit()
+
+	want, have := registry1.Migrations()[to.Hash()], registry2.Migrations()[to.Hash()]
+	if !want.Started.Equal(have.Started) {
+		t.Errorf("want batch started %s, have %s", want.Started, have.Started)
+	}
+
+	want.Started, have.Started = time.Time{}, time.Time{}
+	if !reflect.DeepEqual(want, have) {
+		t.Fatalf("want %v, have %v", want, have)
+	}
+
+	checkScales(t, registry2.Snapshot(), map[string]int{from.Hash(): 1, to.Hash(): 2})
+}
+
+func checkScales(t *testing.T, snapshot map[string]configstore.JobConfig, scales map[string]int) {
+	if want, have := len(scales), len(snapshot); want != have {
+		t.Fatalf("want %d job(s), have %d", want, have)
+	}
+
+	for hash, scale := range scales {
+		if want, have := scale, snapshot[hash].Scale; want != have {
+			t.Errorf("%s: want scale %d, have %d", hash, want, have)
+		}
+	}
+}
@@ -1,7 +1,29 @@
 package xf
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/soundcloud/harpoon/harpoon-agent/lib"
+	"github.com/soundcloud/harpoon/harpoon-configstore/lib"
+)
 
 func makeContainerID(jobConfigHash string, i int) string {
 	return fmt.Sprintf("%s-%d", jobConfigHash, i)
 }
+
+// makeContainerConfig returns the config of the job's tasks. They're labelled
+// with the job, so agents can attribute them, e.g. in their metrics.
+func makeContainerConfig(config configstore.JobConfig) agent.ContainerConfig {
+	c := config.ContainerConfig
+
+	c.Labels = make(map[string]string, len(config.Labels)+3)
+	for k, v := range config.Labels {
+		c.Labels[k] = v
+	}
+
+	c.Labels["job"] = config.Job
+	c.Labels["environment"] = config.Environment
+	c.Labels["product"] = config.Product
+
+	return c
+}
@@ -0,0 +1,179 @@
+package xf
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/soundcloud/harpoon/harpoon-agent/lib"
+	"github.com/soundcloud/harpoon/harpoon-configstore/lib"
+	"github.com/soundcloud/harpoon/harpoon-scheduler/metrics"
+	"github.com/soundcloud/harpoon/harpoon-scheduler/registry"
+	"github.com/soundcloud/harpoon/harpoon-scheduler/xtime"
+)
+
+// MigrationTimeout is how long we wait for the new tasks of a migration batch
+// to be running, and healthy if they have health checks, before we consider
+// the batch failed.
+var MigrationTimeout = 5 * time.Minute
+
+// MigrationRegistry is any component which holds migrations between jobs,
+// and accepts verdicts about their current batches.
+type MigrationRegistry interface {
+	DesireBroadcaster
+	Snapshot() map[string]configstore.JobConfig
+	Migrations() map[string]registry.Migration
+	Advance(to string, step int) error
+	Fail(to string, step int, reason string) error
+}
+
+// Migrate continuously monitors the migrations in the registry against the
+// actual state of the scheduling domain. It advances every migration whose
+// current batch is up, and fails those whose batch failed or timed out. It
+// never returns.
+func Migrate(r MigrationRegistry, actual ActualBroadcaster) {
+	var (
+		desirec = make(chan map[string]configstore.JobConfig)
+		actualc = make(chan map[string]agent.StateEvent)
+		have    = map[string]agent.StateEvent{}
+		tick    = time.Tick(tickInterval)
+	)
+
+	r.Subscribe(desirec)
+	defer r.Unsubscribe(desirec)
+
+	select {
+	case <-desirec:
+	case <-time.After(time.Millisecond):
+		panic("misbehaving desire broadcaster")
+	}
+
+	actual.Subscribe(actualc)
+	defer actual.Unsubscribe(actualc)
+
+	select {
+	case have = <-actualc:
+	case <-time.After(time.Millisecond):
+		panic("misbehaving actual broadcaster")
+	}
+
+	// Verdicts are issued to the registry, which broadcasts the resulting
+	// desired state to us. So, like the transform, migrations must be
+	// checked asynchronously, at most once at a time.
+	var (
+		semaphore  = make(chan bool, 1)
+		tryMigrate = func(have map[string]agent.StateEvent) {
+			select {
+			case semaphore <- true:
+				migrate(r, have)
+				<-semaphore
+
+			default:
+				Debugf("tryMigrate skipped")
+			}
+		}
+	)
+
+	for {
+		select {
+		case <-desirec:
+			go tryMigrate(have)
+
+		case have = <-actualc:
+			go tryMigrate(have)
+
+		case <-tick:
+			go tryMigrate(have)
+		}
+	}
+}
+
+// migrate checks the current batch of every running migration, and issues
+// the verdict to the registry.
+func migrate(r MigrationRegistry, have map[string]agent.StateEvent) {
+	var (
+		want       = r.Snapshot()
+		migrations = r.Migrations()
+	)
+
+	for to, m := range migrations {
+		if m.Paused {
+			continue
+		}
+
+		config, ok := want[to]
+		if !ok {
+			continue // completed or rolled back in the meantime
+		}
+
+		ready, err := checkBatch(m, config, have)
+		if err == nil && !ready && xtime.Now().Sub(m.Started) > MigrationTimeout {
+			err = fmt.Errorf("batch %d not up after %s", m.Step, MigrationTimeout)
+		}
+
+		switch {
+		case err != nil:
+			log.Printf("migration %s to %s: %s", m.From, to, err)
+			metrics.IncMigrationBatchesFailed(1)
+			if err := r.Fail(to, m.Step, err.Error()); err != nil {
+				log.Printf("migration %s to %s: fail: %s", m.From, to, err)
+			}
+
+		case ready:
+			Debugf("migration %s to %s: batch %d up", m.From, to, m.Step)
+			metrics.IncMigrationBatchesCompleted(1)
+			if err := r.Advance(to, m.Step); err != nil {
+				log.Printf("migration %s to %s: advance: %s", m.From, to, err)
+			}
+		}
+	}
+}
+
+// checkBatch returns true if every task of the new job that's wanted at the
+// current step of the migration is running, and healthy if the job has
+// health checks. It returns an error if any of those tasks failed.
+func checkBatch(m registry.Migration, config configstore.JobConfig, have map[string]agent.StateEvent) (bool, error) {
+	var (
+		instances = map[string][]agent.ContainerInstance{} // id: instances
+		_, scale  = m.Scales()
+		ready     = true
+	)
+
+	for _, state := range have {
+		for id, instance := range state.Containers {
+			instances[id] = append(instances[id], instance)
+		}
+	}
+
+	for i := 0; i < scale; i++ {
+		var (
+			id     = makeContainerID(m.To, i)
+			up     = false
+			failed agent.ContainerStatus
+		)
+
+		for _, instance := range instances[id] {
+			switch instance.ContainerStatus {
+			case agent.ContainerStatusRunning:
+				if len(config.HealthChecks) == 0 || instance.HealthStatus == agent.HealthStatusHealthy {
+					up = true
+				}
+
+			case agent.ContainerStatusFailed, agent.ContainerStatusFinished:
+				failed = instance.ContainerStatus
+			}
+		}
+
+		if up {
+			continue
+		}
+
+		if failed != "" {
+			return false, fmt.Errorf("task %s %s", id, failed)
+		}
+
+		ready = false
+	}
+
+	return ready, nil
+}
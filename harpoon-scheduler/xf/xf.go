@@ -5,6 +5,8 @@ package xf
 import (
 	"fmt"
 	"log"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/soundcloud/harpoon/harpoon-agent/lib"
@@ -23,9 +25,19 @@ var (
 	// we give up and repeat the command.
 	Tolerance = 1 * time.Minute
 
+	// UnhealthyWindow is how long a running container may continuously
+	// report itself unhealthy, before we schedule a replacement elsewhere and
+	// unschedule it.
+	UnhealthyWindow = 1 * time.Minute
+
+	// DrainConcurrency is how many tasks may be migrated off each draining
+	// agent at a time. Every task gets a replacement elsewhere, before its
+	// instance on the draining agent is unscheduled.
+	DrainConcurrency = 1
+
 	// Algorithm is the scheduling algorithm we'll use when placing new
 	// containers.
-	Algorithm = algo.RandomFit
+	Algorithm algo.Algorithm = algo.RandomFit
 
 	// tickInterval is how often the Transform will attempt to reconcile
 	// desired and actual states, absent a mutation event. Basically, every
@@ -36,6 +48,10 @@ var (
 // DesireBroadcaster emits the complete desired state of the scheduling domain
 // whenever any element in the domain changes. All broadcasters emit their
 // current state to every newly-subscribed channel as an initial message.
+//
+// Jobs are keyed by the hash of their config at the time they were
+// scheduled. Tasks are identified by that hash, so that changing the
+// effective scale of a job leaves its existing tasks alone.
 type DesireBroadcaster interface {
 	Subscribe(chan<- map[string]configstore.JobConfig)
 	Unsubscribe(chan<- map[string]configstore.JobConfig)
@@ -150,12 +166,22 @@ func transform(
 		toSchedule   = map[string]agent.ContainerConfig{}              // id: config
 		toStart      = map[string]map[string]agent.ContainerConfig{}   // endpoint: configs
 		toUnschedule = map[string][]string{}                           // endpoint: ids
+		toReplace    = map[string]replacement{}                        // id: replacement
+		draining     = map[string]bool{}                               // endpoint: draining
+		migrating    = map[string]int{}                                // endpoint: replacements pending
 	)
 
+	for endpoint, state := range have {
+		if state.Resources.Draining {
+			draining[endpoint] = true
+		}
+	}
+
 	// Expand every wanted Job to its composite tasks.
-	for _, config := range want {
+	for hash, config := range want {
+		c := makeContainerConfig(config)
 		for i := 0; i < config.Scale; i++ {
-			wantTasks[makeContainerID(config.Hash(), i)] = config.ContainerConfig
+			wantTasks[makeContainerID(hash, i)] = c
 		}
 	}
 
@@ -198,14 +224,29 @@ func transform(
 	}
 
 	for id, p := range pending {
-		if m, ok := haveTasks[id]; ok && p.Schedule && has(m,
+		if m, ok := haveTasks[id]; ok && p.Schedule && p.Replace {
+			// A replacement is only satisfied by the instance on its own
+			// endpoint. The unhealthy instance is probably still running.
+			if has(on(m, p.Endpoint),
+				agent.ContainerStatusRunning,
+				agent.ContainerStatusFinished,
+				agent.ContainerStatusFailed,
+			) {
+				Debugf("pending replacement %q successfully scheduled; delete from pending", id)
+				delete(pending, id) // successful replacement
+			} else if xtime.Now().After(p.Deadline) {
+				Debugf("pending replacement %q expired; delete from pending", id)
+				delete(pending, id) // timeout
+			}
+		} else if ok && p.Schedule && has(m,
 			agent.ContainerStatusRunning,
 			agent.ContainerStatusFinished,
 			agent.ContainerStatusFailed,
 		) {
 			Debugf("pending task %q successfully scheduled; delete from pending", id)
 			delete(pending, id) // successful schedule
-		} else if !p.Schedule && (!ok || !has(m,
+		} else if !p.Schedule && (!ok || !has(on(m, p.Endpoint),
+			agent.ContainerStatusPreparing,
 			agent.ContainerStatusCreated,
 			agent.ContainerStatusRunning,
 			agent.ContainerStatusFinished,
@@ -219,6 +260,23 @@ func transform(
 		}
 	}
 
+	// Count the tasks already being migrated off draining agents, and only
+	// migrate more while there are fewer than DrainConcurrency.
+	for _, p := range pending {
+		if p.Schedule && p.Replace && draining[p.Previous] {
+			migrating[p.Previous]++
+		}
+	}
+
+	migrate := func(endpoint string) bool {
+		if migrating[endpoint] >= DrainConcurrency {
+			return false
+		}
+
+		migrating[endpoint]++
+		return true
+	}
+
 	Debugf(
 		"before scan: want %d task(s), have %d task(s), pending %d task(s)",
 		len(wantTasks),
@@ -236,6 +294,12 @@ func transform(
 	// unschedule the rest. If we find a created instance, and it's not
 	// pending-schedule, then we'll assume the Start signal was lost, and
 	// issue another schedule mutation. Otherwise, schedule a new instance.
+	//
+	// The exceptions are health and draining: if the only instance we could
+	// keep has been unhealthy for longer than the UnhealthyWindow, or runs on
+	// a draining agent, we keep it, but schedule a replacement elsewhere. Once
+	// the replacement runs, the instance loses out to it, and gets
+	// unscheduled.
 
 	// Scan the domain for instances we can keep.
 	for id, config := range wantTasks {
@@ -248,19 +312,42 @@ func transform(
 			// more than once. Pick one of those instances and use it, rather
 			// than scheduling a new one.
 
+			if p, ok := pending[id]; ok && p.Schedule && p.Replace {
+				// A replacement for an unhealthy or draining instance is on
+				// its way. Keep both, until the replacement is up.
+				for endpoint := range haveTasks[id] {
+					delete(haveTasks[id], endpoint) // accounted-for
+					if endpoint == p.Endpoint || endpoint == p.Previous {
+						toKeep[endpoint] = id
+						continue
+					}
+					toUnschedule[endpoint] = append(toUnschedule[endpoint], id)
+				}
+
+				delete(wantTasks, id) // accounted-for
+				continue
+			}
+
 			var (
 				satisfied = false
 			)
-			for endpoint, instance := range haveTasks[id] {
+			for _, endpoint := range byPreference(haveTasks[id], draining) {
+				instance := haveTasks[id][endpoint]
+
 				if satisfied {
 					// The wanted container has already been satisfied
-					// elsewhere in the domain. Remove this instance.
+					// elsewhere in the domain. Remove this instance, unless
+					// that's already pending.
 					delete(haveTasks[id], endpoint) // accounted-for
+					if p, ok := pending[id]; ok && !p.Schedule && p.Endpoint == endpoint {
+						continue
+					}
 					toUnschedule[endpoint] = append(toUnschedule[endpoint], id)
 					continue
 				}
 
 				var (
+					preparing       = instance.ContainerStatus == agent.ContainerStatusPreparing
 					created         = instance.ContainerStatus == agent.ContainerStatusCreated
 					running         = instance.ContainerStatus == agent.ContainerStatusRunning
 					finished        = instance.ContainerStatus == agent.ContainerStatusFinished
@@ -268,6 +355,29 @@ func transform(
 					pendingSchedule = func() bool { b, ok := pending[id]; return ok && b.Schedule }()
 				)
 
+				if running && unhealthy(instance) {
+					// There's no better instance, so keep this one for now,
+					// but replace it as soon as possible.
+					delete(haveTasks[id], endpoint) // accounted-for
+					toKeep[endpoint] = id
+					toReplace[id] = replacement{endpoint: endpoint, config: config}
+					satisfied = true
+					continue
+				}
+
+				if (running || (created && !pendingSchedule)) && draining[endpoint] {
+					// The agent is being drained, and won't start anything.
+					// Keep this instance until a replacement runs elsewhere,
+					// if it may be migrated now.
+					delete(haveTasks[id], endpoint) // accounted-for
+					toKeep[endpoint] = id
+					if migrate(endpoint) {
+						toReplace[id] = replacement{endpoint: endpoint, config: config}
+					}
+					satisfied = true
+					continue
+				}
+
 				if running || finished || failed {
 					// The container is already being supervised.
 					delete(haveTasks[id], endpoint) // accounted-for
@@ -276,6 +386,16 @@ func transform(
 					continue
 				}
 
+				if preparing {
+					// The agent is still fetching the artifact, and will
+					// start the container once it's done. Wait for it, even
+					// if the schedule request has already expired.
+					delete(haveTasks[id], endpoint) // accounted-for
+					toKeep[endpoint] = id
+					satisfied = true
+					continue
+				}
+
 				if created && pendingSchedule {
 					// This instance is apparently in the process of being
 					// started. We'll just wait for it, and unschedule the
@@ -353,9 +473,10 @@ func transform(
 	}
 
 	Debugf(
-		"after scan: %d to keep, %d to schedule, %d to unschedule",
+		"after scan: %d to keep, %d to schedule, %d to replace, %d to unschedule",
 		len(toKeep),
 		len(toSchedule),
+		len(toReplace),
 		killCount,
 	)
 
@@ -363,6 +484,7 @@ func transform(
 	placed, failed := Algorithm(toSchedule, have, pending)
 	if len(failed) > 0 {
 		log.Printf("the scheduling algorithm failed to place %d/%d tasks", len(failed), len(toSchedule))
+		reportConstrained(failed, have)
 	}
 
 	metrics.IncContainersRequested(len(toSchedule))
@@ -391,6 +513,44 @@ func transform(
 	sched(toStart)
 	sched(placed)
 
+	// Replacements must be placed away from the instance they replace. That's
+	// different for every replacement, so place them one by one.
+	for id, r := range toReplace {
+		candidates := make(map[string]agent.StateEvent, len(have))
+		for endpoint, state := range have {
+			if endpoint != r.endpoint {
+				candidates[endpoint] = state
+			}
+		}
+
+		placed, failed := Algorithm(map[string]agent.ContainerConfig{id: r.config}, candidates, pending)
+		if len(failed) > 0 {
+			log.Printf("the scheduling algorithm failed to place a replacement for task %q on %s", id, r.endpoint)
+			reportConstrained(failed, candidates)
+			metrics.IncContainersFailed(len(failed))
+			continue
+		}
+
+		metrics.IncContainersPlaced(len(placed))
+
+		for endpoint := range placed {
+			if err := target.Schedule(endpoint, id, r.config); err != nil {
+				log.Printf("%s schedule replacement %q failed: %s", endpoint, id, err)
+				continue
+			}
+
+			Debugf("%s schedule replacement %q (for %s) now pending", endpoint, id, r.endpoint)
+			pending[id] = algo.PendingTask{
+				Schedule:        true,
+				Replace:         true,
+				Previous:        r.endpoint,
+				Deadline:        xtime.Now().Add(Tolerance),
+				Endpoint:        endpoint,
+				ContainerConfig: r.config,
+			} // we issued the mutation
+		}
+	}
+
 	// Invoke the unschedule mutations.
 	for endpoint, ids := range toUnschedule {
 		for _, id := range ids {
@@ -410,3 +570,95 @@ func transform(
 
 	return pending
 }
+
+// reportConstrained logs the tasks which failed to be placed, because no
+// agent satisfies their constraints, rather than for lack of resources.
+func reportConstrained(failed map[string]agent.ContainerConfig, have map[string]agent.StateEvent) {
+	for id, config := range failed {
+		if !algo.Constrained(config, have) {
+			continue
+		}
+
+		constraints := make([]string, len(config.Constraints))
+		for i, c := range config.Constraints {
+			constraints[i] = c.String()
+		}
+
+		log.Printf("task %q can't be placed: no agent satisfies its constraints (%s)", id, strings.Join(constraints, " and "))
+		metrics.IncContainersConstrained(1)
+	}
+}
+
+type replacement struct {
+	endpoint string // of the instance to replace
+	config   agent.ContainerConfig
+}
+
+// unhealthy returns true if the instance has continuously reported itself
+// unhealthy for longer than the UnhealthyWindow. Containers which flap
+// between healthy and unhealthy never qualify.
+func unhealthy(instance agent.ContainerInstance) bool {
+	return instance.HealthStatus == agent.HealthStatusUnhealthy &&
+		xtime.Now().Sub(instance.ContainerHealth.Since) > UnhealthyWindow
+}
+
+// on returns the subset of instances located on the given endpoint.
+func on(m map[string]agent.ContainerInstance, endpoint string) map[string]agent.ContainerInstance {
+	instance, ok := m[endpoint]
+	if !ok {
+		return map[string]agent.ContainerInstance{}
+	}
+
+	return map[string]agent.ContainerInstance{endpoint: instance}
+}
+
+// byPreference returns the endpoints of the instances of a single task,
+// ordered by which instance we'd rather keep: healthy instances first, then
+// other running, finished, or failed instances, then preparing or created
+// instances, and finally instances that have been unhealthy for too long, or
+// are on draining agents.
+func byPreference(m map[string]agent.ContainerInstance, draining map[string]bool) []string {
+	s := preference{
+		e2r:       make(map[string]int, len(m)),
+		endpoints: make([]string, 0, len(m)),
+	}
+
+	for endpoint, instance := range m {
+		switch {
+		case draining[endpoint]:
+			s.e2r[endpoint] = 3
+		case instance.ContainerStatus == agent.ContainerStatusPreparing,
+			instance.ContainerStatus == agent.ContainerStatusCreated:
+			s.e2r[endpoint] = 2
+		case instance.ContainerStatus == agent.ContainerStatusRunning && unhealthy(instance):
+			s.e2r[endpoint] = 3
+		case instance.HealthStatus == agent.HealthStatusHealthy:
+			s.e2r[endpoint] = 0
+		default:
+			s.e2r[endpoint] = 1
+		}
+
+		s.endpoints = append(s.endpoints, endpoint)
+	}
+
+	sort.Sort(s)
+
+	return s.endpoints
+}
+
+type preference struct {
+	e2r       map[string]int // endpoint to rank, lower is better
+	endpoints []string
+}
+
+func (s preference) Less(i, j int) bool {
+	return s.e2r[s.endpoints[i]] < s.e2r[s.endpoints[j]]
+}
+
+func (s preference) Len() int {
+	return len(s.endpoints)
+}
+
+func (s preference) Swap(i, j int) {
+	s.endpoints[i], s.endpoints[j] = s.endpoints[j], s.endpoints[i]
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/soundcloud/harpoon/harpoon-agent/lib"
 	"github.com/soundcloud/harpoon/harpoon-configstore/lib"
 	"github.com/soundcloud/harpoon/harpoon-scheduler/algo"
+	"github.com/soundcloud/harpoon/harpoon-scheduler/registry"
 	"github.com/soundcloud/harpoon/harpoon-scheduler/xtime"
 )
 
@@ -28,7 +29,7 @@ func TestRemoveDuplicates(t *testing.T) {
 	}
 
 	want := map[string]configstore.JobConfig{
-		"a": jobConfig,
+		jobConfig.Hash(): jobConfig,
 	}
 
 	have := map[string]agent.StateEvent{
@@ -58,7 +59,7 @@ func TestRespectPending(t *testing.T) {
 	}
 
 	want := map[string]configstore.JobConfig{
-		"a": jobConfig,
+		jobConfig.Hash(): jobConfig,
 	}
 
 	have := map[string]agent.StateEvent{
@@ -99,7 +100,7 @@ func TestRespectResourceReservedForPendingTasks(t *testing.T) {
 	}
 
 	want := map[string]configstore.JobConfig{
-		"a": jobConfig,
+		jobConfig.Hash(): jobConfig,
 	}
 
 	have := map[string]agent.StateEvent{
@@ -161,7 +162,7 @@ func TestPendingExpiration(t *testing.T) {
 	}
 
 	want := map[string]configstore.JobConfig{
-		"a": jobConfig,
+		jobConfig.Hash(): jobConfig,
 	}
 
 	have := map[string]agent.StateEvent{
@@ -335,6 +336,317 @@ func TestRetryingMutation(t *testing.T) {
 	}
 }
 
+func TestReplaceUnhealthy(t *testing.T) {
+	Debugf = t.Logf
+
+	fakeNow := time.Now()
+	xtime.Now = func() time.Time { return fakeNow }
+
+	var (
+		jobConfig = configstore.JobConfig{Job: "a", Scale: 1}
+		id        = makeContainerID(jobConfig.Hash(), 0)
+		want      = map[string]configstore.JobConfig{jobConfig.Hash(): jobConfig}
+		have      = map[string]agent.StateEvent{
+			"agent-one": agent.StateEvent{
+				Containers: map[string]agent.ContainerInstance{
+					id: unhealthyInstance(fakeNow.Add(-2 * UnhealthyWindow)),
+				},
+				Resources: testResources,
+			},
+			"agent-two": agent.StateEvent{
+				Containers: map[string]agent.ContainerInstance{},
+				Resources:  testResources,
+			},
+		}
+		target  = &mockTaskScheduler{}
+		pending = transform(want, have, target, map[string]algo.PendingTask{})
+	)
+
+	// The unhealthy instance should be kept, and a replacement scheduled on
+	// the other agent.
+
+	if want, have := int32(1), atomic.LoadInt32(&target.schedules); want != have {
+		t.Errorf("want %d schedule(s), have %d", want, have)
+	}
+
+	if want, have := int32(0), atomic.LoadInt32(&target.unschedules); want != have {
+		t.Errorf("want %d unschedule(s), have %d", want, have)
+	}
+
+	p, ok := pending[id]
+	if !ok || !p.Schedule || !p.Replace {
+		t.Fatalf("want pending replacement, have %+v", p)
+	}
+
+	if want, have := "agent-two", p.Endpoint; want != have {
+		t.Errorf("want replacement on %s, have %s", want, have)
+	}
+
+	if want, have := "agent-one", p.Previous; want != have {
+		t.Errorf("want replacement of instance on %s, have %s", want, have)
+	}
+
+	// While the replacement is starting, nothing should happen.
+
+	have["agent-two"].Containers[id] = agent.ContainerInstance{ContainerStatus: agent.ContainerStatusCreated}
+	target = &mockTaskScheduler{}
+	pending = transform(want, have, target, pending)
+
+	if want, have := int32(0), atomic.LoadInt32(&target.schedules); want != have {
+		t.Errorf("want %d schedule(s), have %d", want, have)
+	}
+
+	if want, have := int32(0), atomic.LoadInt32(&target.unschedules); want != have {
+		t.Errorf("want %d unschedule(s), have %d", want, have)
+	}
+
+	// Once the replacement is running, the unhealthy instance should be
+	// unscheduled.
+
+	have["agent-two"].Containers[id] = agent.ContainerInstance{ContainerStatus: agent.ContainerStatusRunning}
+	target = &mockTaskScheduler{}
+	pending = transform(want, have, target, pending)
+
+	if want, have := int32(0), atomic.LoadInt32(&target.schedules); want != have {
+		t.Errorf("want %d schedule(s), have %d", want, have)
+	}
+
+	if want, have := int32(1), atomic.LoadInt32(&target.unschedules); want != have {
+		t.Errorf("want %d unschedule(s), have %d", want, have)
+	}
+
+	if want, have := "agent-one", pending[id].Endpoint; want != have {
+		t.Errorf("want pending unschedule on %s, have %s", want, have)
+	}
+
+	// The unschedule is pending, so it shouldn't be repeated.
+
+	target = &mockTaskScheduler{}
+	pending = transform(want, have, target, pending)
+
+	if want, have := int32(0), atomic.LoadInt32(&target.unschedules); want != have {
+		t.Errorf("want %d unschedule(s), have %d", want, have)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	Debugf = t.Logf
+
+	defer func(n int) { DrainConcurrency = n }(DrainConcurrency)
+	DrainConcurrency = 1
+
+	var (
+		jobConfig = configstore.JobConfig{Job: "a", Scale: 2}
+		id0       = makeContainerID(jobConfig.Hash(), 0)
+		id1       = makeContainerID(jobConfig.Hash(), 1)
+		want      = map[string]configstore.JobConfig{jobConfig.Hash(): jobConfig}
+		running   = agent.ContainerInstance{ContainerStatus: agent.ContainerStatusRunning}
+		have      = map[string]agent.StateEvent{
+			"agent-one": agent.StateEvent{
+				Containers: map[string]agent.ContainerInstance{id0: running, id1: running},
+				Resources:  agent.HostResources{Mem: testResources.Mem, CPU: testResources.CPU, Draining: true},
+			},
+			"agent-two": agent.StateEvent{
+				Containers: map[string]agent.ContainerInstance{},
+				Resources:  testResources,
+			},
+		}
+	)
+
+	// Only one task should be migrated at a time, and its instance on the
+	// draining agent kept until the replacement runs.
+
+	target := &mockTaskScheduler{}
+	pending := transform(want, have, target, map[string]algo.PendingTask{})
+
+	if want, have := int32(1), atomic.LoadInt32(&target.schedules); want != have {
+		t.Errorf("want %d schedule(s), have %d", want, have)
+	}
+
+	if want, have := int32(0), atomic.LoadInt32(&target.unschedules); want != have {
+		t.Errorf("want %d unschedule(s), have %d", want, have)
+	}
+
+	var migrated string
+	for id, p := range pending {
+		if !p.Replace || p.Endpoint != "agent-two" || p.Previous != "agent-one" {
+			t.Fatalf("want pending replacement on agent-two, have %+v", p)
+		}
+		migrated = id
+	}
+
+	target = &mockTaskScheduler{}
+	pending = transform(want, have, target, pending)
+
+	if want, have := int32(0), atomic.LoadInt32(&target.schedules); want != have {
+		t.Errorf("want %d schedule(s), have %d", want, have)
+	}
+
+	// Once the replacement runs, the instance on the draining agent should be
+	// unscheduled, and the next task migrated.
+
+	have["agent-two"].Containers[migrated] = running
+	target = &mockTaskScheduler{}
+	pending = transform(want, have, target, pending)
+
+	if want, have := int32(1), atomic.LoadInt32(&target.schedules); want != have {
+		t.Errorf("want %d schedule(s), have %d", want, have)
+	}
+
+	if want, have := int32(1), atomic.LoadInt32(&target.unschedules); want != have {
+		t.Errorf("want %d unschedule(s), have %d", want, have)
+	}
+
+	if p := pending[migrated]; p.Schedule || p.Endpoint != "agent-one" {
+		t.Errorf("want pending unschedule of %q on agent-one, have %+v", migrated, p)
+	}
+}
+
+func TestFlappingHealth(t *testing.T) {
+	Debugf = t.Logf
+
+	fakeNow := time.Now()
+	xtime.Now = func() time.Time { return fakeNow }
+
+	var (
+		jobConfig = configstore.JobConfig{Job: "a", Scale: 1}
+		id        = makeContainerID(jobConfig.Hash(), 0)
+		want      = map[string]configstore.JobConfig{jobConfig.Hash(): jobConfig}
+		have      = map[string]agent.StateEvent{
+			"agent-one": agent.StateEvent{
+				Containers: map[string]agent.ContainerInstance{},
+				Resources:  testResources,
+			},
+			"agent-two": agent.StateEvent{
+				Containers: map[string]agent.ContainerInstance{},
+				Resources:  testResources,
+			},
+		}
+		target  = &mockTaskScheduler{}
+		pending = map[string]algo.PendingTask{}
+	)
+
+	// The container flips between healthy and unhealthy. Each flip resets
+	// the start of its health status, so even though the container is
+	// unhealthy on and off for much longer than the window, it never gets
+	// replaced.
+
+	for i := 0; i < 10; i++ {
+		instance := agent.ContainerInstance{
+			ContainerStatus: agent.ContainerStatusRunning,
+			ContainerHealth: agent.ContainerHealth{HealthStatus: agent.HealthStatusHealthy, Since: fakeNow},
+		}
+		if i%2 == 0 {
+			instance = unhealthyInstance(fakeNow)
+		}
+
+		have["agent-one"].Containers[id] = instance
+		fakeNow = fakeNow.Add(UnhealthyWindow / 2)
+		pending = transform(want, have, target, pending)
+	}
+
+	if want, have := int32(0), atomic.LoadInt32(&target.schedules); want != have {
+		t.Errorf("want %d schedule(s), have %d", want, have)
+	}
+
+	if want, have := int32(0), atomic.LoadInt32(&target.unschedules); want != have {
+		t.Errorf("want %d unschedule(s), have %d", want, have)
+	}
+
+	// Eventually, the container stays unhealthy, and gets replaced.
+
+	have["agent-one"].Containers[id] = unhealthyInstance(fakeNow)
+	fakeNow = fakeNow.Add(UnhealthyWindow + time.Millisecond)
+	pending = transform(want, have, target, pending)
+
+	if want, have := int32(1), atomic.LoadInt32(&target.schedules); want != have {
+		t.Errorf("want %d schedule(s), have %d", want, have)
+	}
+
+	// While the replacement is pending, the original recovers. It's kept
+	// until the replacement is up, and nothing is unscheduled.
+
+	have["agent-one"].Containers[id] = agent.ContainerInstance{
+		ContainerStatus: agent.ContainerStatusRunning,
+		ContainerHealth: agent.ContainerHealth{HealthStatus: agent.HealthStatusHealthy, Since: fakeNow},
+	}
+	have["agent-two"].Containers[id] = agent.ContainerInstance{ContainerStatus: agent.ContainerStatusCreated}
+	pending = transform(want, have, target, pending)
+
+	if want, have := int32(1), atomic.LoadInt32(&target.schedules); want != have {
+		t.Errorf("want %d schedule(s), have %d", want, have)
+	}
+
+	if want, have := int32(0), atomic.LoadInt32(&target.unschedules); want != have {
+		t.Errorf("want %d unschedule(s), have %d", want, have)
+	}
+
+	// When the replacement runs, one of the two healthy instances goes.
+
+	have["agent-two"].Containers[id] = agent.ContainerInstance{ContainerStatus: agent.ContainerStatusRunning}
+	pending = transform(want, have, target, pending)
+
+	if want, have := int32(1), atomic.LoadInt32(&target.unschedules); want != have {
+		t.Errorf("want %d unschedule(s), have %d", want, have)
+	}
+
+	// The recovered original is healthy, while the replacement hasn't
+	// reported its health yet, so the replacement goes.
+
+	if want, have := "agent-two", pending[id].Endpoint; want != have {
+		t.Errorf("want pending unschedule on %s, have %s", want, have)
+	}
+}
+
+var testResources = agent.HostResources{
+	Mem: agent.TotalReservedInt{Total: 1024},
+	CPU: agent.TotalReserved{Total: 4.0},
+}
+
+func TestWaitForPreparing(t *testing.T) {
+	Debugf = t.Logf
+
+	var (
+		jobConfig = configstore.JobConfig{Job: "a", Scale: 1}
+		id        = makeContainerID(jobConfig.Hash(), 0)
+		want      = map[string]configstore.JobConfig{jobConfig.Hash(): jobConfig}
+		have      = map[string]agent.StateEvent{
+			"agent-one": agent.StateEvent{
+				Containers: map[string]agent.ContainerInstance{
+					id: agent.ContainerInstance{ContainerStatus: agent.ContainerStatusPreparing},
+				},
+				Resources: testResources,
+			},
+		}
+		target  = &mockTaskScheduler{}
+		pending = map[string]algo.PendingTask{} // the schedule request expired
+	)
+
+	// The agent is still fetching the artifact, so the task is neither
+	// scheduled again, nor unscheduled.
+
+	pending = transform(want, have, target, pending)
+
+	if want, have := int32(0), atomic.LoadInt32(&target.schedules); want != have {
+		t.Errorf("want %d schedule(s), have %d", want, have)
+	}
+
+	if want, have := int32(0), atomic.LoadInt32(&target.unschedules); want != have {
+		t.Errorf("want %d unschedule(s), have %d", want, have)
+	}
+
+	if want, have := 0, len(pending); want != have {
+		t.Errorf("want %d pending task(s), have %d", want, have)
+	}
+}
+
+func unhealthyInstance(since time.Time) agent.ContainerInstance {
+	return agent.ContainerInstance{
+		ContainerStatus: agent.ContainerStatusRunning,
+		ContainerHealth: agent.ContainerHealth{HealthStatus: agent.HealthStatusUnhealthy, Since: since},
+	}
+}
+
 type mockTaskScheduler struct {
 	schedules   int32
 	unschedules int32
@@ -349,3 +661,193 @@ func (s *mockTaskScheduler) Unschedule(endpoint, id string) error {
 	atomic.AddInt32(&s.unschedules, 1)
 	return nil
 }
+
+func TestScaleKeepsTasks(t *testing.T) {
+	Debugf = t.Logf
+
+	var (
+		jobConfig = configstore.JobConfig{Job: "a", Scale: 4}
+		hash      = jobConfig.Hash()
+		have      = map[string]agent.StateEvent{
+			"agent-one": agent.StateEvent{
+				Containers: map[string]agent.ContainerInstance{},
+				Resources:  testResources,
+			},
+		}
+	)
+
+	for i := 0; i < 4; i++ {
+		have["agent-one"].Containers[makeContainerID(hash, i)] = agent.ContainerInstance{ContainerStatus: agent.ContainerStatusRunning}
+	}
+
+	// Scaling up adds the trailing tasks only.
+
+	jobConfig.Scale = 6
+	target := &mockTaskScheduler{}
+	pending := transform(map[string]configstore.JobConfig{hash: jobConfig}, have, target, map[string]algo.PendingTask{})
+
+	if want, have := int32(2), atomic.LoadInt32(&target.schedules); want != have {
+		t.Errorf("want %d schedule(s), have %d", want, have)
+	}
+
+	if want, have := int32(0), atomic.LoadInt32(&target.unschedules); want != have {
+		t.Errorf("want %d unschedule(s), have %d", want, have)
+	}
+
+	for _, i := range []int{4, 5} {
+		if _, ok := pending[makeContainerID(hash, i)]; !ok {
+			t.Errorf("task %d not pending schedule", i)
+		}
+	}
+
+	// Scaling down removes the trailing tasks only.
+
+	jobConfig.Scale = 2
+	target = &mockTaskScheduler{}
+	pending = transform(map[string]configstore.JobConfig{hash: jobConfig}, have, target, map[string]algo.PendingTask{})
+
+	if want, have := int32(0), atomic.LoadInt32(&target.schedules); want != have {
+		t.Errorf("want %d schedule(s), have %d", want, have)
+	}
+
+	if want, have := int32(2), atomic.LoadInt32(&target.unschedules); want != have {
+		t.Errorf("want %d unschedule(s), have %d", want, have)
+	}
+
+	for _, i := range []int{2, 3} {
+		if p, ok := pending[makeContainerID(hash, i)]; !ok || p.Schedule {
+			t.Errorf("task %d not pending unschedule", i)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	Debugf = t.Logf
+
+	fakeNow := time.Now()
+	xtime.Now = func() time.Time { return fakeNow }
+
+	var (
+		r    = registry.New("")
+		from = configstore.JobConfig{Job: "a", Scale: 2}
+		to   = configstore.JobConfig{Job: "a", Scale: 2, Environment: "new"}
+		have = map[string]agent.StateEvent{
+			"agent-one": agent.StateEvent{Containers: map[string]agent.ContainerInstance{}},
+		}
+	)
+	defer r.Quit()
+
+	if err := r.Schedule(from); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Migrate(from.Hash(), to, 1, registry.OnFailurePause); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first new task isn't up yet.
+
+	have["agent-one"].Containers[makeContainerID(to.Hash(), 0)] = agent.ContainerInstance{ContainerStatus: agent.ContainerStatusCreated}
+	migrate(r, have)
+
+	if want, have := 0, r.Migrations()[to.Hash()].Step; want != have {
+		t.Fatalf("want step %d, have %d", want, have)
+	}
+
+	// Once it runs, the migration moves on to the next batch.
+
+	have["agent-one"].Containers[makeContainerID(to.Hash(), 0)] = agent.ContainerInstance{ContainerStatus: agent.ContainerStatusRunning}
+	migrate(r, have)
+
+	if want, have := 1, r.Migrations()[to.Hash()].Step; want != have {
+		t.Fatalf("want step %d, have %d", want, have)
+	}
+
+	// The second new task fails, and the migration is paused.
+
+	have["agent-one"].Containers[makeContainerID(to.Hash(), 1)] = agent.ContainerInstance{ContainerStatus: agent.ContainerStatusFailed}
+	migrate(r, have)
+
+	if m := r.Migrations()[to.Hash()]; !m.Paused {
+		t.Fatalf("want paused migration, have %+v", m)
+	}
+
+	// Resumed, it times out if the task doesn't come up.
+
+	if err := r.Resume(to.Hash()); err != nil {
+		t.Fatal(err)
+	}
+
+	have["agent-one"].Containers[makeContainerID(to.Hash(), 1)] = agent.ContainerInstance{ContainerStatus: agent.ContainerStatusCreated}
+	fakeNow = fakeNow.Add(2 * MigrationTimeout)
+	migrate(r, have)
+
+	if m := r.Migrations()[to.Hash()]; !m.Paused {
+		t.Fatalf("want paused migration, have %+v", m)
+	}
+
+	// Resumed again, it completes once the task comes up.
+
+	if err := r.Resume(to.Hash()); err != nil {
+		t.Fatal(err)
+	}
+
+	fakeNow = time.Now()
+	have["agent-one"].Containers[makeContainerID(to.Hash(), 1)] = agent.ContainerInstance{ContainerStatus: agent.ContainerStatusRunning}
+	migrate(r, have)
+
+	if want, have := 0, len(r.Migrations()); want != have {
+		t.Fatalf("want %d migration(s), have %d", want, have)
+	}
+
+	if _, ok := r.Snapshot()[from.Hash()]; ok {
+		t.Errorf("old job still scheduled after migration")
+	}
+}
+
+func TestCheckBatchHealth(t *testing.T) {
+	var (
+		to = configstore.JobConfig{
+			Job:             "a",
+			Scale:           1,
+			ContainerConfig: agent.ContainerConfig{HealthChecks: []agent.HealthCheck{{Protocol: agent.ProtocolTCP, Port: "tcp"}}},
+		}
+		m = registry.Migration{To: to.Hash(), ToScale: 1, BatchSize: 1}
+	)
+
+	for status, want := range map[agent.HealthStatus]bool{
+		agent.HealthStatusUnknown:   false,
+		agent.HealthStatusUnhealthy: false,
+		agent.HealthStatusHealthy:   true,
+	} {
+		have := map[string]agent.StateEvent{
+			"agent-one": agent.StateEvent{
+				Containers: map[string]agent.ContainerInstance{
+					makeContainerID(to.Hash(), 0): agent.ContainerInstance{
+						ContainerStatus: agent.ContainerStatusRunning,
+						ContainerHealth: agent.ContainerHealth{HealthStatus: status},
+					},
+				},
+			},
+		}
+
+		ready, err := checkBatch(m, to, have)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want != ready {
+			t.Errorf("%s: want ready %v, have %v", status, want, ready)
+		}
+	}
+}
+
+func TestTasksAreLabelledWithTheirJob(t *testing.T) {
+	// Agents leave finished and failed tasks to the scheduler, instead of
+	// garbage-collecting them, by their job label.
+	c := makeContainerConfig(configstore.JobConfig{Job: "a", Environment: "prod", Product: "search"})
+
+	if want, have := "a", c.Labels["job"]; want != have {
+		t.Errorf("want job label %q, have %q", want, have)
+	}
+}
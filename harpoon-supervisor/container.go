@@ -1,11 +1,28 @@
 package main
 
 import (
+	"io"
 	"os"
 
 	"github.com/soundcloud/harpoon/harpoon-agent/lib"
 )
 
+const (
+	// cpuPeriod is the CFS enforcement period, in microseconds. Containers
+	// get a quota of CPU time in every period, in proportion to their CPUs.
+	cpuPeriod = 100000
+
+	minCPUShares = 2    // kernel minimum
+	minCPUQuota  = 1000 // kernel minimum, in microseconds
+)
+
+const (
+	// The host side of a container's veth pair is named with this prefix
+	// and a random suffix; the container side is renamed to eth0.
+	vethPrefix = "hp"
+	vethMTU    = 1500
+)
+
 // Container defines the platform-agnostic interface for managing a container.
 type Container interface {
 	Start() error
@@ -15,7 +32,28 @@ type Container interface {
 	// Signal sends sig to the container's init process.
 	Signal(sig os.Signal)
 
+	// Exec runs a command in the running container, and returns its exit
+	// status.
+	Exec(args []string, stdin io.Reader, stdout, stderr io.Writer) (int, error)
+
 	Metrics() agent.ContainerMetrics
 
 	Config() agent.ContainerConfig
 }
+
+// cpuLimits derives the cgroup CPU shares and CFS quota and period from
+// fractional CPUs. Shares weigh containers against each other when the host
+// is busy, and the quota caps a container even when it's not.
+func cpuLimits(cpus float64) (shares, quota, period int64) {
+	shares = int64(cpus * 1024)
+	if shares < minCPUShares {
+		shares = minCPUShares
+	}
+
+	quota = int64(cpus * cpuPeriod)
+	if quota < minCPUQuota {
+		quota = minCPUQuota
+	}
+
+	return shares, quota, cpuPeriod
+}
@@ -5,9 +5,13 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"syscall"
 
 	"github.com/docker/libcontainer"
@@ -20,9 +24,20 @@ import (
 	"github.com/soundcloud/harpoon/harpoon-agent/lib"
 )
 
+const (
+	// tmpfsDir is the directory in the rundir where sized tmpfs mounts are
+	// mounted on the host.
+	tmpfsDir = "tmpfs"
+
+	// tmpfsFlags are the flags libcontainer mounts tmpfs mounts with.
+	tmpfsFlags = syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
+)
+
 type container struct {
 	hostname string
 	id       string
+	bridge   string
+	gateway  string
 
 	agentConfigPath     string
 	agentConfig         agent.ContainerConfig
@@ -30,6 +45,10 @@ type container struct {
 	rootfs              string
 	args                []string
 
+	// tmpfs are the sized tmpfs mounts, by their directory on the host (see
+	// tmpfsDir), and their size (MB).
+	tmpfs map[string]int
+
 	err             error
 	containerConfig *libcontainer.Config
 
@@ -38,14 +57,17 @@ type container struct {
 	exitc chan error
 }
 
-func newContainer(hostname string, id string, agentConfig, containerConfig, rootfs string, args []string) Container {
+func newContainer(hostname, id, bridge, gateway string, agentConfig, containerConfig, rootfs string, args []string) Container {
 	container := &container{
 		hostname:            hostname,
 		id:                  id,
+		bridge:              bridge,
+		gateway:             gateway,
 		agentConfigPath:     agentConfig,
 		containerConfigPath: containerConfig,
 		rootfs:              rootfs,
 		args:                args,
+		tmpfs:               map[string]int{},
 		exitc:               make(chan error, 1),
 	}
 
@@ -70,6 +92,16 @@ func (c *container) configure() error {
 		return err
 	}
 
+	if c.agentConfig.Network == agent.NetworkPrivate {
+		if c.bridge == "" || c.gateway == "" {
+			return fmt.Errorf("private network requires a bridge and a gateway")
+		}
+
+		if c.agentConfig.Address == "" {
+			return fmt.Errorf("private network requires an address")
+		}
+	}
+
 	// Check if the rootfs exists
 	fi, err := os.Stat(c.rootfs)
 	if err != nil {
@@ -80,8 +112,14 @@ func (c *container) configure() error {
 		return fmt.Errorf("rootfs %q invalid: not a directory", c.rootfs)
 	}
 
+	// Sized tmpfs mounts are mounted in the rundir, the working directory.
+	rundir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
 	// Extract libcontainer config from harpoon config, and write it out to the filesystem.
-	c.containerConfig = c.libcontainerConfig()
+	c.containerConfig = c.libcontainerConfig(rundir)
 	containerConfigFile, err := os.OpenFile(c.containerConfigPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
 		return err
@@ -120,6 +158,10 @@ func (c *container) Start() error {
 		return c.err
 	}
 
+	if err := c.mountTmpfs(); err != nil {
+		return err
+	}
+
 	var started = make(chan struct{})
 
 	startCallback := func() {
@@ -140,12 +182,14 @@ func (c *container) Start() error {
 			os.Stdout,
 			os.Stderr,
 			"", // no console
-			"", // datapath handled elsewhere
+			"", // state, e.g. the init PID, is saved to the working directory (rundir), for exec
 			c.args,
 			c.containerCommand,
 			startCallback,
 		)
 
+		c.unmountTmpfs()
+
 		c.exitc <- err
 	}()
 
@@ -212,6 +256,38 @@ func (c *container) Signal(sig os.Signal) {
 	c.cmd.Process.Signal(sig)
 }
 
+// Exec runs a command in the namespaces and cgroups of the running
+// container, and returns its exit status.
+func (c *container) Exec(args []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	if len(args) == 0 {
+		return -1, fmt.Errorf("no command given")
+	}
+
+	if c.cmd == nil || c.cmd.Process == nil {
+		return -1, fmt.Errorf("container not running")
+	}
+
+	// namespaces.Exec saves the state to the working directory, and removes
+	// it when the container exits.
+	state, err := libcontainer.GetState(".")
+	if err != nil {
+		return -1, fmt.Errorf("unable to get container state: %s", err)
+	}
+
+	return namespaces.ExecIn(
+		c.containerConfig,
+		state,
+		args,
+		"/proc/self/exe",
+		containerExecAction,
+		stdin,
+		stdout,
+		stderr,
+		"", // no console
+		nil,
+	)
+}
+
 func (c *container) Metrics() agent.ContainerMetrics {
 	stats, err := fs.GetStats(c.containerConfig.Cgroups)
 	if err != nil {
@@ -219,9 +295,12 @@ func (c *container) Metrics() agent.ContainerMetrics {
 	}
 
 	return agent.ContainerMetrics{
-		MemoryUsage: stats.MemoryStats.Usage,
-		MemoryLimit: stats.MemoryStats.Stats["hierarchical_memory_limit"],
-		CPUTime:     stats.CpuStats.CpuUsage.TotalUsage,
+		MemoryUsage:         stats.MemoryStats.Usage,
+		MemoryLimit:         stats.MemoryStats.Stats["hierarchical_memory_limit"],
+		CPUTime:             stats.CpuStats.CpuUsage.TotalUsage,
+		CPUPeriods:          stats.CpuStats.ThrottlingData.Periods,
+		CPUThrottledPeriods: stats.CpuStats.ThrottlingData.ThrottledPeriods,
+		CPUThrottledTime:    stats.CpuStats.ThrottlingData.ThrottledTime,
 	}
 }
 
@@ -229,10 +308,40 @@ func (c *container) Config() agent.ContainerConfig {
 	return c.agentConfig
 }
 
+// mountTmpfs mounts the sized tmpfs mounts on the host, to be bound into the
+// container. They're mounted afresh for every start, like unsized ones.
+func (c *container) mountTmpfs() error {
+	for dir, size := range c.tmpfs {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			c.unmountTmpfs()
+			return err
+		}
+
+		if err := syscall.Mount("tmpfs", dir, "tmpfs", tmpfsFlags, fmt.Sprintf("size=%dm", size)); err != nil {
+			c.unmountTmpfs()
+			return fmt.Errorf("mounting tmpfs %s: %s", dir, err)
+		}
+	}
+
+	return nil
+}
+
+// unmountTmpfs unmounts the sized tmpfs mounts on the host, once the
+// container exited.
+func (c *container) unmountTmpfs() {
+	for dir := range c.tmpfs {
+		if err := syscall.Unmount(dir, syscall.MNT_DETACH); err != nil && err != syscall.EINVAL {
+			log.Printf("unmounting tmpfs %s: %s", dir, err)
+		}
+	}
+}
+
 // libcontainerConfig builds a complete libcontainer.Config from an
 // agent.ContainerConfig.
-func (c *container) libcontainerConfig() *libcontainer.Config {
+func (c *container) libcontainerConfig(rundir string) *libcontainer.Config {
 	var (
+		shares, quota, period = cpuLimits(c.agentConfig.Resources.CPU)
+
 		config = &libcontainer.Config{
 			RootFs:   c.rootfs,
 			Hostname: c.hostname,
@@ -252,6 +361,11 @@ func (c *container) libcontainerConfig() *libcontainer.Config {
 
 				Memory: int64(c.agentConfig.Resources.Mem * 1024 * 1024),
 
+				CpuShares:  shares,
+				CpuQuota:   quota,
+				CpuPeriod:  period,
+				CpusetCpus: c.agentConfig.Resources.CPUSet,
+
 				AllowedDevices: devices.DefaultAllowedDevices,
 			},
 			MountConfig: &libcontainer.MountConfig{
@@ -264,6 +378,21 @@ func (c *container) libcontainerConfig() *libcontainer.Config {
 		}
 	)
 
+	if c.agentConfig.Network == agent.NetworkPrivate {
+		config.Namespaces["NEWNET"] = true
+		config.Networks = []*libcontainer.Network{
+			{Type: "loopback", Address: "127.0.0.1/0", Gateway: "localhost"},
+			{
+				Type:       "veth",
+				Bridge:     c.bridge,
+				VethPrefix: vethPrefix,
+				Address:    c.agentConfig.Address,
+				Gateway:    c.gateway,
+				Mtu:        vethMTU,
+			},
+		}
+	}
+
 	for k, v := range c.agentConfig.Env {
 		config.Env = append(config.Env, fmt.Sprintf("%s=%s", k, v))
 	}
@@ -274,9 +403,30 @@ func (c *container) libcontainerConfig() *libcontainer.Config {
 		})
 	}
 
+	// libcontainer's tmpfs mounts take no size, so sized ones are mounted on
+	// the host (see mountTmpfs), and bound into the container. Unsized tmpfs
+	// mounts get the kernel default, half of the memory.
+	dests := make([]string, 0, len(c.agentConfig.Storage.Temp))
 	for dest := range c.agentConfig.Storage.Temp {
+		dests = append(dests, dest)
+	}
+	sort.Strings(dests)
+
+	for i, dest := range dests {
+		size := c.agentConfig.Storage.Temp[dest]
+
+		if size <= 0 {
+			config.MountConfig.Mounts = append(config.MountConfig.Mounts, &mount.Mount{
+				Type: "tmpfs", Destination: dest, Writable: true, Private: true,
+			})
+			continue
+		}
+
+		source := filepath.Join(rundir, tmpfsDir, strconv.Itoa(i))
+		c.tmpfs[source] = size
+
 		config.MountConfig.Mounts = append(config.MountConfig.Mounts, &mount.Mount{
-			Type: "tmpfs", Destination: dest, Writable: true, Private: true,
+			Type: "bind", Source: source, Destination: dest, Writable: true, Private: true,
 		})
 	}
 
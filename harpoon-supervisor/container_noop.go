@@ -4,6 +4,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/soundcloud/harpoon/harpoon-agent/lib"
@@ -11,7 +12,7 @@ import (
 
 type container struct{}
 
-func newContainer(hostname string, id string, agentConfig, containerConfig, rootfs string, args []string) Container {
+func newContainer(hostname, id, bridge, gateway string, agentConfig, containerConfig, rootfs string, args []string) Container {
 	return &container{}
 }
 
@@ -25,6 +26,10 @@ func (*container) Wait() agent.ContainerExitStatus {
 
 func (*container) Signal(os.Signal) {}
 
+func (*container) Exec([]string, io.Reader, io.Writer, io.Writer) (int, error) {
+	return -1, fmt.Errorf("platform does not support containers")
+}
+
 func (*container) Metrics() agent.ContainerMetrics {
 	return agent.ContainerMetrics{}
 }
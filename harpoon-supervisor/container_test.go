@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestCPULimits(t *testing.T) {
+	for _, input := range []struct {
+		cpus          float64
+		shares, quota int64
+	}{
+		{cpus: 1, shares: 1024, quota: 100000},
+		{cpus: 0.5, shares: 512, quota: 50000},
+		{cpus: 2.25, shares: 2304, quota: 225000},
+		{cpus: 0.001, shares: minCPUShares, quota: minCPUQuota},
+	} {
+		shares, quota, period := cpuLimits(input.cpus)
+
+		if want, have := input.shares, shares; want != have {
+			t.Errorf("%.3f CPUs: want %d shares, have %d", input.cpus, want, have)
+		}
+
+		if want, have := input.quota, quota; want != have {
+			t.Errorf("%.3f CPUs: want quota %d, have %d", input.cpus, want, have)
+		}
+
+		if want, have := int64(cpuPeriod), period; want != have {
+			t.Errorf("%.3f CPUs: want period %d, have %d", input.cpus, want, have)
+		}
+	}
+}
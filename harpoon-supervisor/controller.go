@@ -78,9 +78,17 @@ func (c *controllerConn) readLoop() error {
 
 		switch ev.Type {
 		case "stop":
-			c.s.Stop(syscall.SIGTERM)
+			c.s.Terminate()
 		case "kill":
 			c.s.Stop(syscall.SIGKILL)
+		case "signal":
+			sig, err := agent.ParseSignal(string(ev.Data))
+			if err != nil {
+				log.Printf("signal: %s", err)
+				continue
+			}
+
+			c.s.Signal(sig)
 		case "exit":
 			c.s.Exit()
 		}
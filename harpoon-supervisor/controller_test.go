@@ -59,17 +59,27 @@ func TestController(t *testing.T) {
 		t.Fatalf("unexpected state %#v", state)
 	}
 
+	if err := enc.Encode(eventsource.Event{Type: "signal", Data: []byte("HUP")}); err != nil {
+		t.Fatal("error sending signal command: ", err)
+	}
+
+	select {
+	case sig := <-s.signalc:
+		if sig != syscall.SIGHUP {
+			t.Fatal("expected SIGHUP, got ", sig)
+		}
+	case <-time.After(time.Millisecond):
+		panic("client connection did not call signal on supervisor")
+	}
+
 	if err := enc.Encode(eventsource.Event{Type: "stop"}); err != nil {
 		t.Fatal("error sending stop command: ", err)
 	}
 
 	select {
-	case stop := <-s.stopc:
-		if stop != syscall.SIGTERM {
-			t.Fatal("expected SIGTERM, got ", stop)
-		}
+	case <-s.terminatec:
 	case <-time.After(time.Millisecond):
-		panic("client connection did call stop on supervisor")
+		panic("client connection did not call terminate on supervisor")
 	}
 
 	// supervisor reports down state
@@ -0,0 +1,73 @@
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+
+	"github.com/docker/libcontainer"
+	"github.com/docker/libcontainer/namespaces"
+	_ "github.com/docker/libcontainer/namespaces/nsenter" // joins the namespaces of nsenter-* processes
+	"github.com/docker/libcontainer/syncpipe"
+)
+
+// containerExecAction names the processes which run commands in a running
+// container for Exec: namespaces.ExecIn names them nsenter-exec. The nsenter
+// package recognizes the name, and joins the container's namespaces before
+// the Go runtime starts.
+const containerExecAction = "exec"
+
+func init() {
+	// If the process name is nsenter-exec (set by namespaces.ExecIn), execution
+	// will be hijacked from main().
+	if os.Args[0] != "nsenter-"+containerExecAction {
+		return
+	}
+
+	runtime.LockOSThread()
+
+	syncPipe, err := syncpipe.NewSyncPipeFromFd(0, uintptr(3))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to initialize sync pipe: %s\n", err)
+		os.Exit(2)
+	}
+
+	var container *libcontainer.Config
+
+	if err := syncPipe.ReadFromParent(&container); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to receive container config: %s\n", err)
+		os.Exit(2)
+	}
+
+	// The working directory is still the supervisor's, outside of the
+	// container's root.
+	dir := container.WorkingDir
+	if dir == "" {
+		dir = "/"
+	}
+
+	if err := syscall.Chdir(dir); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to change to working dir %q: %s\n", dir, err)
+		os.Exit(2)
+	}
+
+	if err := namespaces.FinalizeSetns(container, execArgs(os.Args)); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to exec in container: %s\n", err)
+	}
+
+	os.Exit(2)
+}
+
+// execArgs returns the command given to nsenter, after "--".
+func execArgs(args []string) []string {
+	for i, arg := range args {
+		if arg == "--" {
+			return args[i+1:]
+		}
+	}
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"io"
 	"os"
 
 	"github.com/soundcloud/harpoon/harpoon-agent/lib"
@@ -10,15 +11,17 @@ type fakeContainer struct {
 	startc  chan error
 	signalc chan os.Signal
 	waitc   chan agent.ContainerExitStatus
-	restart agent.Restart
+	execc   chan []string
+	config  agent.ContainerConfig
 }
 
-func newFakeContainer(restart agent.Restart) *fakeContainer {
+func newFakeContainer(policy agent.RestartPolicy) *fakeContainer {
 	return &fakeContainer{
 		startc:  make(chan error),
 		signalc: make(chan os.Signal, 1),
 		waitc:   make(chan agent.ContainerExitStatus),
-		restart: restart,
+		execc:   make(chan []string, 1),
+		config:  agent.ContainerConfig{Restart: agent.Restart{Policy: policy}},
 	}
 }
 
@@ -38,6 +41,12 @@ func (c *fakeContainer) Signal(sig os.Signal) {
 	c.signalc <- sig
 }
 
+// Exec reports the command on execc, and succeeds.
+func (c *fakeContainer) Exec(args []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	c.execc <- args
+	return 0, nil
+}
+
 func (c *fakeContainer) Config() agent.ContainerConfig {
-	return agent.ContainerConfig{Restart: c.restart}
+	return c.config
 }
@@ -32,6 +32,8 @@ func main() {
 		showVersion = flag.Bool("version", false, "print version")
 		hostname    = flag.String("hostname", "", "hostname")
 		id          = flag.String("id", "", "container ID")
+		bridge      = flag.String("bridge", "", "bridge to connect a private network to")
+		gateway     = flag.String("gateway", "", "gateway of a private network")
 	)
 	flag.Parse()
 
@@ -61,6 +63,8 @@ func main() {
 		container = newContainer(
 			*hostname,
 			*id,
+			*bridge,
+			*gateway,
 			agentFileName,
 			containerFileName,
 			rootfsFileName,
@@ -74,9 +78,5 @@ func main() {
 	go signalHandler.Run()
 	go controller.Run()
 
-	restartTimer := func() <-chan time.Time {
-		return time.After(time.Second)
-	}
-
-	supervisor.Run(time.Tick(3*time.Second), restartTimer)
+	supervisor.Run(time.Tick(3*time.Second), time.After)
 }
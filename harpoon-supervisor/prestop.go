@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/soundcloud/harpoon/harpoon-agent/lib"
+)
+
+// stopSignal returns the signal which gracefully stops the container.
+func stopSignal(config agent.ContainerConfig) os.Signal {
+	if config.StopSignal == "" {
+		return syscall.SIGTERM
+	}
+
+	sig, err := agent.ParseSignal(config.StopSignal)
+	if err != nil {
+		return syscall.SIGTERM // rejected by the agent
+	}
+
+	return sig
+}
+
+// preStopTimeout returns how long the pre-stop hook may run: half of the
+// shutdown grace period. The agent kills the container once the grace period
+// is up, so the stop signal gets the other half.
+func preStopTimeout(grace time.Duration) time.Duration {
+	return grace / 2
+}
+
+// runPreStop runs the container's pre-stop hook, and returns its outcome.
+// The timeout only bounds HTTP requests; commands are abandoned by the
+// supervisor once the timeout is up.
+func runPreStop(c Container, timeout time.Duration) agent.PreStopStatus {
+	var (
+		config = c.Config()
+		hook   = config.PreStop
+		err    error
+	)
+
+	if hook.HTTPPort != "" {
+		err = preStopHTTP(config, timeout)
+	} else {
+		err = preStopExec(c, hook.Exec)
+	}
+
+	if err != nil {
+		return agent.PreStopStatus{Err: err.Error()}
+	}
+
+	return agent.PreStopStatus{Succeeded: true}
+}
+
+// preStopHTTP POSTs to the hook's path on the container's port, and expects
+// a 2xx response.
+func preStopHTTP(config agent.ContainerConfig, timeout time.Duration) error {
+	host := "127.0.0.1"
+	if config.Network == agent.NetworkPrivate {
+		ip, _, err := net.ParseCIDR(config.Address)
+		if err != nil {
+			return fmt.Errorf("container address %q invalid: %s", config.Address, err)
+		}
+
+		host = ip.String()
+	}
+
+	var (
+		port = strconv.Itoa(int(config.Ports[config.PreStop.HTTPPort]))
+		url  = fmt.Sprintf("http://%s%s", net.JoinHostPort(host, port), config.PreStop.HTTPPath)
+	)
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Dial: func(network, addr string) (net.Conn, error) {
+				return net.DialTimeout(network, addr, timeout)
+			},
+			ResponseHeaderTimeout: timeout,
+		},
+	}
+
+	resp, err := client.Post(url, "text/plain", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("POST %s: HTTP %d", url, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// preStopExec runs the hook's command in the container, with its output
+// going to the container's log, and expects it to exit zero.
+func preStopExec(c Container, args []string) error {
+	status, err := c.Exec(args, nil, os.Stdout, os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	if status != 0 {
+		return fmt.Errorf("%s: exit status %d", args[0], status)
+	}
+
+	return nil
+}
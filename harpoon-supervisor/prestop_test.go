@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/harpoon/harpoon-agent/lib"
+)
+
+func TestStopSignal(t *testing.T) {
+	for input, want := range map[string]syscall.Signal{
+		"":      syscall.SIGTERM,
+		"QUIT":  syscall.SIGQUIT,
+		"bogus": syscall.SIGTERM,
+	} {
+		if have := stopSignal(agent.ContainerConfig{StopSignal: input}); want != have {
+			t.Errorf("%q: want %s, have %s", input, want, have)
+		}
+	}
+}
+
+func TestPreStopHTTP(t *testing.T) {
+	var (
+		drained = make(chan string, 1)
+		status  = http.StatusOK
+	)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		drained <- r.Method + " " + r.URL.Path
+		w.WriteHeader(status)
+	}))
+	defer s.Close()
+
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(s.URL, "http://"))
+	p, _ := net.LookupPort("tcp", port)
+
+	container := newFakeContainer(agent.NoRestart)
+	container.config.Ports = map[string]uint16{"http": uint16(p)}
+	container.config.PreStop = &agent.PreStop{HTTPPort: "http", HTTPPath: "/-/drain"}
+
+	if status := runPreStop(container, time.Second); !status.Succeeded {
+		t.Fatalf("expected pre-stop hook to succeed, got %q", status.Err)
+	}
+
+	if want, have := "POST /-/drain", <-drained; want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+
+	status = http.StatusServiceUnavailable
+
+	if status := runPreStop(container, time.Second); status.Succeeded || status.Err == "" {
+		t.Errorf("expected pre-stop hook to fail, got %#v", status)
+	}
+}
@@ -13,11 +13,14 @@ type testSupervisor struct {
 	subscribec   chan chan<- agent.ContainerProcessState
 	unsubscribec chan chan<- agent.ContainerProcessState
 	stopc        chan os.Signal
+	terminatec   chan struct{}
+	signalc      chan os.Signal
 	exitc        chan struct{}
 	exited       chan struct{}
 }
 
-func (*testSupervisor) Run(metricsTick <-chan time.Time, restartTimer func() <-chan time.Time) {}
+func (*testSupervisor) Run(metricsTick <-chan time.Time, restartTimer func(time.Duration) <-chan time.Time) {
+}
 
 func (s *testSupervisor) Subscribe(c chan<- agent.ContainerProcessState) {
 	s.subscribec <- c
@@ -31,6 +34,14 @@ func (s *testSupervisor) Stop(sig os.Signal) {
 	s.stopc <- sig
 }
 
+func (s *testSupervisor) Terminate() {
+	s.terminatec <- struct{}{}
+}
+
+func (s *testSupervisor) Signal(sig os.Signal) {
+	s.signalc <- sig
+}
+
 func (s *testSupervisor) Exit() error {
 	s.exitc <- struct{}{}
 	return nil
@@ -45,6 +56,8 @@ func newTestSupervisor() *testSupervisor {
 		subscribec:   make(chan chan<- agent.ContainerProcessState, 1),
 		unsubscribec: make(chan chan<- agent.ContainerProcessState, 1),
 		stopc:        make(chan os.Signal, 1),
+		terminatec:   make(chan struct{}, 1),
+		signalc:      make(chan os.Signal, 1),
 		exitc:        make(chan struct{}, 1),
 		exited:       make(chan struct{}),
 	}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"errors"
+	"fmt"
+	"math/rand"
 	"os"
 	"time"
 
@@ -10,10 +12,18 @@ import (
 
 var errNotDown = errors.New("supervisor not down")
 
+// Defaults for zero durations in agent.Restart.
+const (
+	defaultRestartBackoff    = time.Second
+	defaultRestartMaxBackoff = time.Minute
+	defaultRestartWindow     = 10 * time.Minute
+)
+
 // A Supervisor manages a Container process.
 type Supervisor interface {
-	// Run starts the supervisor. It blocks until Exit is called.
-	Run(metricsTick <-chan time.Time, restartTimer func() <-chan time.Time)
+	// Run starts the supervisor. It blocks until Exit is called. Restarts are
+	// delayed by waiting on restartTimer.
+	Run(metricsTick <-chan time.Time, restartTimer func(time.Duration) <-chan time.Time)
 
 	Subscribe(chan<- agent.ContainerProcessState)
 	Unsubscribe(chan<- agent.ContainerProcessState)
@@ -22,6 +32,15 @@ type Supervisor interface {
 	// will not be restarted.
 	Stop(os.Signal)
 
+	// Terminate gracefully stops the supervised process: it runs the
+	// container's pre-stop hook, if any, and then sends it the container's
+	// stop signal. If the process exits it will not be restarted.
+	Terminate()
+
+	// Signal sends the signal to the supervised process, if it's up. Unlike
+	// Stop, it doesn't affect restarts.
+	Signal(os.Signal)
+
 	// Exit stops the supervisor. Exit returns an error if the supervised process
 	// has not been stopped.
 	Exit() error
@@ -37,6 +56,8 @@ type supervisor struct {
 	subscribec   chan chan<- agent.ContainerProcessState
 	unsubscribec chan chan<- agent.ContainerProcessState
 	downc        chan os.Signal
+	terminatec   chan struct{}
+	signalc      chan os.Signal
 	exitc        chan chan error
 	exited       chan struct{}
 }
@@ -48,6 +69,8 @@ func newSupervisor(c Container) Supervisor {
 		subscribec:   make(chan chan<- agent.ContainerProcessState),
 		unsubscribec: make(chan chan<- agent.ContainerProcessState),
 		downc:        make(chan os.Signal),
+		terminatec:   make(chan struct{}),
+		signalc:      make(chan os.Signal),
 		exitc:        make(chan chan error),
 		exited:       make(chan struct{}),
 	}
@@ -74,6 +97,20 @@ func (s *supervisor) Stop(sig os.Signal) {
 	}
 }
 
+func (s *supervisor) Terminate() {
+	select {
+	case s.terminatec <- struct{}{}:
+	case <-s.exited:
+	}
+}
+
+func (s *supervisor) Signal(sig os.Signal) {
+	select {
+	case s.signalc <- sig:
+	case <-s.exited:
+	}
+}
+
 func (s *supervisor) Exit() error {
 	c := make(chan error)
 
@@ -95,13 +132,35 @@ func (s *supervisor) Exited() <-chan struct{} {
 	return s.exited
 }
 
-func (s *supervisor) Run(metricsTick <-chan time.Time, restartTimer func() <-chan time.Time) {
+func (s *supervisor) Run(metricsTick <-chan time.Time, restartTimer func(time.Duration) <-chan time.Time) {
 	var (
 		state          agent.ContainerProcessState
 		containerExitc chan agent.ContainerExitStatus
 		restart        <-chan time.Time
+
+		config   = s.container.Config()
+		policy   = config.Restart
+		started  = time.Now()
+		backoffs = 0         // consecutive restarts
+		restarts []time.Time // within the window
+
+		preStopc     chan agent.PreStopStatus // while the pre-stop hook runs
+		preStopTimer <-chan time.Time
 	)
 
+	// preStopped records the outcome of the pre-stop hook, and stops the
+	// container if it's still up.
+	preStopped := func(status agent.PreStopStatus) {
+		preStopc, preStopTimer = nil, nil
+
+		state.PreStop = &status
+		s.broadcast(state)
+
+		if state.Up {
+			s.container.Signal(stopSignal(config))
+		}
+	}
+
 	defer close(s.exited)
 
 	if err := s.container.Start(); err != nil {
@@ -124,6 +183,9 @@ func (s *supervisor) Run(metricsTick <-chan time.Time, restartTimer func() <-cha
 				continue
 			}
 
+			started = time.Now()
+			restarts = append(restarts, started)
+
 			state.Up = true
 			state.Restarts++
 			state.ContainerExitStatus = agent.ContainerExitStatus{}
@@ -141,7 +203,7 @@ func (s *supervisor) Run(metricsTick <-chan time.Time, restartTimer func() <-cha
 			}
 
 			if exitStatus.Exited {
-				switch s.container.Config().Restart {
+				switch policy.Policy {
 				case agent.NoRestart:
 					state.Restarting = false
 				case agent.AlwaysRestart:
@@ -158,7 +220,23 @@ func (s *supervisor) Run(metricsTick <-chan time.Time, restartTimer func() <-cha
 			}
 
 			if state.Restarting {
-				restart = restartTimer()
+				restarts = since(restarts, time.Now().Add(-duration(policy.Window, defaultRestartWindow)))
+
+				if policy.MaxRestarts > 0 && len(restarts) >= policy.MaxRestarts {
+					state.Restarting = false
+					state.CrashLooping = true
+					state.Err = fmt.Sprintf("crash looping: restarted %d times within %s", len(restarts), duration(policy.Window, defaultRestartWindow))
+					metricsTick = nil
+				}
+			}
+
+			if state.Restarting {
+				if time.Since(started) > duration(policy.MaxBackoff, defaultRestartMaxBackoff) {
+					backoffs = 0 // it ran for a while; start over
+				}
+
+				restart = restartTimer(restartDelay(policy, backoffs, rand.Int63n))
+				backoffs++
 			}
 
 			s.broadcast(state)
@@ -179,6 +257,47 @@ func (s *supervisor) Run(metricsTick <-chan time.Time, restartTimer func() <-cha
 			restart = nil
 			s.broadcast(state)
 
+		case <-s.terminatec:
+			state.Restarting = false
+
+			if !state.Up {
+				metricsTick = nil
+				restart = nil
+				s.broadcast(state)
+				continue
+			}
+
+			if preStopc != nil {
+				continue // already terminating
+			}
+
+			if config.PreStop == nil {
+				s.container.Signal(stopSignal(config))
+				continue
+			}
+
+			timeout := preStopTimeout(config.Grace.Shutdown.Duration)
+
+			preStopc = make(chan agent.PreStopStatus, 1)
+			go func(c chan<- agent.PreStopStatus) { c <- runPreStop(s.container, timeout) }(preStopc)
+
+			if timeout > 0 {
+				preStopTimer = time.After(timeout)
+			}
+
+		case status := <-preStopc:
+			preStopped(status)
+
+		case <-preStopTimer:
+			preStopped(agent.PreStopStatus{
+				Err: fmt.Sprintf("timed out after %s", preStopTimeout(config.Grace.Shutdown.Duration)),
+			})
+
+		case sig := <-s.signalc:
+			if state.Up {
+				s.container.Signal(sig)
+			}
+
 		case c := <-s.subscribec:
 			s.subscribers[c] = struct{}{}
 			s.notify(c, state)
@@ -198,6 +317,47 @@ func (s *supervisor) Run(metricsTick <-chan time.Time, restartTimer func() <-cha
 	}
 }
 
+// restartDelay returns how long to wait before the nth consecutive restart
+// (n starting at 0): the backoff, doubled n times and capped at the max
+// backoff, then jittered to between half and all of that by random, so
+// containers which failed together don't restart in lockstep.
+func restartDelay(policy agent.Restart, n int, random func(int64) int64) time.Duration {
+	var (
+		d   = duration(policy.Backoff, defaultRestartBackoff)
+		max = duration(policy.MaxBackoff, defaultRestartMaxBackoff)
+	)
+
+	for i := 0; i < n && d < max; i++ {
+		d *= 2
+	}
+
+	if d > max {
+		d = max
+	}
+
+	return d/2 + time.Duration(random(int64(d/2)+1))
+}
+
+// since returns the suffix of times which are after t.
+func since(times []time.Time, t time.Time) []time.Time {
+	for i, u := range times {
+		if u.After(t) {
+			return times[i:]
+		}
+	}
+
+	return nil
+}
+
+// duration returns d, or def if d is zero.
+func duration(d agent.JSONDuration, def time.Duration) time.Duration {
+	if d.Duration == 0 {
+		return def
+	}
+
+	return d.Duration
+}
+
 // notify sends state to c, unless unsubscribe is called for c.
 func (s *supervisor) notify(c chan<- agent.ContainerProcessState, state agent.ContainerProcessState) {
 	for {
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"strings"
 	"syscall"
 	"testing"
 	"time"
@@ -22,7 +23,7 @@ func TestSupervisor(t *testing.T) {
 	)
 
 	go func() {
-		supervisor.Run(metricsTick, func() <-chan time.Time {
+		supervisor.Run(metricsTick, func(time.Duration) <-chan time.Time {
 			return restartTimer
 		})
 		done <- struct{}{}
@@ -178,6 +179,190 @@ func TestSupervisorStop(t *testing.T) {
 	}
 }
 
+func TestSupervisorTerminate(t *testing.T) {
+	var (
+		container  = newFakeContainer(agent.AlwaysRestart)
+		supervisor = newSupervisor(container)
+		statec     = make(chan agent.ContainerProcessState)
+
+		done = make(chan struct{}, 1)
+	)
+
+	container.config.StopSignal = "QUIT"
+	container.config.PreStop = &agent.PreStop{Exec: []string{"./drain"}}
+
+	go func() { supervisor.Run(nil, nil); done <- struct{}{} }()
+
+	container.startc <- nil
+
+	supervisor.Subscribe(statec)
+	defer supervisor.Unsubscribe(statec)
+
+	<-statec
+
+	supervisor.Terminate()
+
+	select {
+	case args := <-container.execc:
+		if want, have := "./drain", args[0]; want != have {
+			t.Fatalf("want pre-stop hook %q, have %q", want, have)
+		}
+	case <-time.After(10 * time.Millisecond):
+		panic("supervisor did not run pre-stop hook")
+	}
+
+	state := <-statec
+	if state.PreStop == nil || !state.PreStop.Succeeded {
+		t.Fatalf("expected successful pre-stop hook, got %#v", state.PreStop)
+	}
+
+	if state.Restarting {
+		t.Fatal("expected terminated container not to be restarted")
+	}
+
+	select {
+	case sig := <-container.signalc:
+		if sig != syscall.SIGQUIT {
+			t.Fatal("expected SIGQUIT, got ", sig)
+		}
+	case <-time.After(10 * time.Millisecond):
+		panic("supervisor did not send stop signal to container")
+	}
+
+	container.waitc <- agent.ContainerExitStatus{Signaled: true, Signal: int(syscall.SIGQUIT)}
+
+	if state := <-statec; state.Up || state.Restarting {
+		t.Fatalf("expected container to be down, got %#v", state)
+	}
+
+	if err := supervisor.Exit(); err != nil {
+		t.Fatalf("expected supervisor to exit, got %v", err)
+	}
+
+	<-done
+}
+
+func TestSupervisorPreStopTimeout(t *testing.T) {
+	var (
+		container  = newFakeContainer(agent.AlwaysRestart)
+		supervisor = newSupervisor(container)
+		statec     = make(chan agent.ContainerProcessState)
+		grace      = 100 * time.Millisecond
+
+		done = make(chan struct{}, 1)
+	)
+
+	container.execc = make(chan []string) // the hook hangs until received from
+	container.config.PreStop = &agent.PreStop{Exec: []string{"./drain"}}
+	container.config.Grace.Shutdown.Duration = grace
+
+	go func() { supervisor.Run(nil, nil); done <- struct{}{} }()
+
+	container.startc <- nil
+
+	supervisor.Subscribe(statec)
+	defer supervisor.Unsubscribe(statec)
+
+	<-statec
+
+	began := time.Now()
+	supervisor.Terminate()
+
+	state := <-statec
+	if state.PreStop == nil || state.PreStop.Succeeded || !strings.Contains(state.PreStop.Err, "timed out") {
+		t.Fatalf("expected pre-stop hook to time out, got %#v", state.PreStop)
+	}
+
+	select {
+	case sig := <-container.signalc:
+		if sig != syscall.SIGTERM {
+			t.Fatal("expected SIGTERM, got ", sig)
+		}
+	case <-time.After(grace):
+		panic("supervisor did not send stop signal to container")
+	}
+
+	// The stop signal has the rest of the grace period, before the agent
+	// kills the container.
+	if elapsed := time.Since(began); elapsed >= grace*3/4 {
+		t.Errorf("expected stop signal within half of the grace period, got it after %s", elapsed)
+	}
+
+	<-container.execc // the abandoned hook finishes
+
+	container.waitc <- agent.ContainerExitStatus{Signaled: true, Signal: int(syscall.SIGTERM)}
+
+	if state := <-statec; state.Up || state.Restarting {
+		t.Fatalf("expected container to be down, got %#v", state)
+	}
+
+	if err := supervisor.Exit(); err != nil {
+		t.Fatalf("expected supervisor to exit, got %v", err)
+	}
+
+	<-done
+}
+
+func TestSupervisorSignal(t *testing.T) {
+	var (
+		container  = newFakeContainer(agent.OnFailureRestart)
+		supervisor = newSupervisor(container)
+		statec     = make(chan agent.ContainerProcessState)
+		never      = func(time.Duration) <-chan time.Time { return nil }
+
+		done = make(chan struct{}, 1)
+	)
+
+	go func() { supervisor.Run(nil, never); done <- struct{}{} }()
+
+	select {
+	case container.startc <- nil:
+	case <-time.After(time.Millisecond):
+		panic("supervisor did not attempt to start container")
+	}
+
+	supervisor.Subscribe(statec)
+	defer supervisor.Unsubscribe(statec)
+
+	<-statec
+
+	supervisor.Signal(syscall.SIGHUP)
+
+	select {
+	case sig := <-container.signalc:
+		if sig != syscall.SIGHUP {
+			t.Fatal("expected SIGHUP, got ", sig)
+		}
+	case <-time.After(time.Millisecond):
+		panic("supervisor did not send SIGHUP signal to container")
+	}
+
+	// A signaled container which exits is still restarted.
+	container.waitc <- agent.ContainerExitStatus{Exited: true, ExitStatus: 1}
+
+	if state := <-statec; !state.Restarting {
+		t.Fatalf("expected container to be restarting after signal, got %#v", state)
+	}
+
+	// Signals aren't delivered while the container is down. The state update
+	// after Stop means the signal has been handled.
+	supervisor.Signal(syscall.SIGHUP)
+	supervisor.Stop(syscall.SIGTERM)
+	<-statec
+
+	select {
+	case sig := <-container.signalc:
+		t.Fatal("unexpected signal to down container: ", sig)
+	default:
+	}
+
+	if err := supervisor.Exit(); err != nil {
+		t.Fatalf("expected supervisor to exit, got %v", err)
+	}
+
+	<-done
+}
+
 func TestAlwaysRestartPolicy(t *testing.T) {
 	for exitStatus := 0; exitStatus < 2; exitStatus++ {
 		var (
@@ -189,7 +374,7 @@ func TestAlwaysRestartPolicy(t *testing.T) {
 		)
 
 		go func() {
-			supervisor.Run(nil, func() <-chan time.Time {
+			supervisor.Run(nil, func(time.Duration) <-chan time.Time {
 				return restartTimer
 			})
 			done <- struct{}{}
@@ -241,6 +426,107 @@ func TestAlwaysRestartPolicy(t *testing.T) {
 	}
 }
 
+func TestCrashLoop(t *testing.T) {
+	var (
+		container    = newFakeContainer(agent.AlwaysRestart)
+		supervisor   = newSupervisor(container)
+		statec       = make(chan agent.ContainerProcessState)
+		restartTimer = make(chan time.Time)
+		done         = make(chan struct{}, 1)
+	)
+
+	container.config.Restart.MaxRestarts = 2
+
+	go func() {
+		supervisor.Run(nil, func(time.Duration) <-chan time.Time {
+			return restartTimer
+		})
+		done <- struct{}{}
+	}()
+
+	select {
+	case container.startc <- nil:
+	case <-time.After(time.Millisecond):
+		panic("supervisor did not attempt to start container")
+	}
+
+	supervisor.Subscribe(statec)
+	defer supervisor.Unsubscribe(statec)
+
+	select {
+	case <-statec:
+	case <-time.After(time.Millisecond):
+		panic("supervisor did not send a state update")
+	}
+
+	var state agent.ContainerProcessState
+
+	for i := 0; i < 3; i++ {
+		select {
+		case container.waitc <- agent.ContainerExitStatus{Exited: true, ExitStatus: 1}:
+		case <-time.After(time.Millisecond):
+			panic("unable to send exit status")
+		}
+
+		select {
+		case state = <-statec:
+		case <-time.After(time.Millisecond):
+			panic("supervisor did not send a state update")
+		}
+
+		if i == 2 {
+			break // restarted twice already
+		}
+
+		if !state.Restarting {
+			t.Fatalf("exit %d: container should be restarting", i)
+		}
+
+		if _, err := waitRestart(restartTimer, container, statec, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if state.Restarting || !state.CrashLooping || state.Err == "" {
+		t.Fatalf("want crash looping container, have %+v", state)
+	}
+
+	if err := supervisor.Exit(); err != nil {
+		t.Fatalf("expected supervisor to exit, got %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Millisecond):
+		panic("supervisor did not terminate after exit")
+	}
+}
+
+func TestRestartDelay(t *testing.T) {
+	var (
+		policy = agent.Restart{
+			Backoff:    agent.JSONDuration{Duration: time.Second},
+			MaxBackoff: agent.JSONDuration{Duration: 5 * time.Second},
+		}
+		none = func(int64) int64 { return 0 }
+		all  = func(n int64) int64 { return n - 1 }
+	)
+
+	for n, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if have := restartDelay(policy, n, all); want != have {
+			t.Errorf("restart %d: want %s, have %s", n, want, have)
+		}
+
+		if want, have := want/2, restartDelay(policy, n, none); want != have {
+			t.Errorf("restart %d: want at least %s, have %s", n, want, have)
+		}
+	}
+
+	if want, have := defaultRestartBackoff, restartDelay(agent.Restart{}, 0, all); want != have {
+		t.Errorf("default: want %s, have %s", want, have)
+	}
+}
+
 func TestNoRestartPolicy(t *testing.T) {
 	for exitStatus := 0; exitStatus < 2; exitStatus++ {
 		var (
@@ -252,7 +538,7 @@ func TestNoRestartPolicy(t *testing.T) {
 		)
 
 		go func() {
-			supervisor.Run(nil, func() <-chan time.Time {
+			supervisor.Run(nil, func(time.Duration) <-chan time.Time {
 				return restartTimer
 			})
 			done <- struct{}{}
@@ -311,7 +597,7 @@ func TestOnFailureRestartPolicy(t *testing.T) {
 		)
 
 		go func() {
-			supervisor.Run(nil, func() <-chan time.Time {
+			supervisor.Run(nil, func(time.Duration) <-chan time.Time {
 				return restartTimer
 			})
 			done <- struct{}{}
@@ -1,6 +1,10 @@
 package main
 
-import "github.com/soundcloud/harpoon/harpoon-agent/lib"
+import (
+	"io"
+
+	"github.com/soundcloud/harpoon/harpoon-agent/lib"
+)
 
 type containers map[string]agent.ContainerInstance
 
@@ -85,6 +89,39 @@ func (c cluster) Stop(id string) error {
 	return agent.ErrContainerNotExist
 }
 
+func (c cluster) Signal(id, signal string) error {
+	for _, a := range c {
+		if err := a.Signal(id, signal); err != nil {
+			if err == agent.ErrContainerNotExist {
+				continue
+			}
+
+			return err
+		}
+
+		return nil
+	}
+
+	return agent.ErrContainerNotExist
+}
+
+func (c cluster) Exec(id string, cmd []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	for _, a := range c {
+		status, err := a.Exec(id, cmd, stdin, stdout, stderr)
+		if err != nil {
+			if err == agent.ErrContainerNotExist {
+				continue
+			}
+
+			return -1, err
+		}
+
+		return status, nil
+	}
+
+	return -1, agent.ErrContainerNotExist
+}
+
 func (c cluster) Delete(id string) error {
 	for _, a := range c {
 		if err := a.Delete(id); err != nil {
@@ -114,18 +114,19 @@ func (c *harpoonctl) ps(ctx *cli.Context) {
 
 	fmt.Fprintln(
 		c,
-		"AGENT	ID	COMMAND	STATUS",
+		"AGENT	ID	COMMAND	STATUS	HEALTH",
 	)
 
 	for a, cs := range containers {
 		for id, container := range cs {
 			fmt.Fprintf(
 				c,
-				"%s	%s	%s	%s\n",
+				"%s	%s	%s	%s	%s\n",
 				a,
 				id,
 				container.ContainerConfig.Command.Exec[0],
 				container.ContainerStatus,
+				container.HealthStatus,
 			)
 		}
 	}
@@ -257,7 +258,16 @@ func (*harpoonctl) wait(a agent.Agent, id string) {
 		}
 
 		switch status := container.ContainerStatus; status {
-		case agent.ContainerStatusRunning, agent.ContainerStatusFailed, agent.ContainerStatusFinished:
+		case agent.ContainerStatusRunning, agent.ContainerStatusFinished:
+			log.Println(status)
+			return
+
+		case agent.ContainerStatusFailed:
+			if container.ContainerProcessState.Err != "" {
+				log.Printf("%s: %s", status, container.ContainerProcessState.Err)
+				return
+			}
+
 			log.Println(status)
 			return
 		}
@@ -288,6 +298,36 @@ func (c *harpoonctl) stop(ctx *cli.Context) {
 	}
 }
 
+func (c *harpoonctl) signal(ctx *cli.Context) {
+	args := ctx.Args()
+	if len(args) != 2 {
+		log.Fatal("usage: harpoonctl signal <id> <signal>")
+	}
+	id, signal := args[0], args[1]
+
+	if err := c.cluster.Signal(id, signal); err != nil {
+		log.Fatal("unable to signal container: ", err)
+	}
+}
+
+func (c *harpoonctl) exec(ctx *cli.Context) {
+	args := ctx.Args()
+	if len(args) > 1 && args[1] == "--" {
+		args = append(args[:1:1], args[2:]...)
+	}
+	if len(args) < 2 {
+		log.Fatal("usage: harpoonctl exec <id> -- <cmd> [<args>...]")
+	}
+	id, cmd := args[0], args[1:]
+
+	status, err := c.cluster.Exec(id, cmd, os.Stdin, os.Stdout, os.Stderr)
+	if err != nil {
+		log.Fatal("unable to exec in container: ", err)
+	}
+
+	os.Exit(status)
+}
+
 func (c *harpoonctl) destroy(ctx *cli.Context) {
 	args := ctx.Args()
 	if len(args) != 1 {
@@ -317,3 +357,76 @@ func (c *harpoonctl) logs(ctx *cli.Context) {
 		log.Printf("%s", line)
 	}
 }
+
+func (c *harpoonctl) drain(ctx *cli.Context) {
+	args := ctx.Args()
+	if len(args) != 1 {
+		log.Fatal("usage: harpoonctl drain <agent>")
+	}
+
+	a, err := agent.NewClient(fmt.Sprintf("http://%s", args[0]))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := a.Drain(); err != nil {
+		log.Fatal("unable to drain agent: ", err)
+	}
+
+	events, stopper, err := a.Events()
+	if err != nil {
+		log.Fatal("unable to get event stream: ", err)
+	}
+	defer stopper.Stop()
+
+	// The scheduler moves the containers elsewhere; wait for the agent to be
+	// left with none to supervise.
+	var (
+		containers = map[string]agent.ContainerInstance{}
+		remaining  = -1
+	)
+
+	for event := range events {
+		for id, container := range event.Containers {
+			containers[id] = container
+		}
+
+		n := 0
+		for _, container := range containers {
+			switch container.ContainerStatus {
+			case agent.ContainerStatusPreparing, agent.ContainerStatusCreated, agent.ContainerStatusRunning:
+				n++
+			}
+		}
+
+		if n == 0 {
+			log.Println("drained")
+			return
+		}
+
+		if n != remaining {
+			log.Printf("draining: %d container(s) remaining", n)
+			remaining = n
+		}
+	}
+
+	log.Fatal("event stream closed")
+}
+
+func (c *harpoonctl) undrain(ctx *cli.Context) {
+	args := ctx.Args()
+	if len(args) != 1 {
+		log.Fatal("usage: harpoonctl undrain <agent>")
+	}
+
+	a, err := agent.NewClient(fmt.Sprintf("http://%s", args[0]))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := a.Undrain(); err != nil {
+		log.Fatal("unable to undrain agent: ", err)
+	}
+
+	log.Println("undrained")
+}
@@ -79,6 +79,16 @@ cluster (` + defaultCluster + `) is defined.`,
 				Usage:  "stop a container",
 				Action: harpoonctl.stop,
 			},
+			{
+				Name:   "signal",
+				Usage:  "send a signal to a container, e.g. HUP",
+				Action: harpoonctl.signal,
+			},
+			{
+				Name:   "exec",
+				Usage:  "run a command in a running container",
+				Action: harpoonctl.exec,
+			},
 			{
 				Name:   "start",
 				Usage:  "start a (stopped) container",
@@ -99,6 +109,16 @@ cluster (` + defaultCluster + `) is defined.`,
 				Usage:  "list agents and their resources",
 				Action: harpoonctl.resources,
 			},
+			{
+				Name:   "drain",
+				Usage:  "stop placing containers on an agent, and wait for it to be empty",
+				Action: harpoonctl.drain,
+			},
+			{
+				Name:   "undrain",
+				Usage:  "place containers on a drained agent again",
+				Action: harpoonctl.undrain,
+			},
 		},
 	}
 